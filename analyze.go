@@ -61,9 +61,11 @@ func VerifyFolder(folderPath string) ([]string, error) {
 		errors = append(errors, "appversion")
 	}
 
-	// Check unsupported large_image format
-	if strings.Contains(apiData.LargeImage, "svg") {
-		log.Printf("[ERROR] Unsupported large_image format in %s: svg\n", apiFilePath)
+	// Check the large_image is a valid PNG/JPEG data URI
+	if len(apiData.LargeImage) == 0 {
+		log.Printf("[WARNING] No large_image in %s. Add one with 'shufflecli app image set %s <imagefile>'\n", apiFilePath, folderPath)
+	} else if err := validateLargeImage(apiData.LargeImage); err != nil {
+		log.Printf("[ERROR] Bad large_image in %s: %s\n", apiFilePath, err)
 		errors = append(errors, "image")
 	}
 
@@ -73,6 +75,26 @@ func VerifyFolder(folderPath string) ([]string, error) {
 		errors = append(errors, "actions")
 	}
 
+	// Static security checks of app.py
+	findings, err := lintPythonSecurity(pythonFilePath)
+	if err != nil {
+		log.Printf("[ERROR] Problem running security checks on %s: %s", pythonFilePath, err)
+	}
+
+	logFindings(findings)
+	if hasErrorFindings(findings) {
+		errors = append(errors, "security")
+	}
+
+	// Validate requirements.txt against the SDK and the imports in app.py
+	requirementErrors, err := checkRequirements(folderPath)
+	if err != nil {
+		log.Printf("[ERROR] Problem checking requirements: %s", err)
+		errors = append(errors, "requirements")
+	}
+
+	errors = append(errors, requirementErrors...)
+
 	fmt.Println()
 
 	return errors, nil
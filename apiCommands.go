@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"log"
 	"fmt"
 	"bytes"
@@ -35,6 +36,7 @@ func GetWorkflow(workflowId string) (shuffle.Workflow, error) {
 		req.Header.Add("Authorization", "Bearer "+apikey)
 	}
 
+	setOrgHeader(req, "")
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR] Failed to send request: %v\n", err)
@@ -89,6 +91,7 @@ func UploadWorkflow(workflow shuffle.Workflow) error {
 		req.Header.Add("Authorization", "Bearer "+apikey)
 	}
 
+	setOrgHeader(req, "")
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR] Failed to send request: %v\n", err)
@@ -121,3 +124,266 @@ func UploadWorkflow(workflow shuffle.Workflow) error {
 
 	return nil
 }
+
+// setOrgHeader makes the request act in an org. Without requestOrgId it
+// uses the org from SHUFFLE_ORGID or the active profile.
+func setOrgHeader(req *http.Request, requestOrgId string) {
+	if len(requestOrgId) == 0 && hasOrgId() {
+		requestOrgId = orgId
+	}
+
+	if len(requestOrgId) > 0 {
+		req.Header.Set("Org-Id", requestOrgId)
+	}
+}
+
+// apiRequest sends a request to the Shuffle API and returns the body of a
+// 200 response. JSON bodies are marshalled unless they are already []byte.
+func apiRequest(method, path string, body interface{}) ([]byte, error) {
+	return apiRequestInOrg(method, path, body, "")
+}
+
+// apiRequestInOrg is apiRequest acting in another org the user is in, like
+// a sub-organization
+func apiRequestInOrg(method, path string, body interface{}, requestOrgId string) ([]byte, error) {
+	url := fmt.Sprintf("%s%s", uploadUrl, path)
+
+	var payload io.Reader
+	if data, ok := body.([]byte); ok {
+		payload = bytes.NewReader(data)
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		payload = bytes.NewReader(data)
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		method,
+		url,
+		payload,
+	)
+
+	if err != nil {
+		log.Printf("[ERROR] Failed to create request: %v\n", err)
+		return nil, err
+	}
+
+	if strings.HasPrefix(apikey, "Bearer ") {
+		req.Header.Add("Authorization", apikey)
+	} else {
+		req.Header.Add("Authorization", "Bearer "+apikey)
+	}
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	setOrgHeader(req, requestOrgId)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errServerFailed, err)
+	}
+
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return respBody, statusError(resp.StatusCode, resp.Status, respBody)
+	}
+
+	return respBody, nil
+}
+
+// GetApps lists the apps the user has access to
+func GetApps() ([]shuffle.WorkflowApp, error) {
+	apps := []shuffle.WorkflowApp{}
+	body, err := apiRequest("GET", "/api/v1/apps", nil)
+	if err != nil {
+		return apps, err
+	}
+
+	if err := json.Unmarshal(body, &apps); err != nil {
+		log.Printf("[ERROR] Failed to unmarshal response: %v\n", err)
+		return apps, err
+	}
+
+	return apps, nil
+}
+
+// GetAppConfig gets the full app and, for apps from the app creator, the
+// OpenAPI spec it was generated from
+func GetAppConfig(appId string) (shuffle.WorkflowApp, shuffle.ParsedOpenApi, error) {
+	app := shuffle.WorkflowApp{}
+	openapi := shuffle.ParsedOpenApi{}
+	body, err := apiRequest("GET", fmt.Sprintf("/api/v1/apps/%s/config", appId), nil)
+	if err != nil {
+		return app, openapi, err
+	}
+
+	config := shuffle.AppParser{}
+	if err := json.Unmarshal(body, &config); err != nil {
+		log.Printf("[ERROR] Failed to unmarshal response: %v\n", err)
+		return app, openapi, err
+	}
+
+	if !config.Success || len(config.App) == 0 {
+		return app, openapi, fmt.Errorf("No app config for %s: %s", appId, string(body))
+	}
+
+	if err := json.Unmarshal(config.App, &app); err != nil {
+		return app, openapi, err
+	}
+
+	if len(config.OpenAPI) > 0 {
+		if err := json.Unmarshal(config.OpenAPI, &openapi); err != nil {
+			return app, openapi, err
+		}
+	}
+
+	return app, openapi, nil
+}
+
+// DeleteApp removes an app the user owns
+func DeleteApp(appId string) error {
+	_, err := apiRequest("DELETE", fmt.Sprintf("/api/v1/apps/%s", appId), nil)
+	return err
+}
+
+// SetAppActivated enables or disables an app for the org. An empty
+// activateOrgId means the user's active org.
+func SetAppActivated(appId string, activate bool, activateOrgId string) error {
+	action := "deactivate"
+	if activate {
+		action = "activate"
+	}
+
+	_, err := apiRequestInOrg("GET", fmt.Sprintf("/api/v1/apps/%s/%s", appId, action), nil, activateOrgId)
+	return err
+}
+
+// UpdateAppSharing changes who can see an app. The backend sets all three
+// fields, so the current values have to be sent for the ones not changing.
+func UpdateAppSharing(appId string, sharing bool, sharingConfig string, public bool) error {
+	_, err := apiRequest("PATCH", fmt.Sprintf("/api/v1/apps/%s", appId), map[string]interface{}{
+		"sharing":        sharing,
+		"sharing_config": sharingConfig,
+		"public":         public,
+	})
+
+	return err
+}
+
+// GetOrgs lists the orgs the user is in
+func GetOrgs() ([]shuffle.OrgMini, error) {
+	orgs := []shuffle.OrgMini{}
+	body, err := apiRequest("GET", "/api/v1/orgs", nil)
+	if err != nil {
+		return orgs, err
+	}
+
+	return orgs, json.Unmarshal(body, &orgs)
+}
+
+// CreateSubOrg makes a sub-organization of parentOrgId
+func CreateSubOrg(parentOrgId, name string) error {
+	_, err := apiRequestInOrg("POST", fmt.Sprintf("/api/v1/orgs/%s/create_sub_org", parentOrgId), map[string]string{
+		"org_id": parentOrgId,
+		"name":   name,
+	}, parentOrgId)
+
+	return err
+}
+
+// GetOrgUsers lists the users in the active org
+func GetOrgUsers() ([]shuffle.User, error) {
+	users := []shuffle.User{}
+	body, err := apiRequest("GET", "/api/v1/getusers", nil)
+	if err != nil {
+		return users, err
+	}
+
+	return users, json.Unmarshal(body, &users)
+}
+
+// InviteUser adds a user to the active org by email
+func InviteUser(username string) error {
+	_, err := apiRequest("POST", "/api/v1/users/register", map[string]string{
+		"username": username,
+	})
+
+	return err
+}
+
+// RemoveUser removes a user from the active org
+func RemoveUser(userId string) error {
+	_, err := apiRequest("DELETE", fmt.Sprintf("/api/v1/users/%s", userId), nil)
+	return err
+}
+
+// SetUserRole changes a user's role in the active org
+func SetUserRole(userId, role string) error {
+	_, err := apiRequest("PUT", "/api/v1/users/updateuser", map[string]string{
+		"user_id": userId,
+		"role":    role,
+	})
+
+	return err
+}
+
+// GetAppAuths lists the app authentications in the active org
+func GetAppAuths() ([]shuffle.AppAuthenticationStorage, error) {
+	auths := struct {
+		Success bool                               `json:"success"`
+		Data    []shuffle.AppAuthenticationStorage `json:"data"`
+	}{}
+
+	body, err := apiRequest("GET", "/api/v1/apps/authentication", nil)
+	if err != nil {
+		return auths.Data, err
+	}
+
+	if err := json.Unmarshal(body, &auths); err != nil {
+		return auths.Data, err
+	}
+
+	return auths.Data, nil
+}
+
+// SetAppAuth adds an app authentication, or updates it if the ID is set.
+// Returns the ID of the authentication.
+func SetAppAuth(auth shuffle.AppAuthenticationStorage) (string, error) {
+	body, err := apiRequest("PUT", "/api/v1/apps/authentication", auth)
+	if err != nil {
+		return "", err
+	}
+
+	result := struct {
+		Success bool   `json:"success"`
+		Reason  string `json:"reason"`
+		Id      string `json:"id"`
+	}{}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+
+	if !result.Success {
+		return "", fmt.Errorf("Failed to add authentication: %s", result.Reason)
+	}
+
+	return result.Id, nil
+}
+
+// DeleteAppAuth removes an app authentication
+func DeleteAppAuth(authId string) error {
+	_, err := apiRequest("DELETE", fmt.Sprintf("/api/v1/apps/authentication/%s", authId), nil)
+	return err
+}
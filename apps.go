@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+)
+
+var outputFormat string
+var filterName string
+var filterVersion string
+var filterSharing string
+var shareOrgs []string
+var uploadPublic bool
+
+// appListing is the summary of an app shown by list and search
+type appListing struct {
+	Name      string `json:"name" yaml:"name"`
+	Version   string `json:"version" yaml:"version"`
+	ID        string `json:"id" yaml:"id"`
+	Sharing   string `json:"sharing" yaml:"sharing"`
+	Activated bool   `json:"activated" yaml:"activated"`
+	Updated   string `json:"updated" yaml:"updated"`
+}
+
+// appSharing is public for the app library, shared for apps shared with
+// other orgs and private otherwise
+func appSharing(app shuffle.WorkflowApp) string {
+	if app.Public {
+		return "public"
+	}
+
+	if app.Sharing {
+		return "shared"
+	}
+
+	return "private"
+}
+
+func newAppListing(app shuffle.WorkflowApp) appListing {
+	updated := app.Edited
+	if updated == 0 {
+		updated = app.Created
+	}
+
+	listing := appListing{Name: app.Name, Version: app.AppVersion, ID: app.ID, Sharing: appSharing(app), Activated: app.Activated}
+	if updated > 0 {
+		listing.Updated = time.Unix(updated, 0).UTC().Format("2006-01-02 15:04")
+	}
+
+	return listing
+}
+
+// filterApps applies --name, --version and --sharing
+func filterApps(apps []shuffle.WorkflowApp) []shuffle.WorkflowApp {
+	filtered := []shuffle.WorkflowApp{}
+	for _, app := range apps {
+		if len(filterName) > 0 && !strings.Contains(strings.ToLower(app.Name), strings.ToLower(filterName)) {
+			continue
+		}
+
+		if len(filterVersion) > 0 && app.AppVersion != filterVersion {
+			continue
+		}
+
+		if len(filterSharing) > 0 && appSharing(app) != strings.ToLower(filterSharing) {
+			continue
+		}
+
+		filtered = append(filtered, app)
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		if strings.EqualFold(filtered[i].Name, filtered[j].Name) {
+			return comparePythonVersions(filtered[i].AppVersion, filtered[j].AppVersion) > 0
+		}
+
+		return strings.ToLower(filtered[i].Name) < strings.ToLower(filtered[j].Name)
+	})
+
+	return filtered
+}
+
+func printApps(apps []shuffle.WorkflowApp) error {
+	listings := []appListing{}
+	rows := [][]string{}
+	for _, app := range apps {
+		listing := newAppListing(app)
+		listings = append(listings, listing)
+
+		active := "yes"
+		if !listing.Activated {
+			active = "no"
+		}
+
+		rows = append(rows, []string{listing.Name, listing.Version, listing.ID, listing.Sharing, active, listing.Updated})
+	}
+
+	return printFormatted(outputFormat, listings, []string{"NAME", "VERSION", "ID", "SHARING", "ACTIVE", "UPDATED"}, rows)
+}
+
+// requireApikey exits with the auth failure code if no apikey is set
+func requireApikey() {
+	if len(apikey) <= 0 {
+		fmt.Println("Please set the SHUFFLE_APIKEY or SHUFFLE_AUTHORIZATION environment variables to help with upload/download.")
+		os.Exit(exitAuthFailed)
+	}
+}
+
+var listApps = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the apps in your org",
+	Run: func(cmd *cobra.Command, args []string) {
+		requireApikey()
+		apps, err := GetApps()
+		if err != nil {
+			log.Printf("[ERROR] Problem listing apps: %s", err)
+			os.Exit(uploadExitCode(err))
+		}
+
+		if err := printApps(filterApps(apps)); err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var deleteAppCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Deletes an app: delete <id>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No app ID provided.")
+			return
+		}
+
+		requireApikey()
+		if !assumeYes && !isInteractive() {
+			log.Println("[ERROR] Use --yes to delete an app without a terminal.")
+			os.Exit(1)
+		}
+
+		if !confirm(fmt.Sprintf("Delete app %s? This can't be undone.", args[0]), false) {
+			log.Println("[INFO] Not deleting.")
+			return
+		}
+
+		if err := DeleteApp(args[0]); err != nil {
+			log.Printf("[ERROR] Problem deleting app %s: %s", args[0], err)
+			os.Exit(uploadExitCode(err))
+		}
+
+		log.Printf("[INFO] Deleted app %s", args[0])
+	},
+}
+
+// activationCommand makes the activate and deactivate commands
+func activationCommand(activate bool) *cobra.Command {
+	name, short, done := "deactivate", "Disables an app for your org: deactivate <id>", "Deactivated"
+	if activate {
+		name, short, done = "activate", "Enables an app for your org: activate <id>", "Activated"
+	}
+
+	return &cobra.Command{
+		Use:   name,
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) <= 0 {
+				log.Println("[ERROR] No app ID provided.")
+				return
+			}
+
+			requireApikey()
+			if err := SetAppActivated(args[0], activate, ""); err != nil {
+				log.Printf("[ERROR] Problem trying to %s app %s: %s", name, args[0], err)
+				os.Exit(uploadExitCode(err))
+			}
+
+			log.Printf("[INFO] %s app %s", done, args[0])
+		},
+	}
+}
+
+// setAppPublic publishes an app to the public app library, or makes it
+// private again. Sharing with other orgs is left as it was.
+func setAppPublic(appId string, public bool) error {
+	app, _, err := GetAppConfig(appId)
+	if err != nil {
+		return err
+	}
+
+	sharingConfig := "private"
+	if public {
+		sharingConfig = "public"
+	}
+
+	return UpdateAppSharing(app.ID, app.Sharing, sharingConfig, public)
+}
+
+// shareApp makes an app shareable and activates it in each org, usually
+// sub-organizations of the user's org
+func shareApp(appId string, orgIds []string) error {
+	app, _, err := GetAppConfig(appId)
+	if err != nil {
+		return err
+	}
+
+	if !app.Sharing && !app.Public {
+		log.Printf("[INFO] Enabling sharing for %s so other orgs can activate it. On-premises this also makes it visible to every org on the instance.", app.Name)
+		if err := UpdateAppSharing(app.ID, true, app.SharingConfig, app.Public); err != nil {
+			return err
+		}
+	}
+
+	for _, shareOrgId := range orgIds {
+		if err := SetAppActivated(app.ID, true, shareOrgId); err != nil {
+			return fmt.Errorf("problem activating for org %s: %w", shareOrgId, err)
+		}
+
+		log.Printf("[INFO] Shared %s with org %s", app.Name, shareOrgId)
+	}
+
+	return nil
+}
+
+// shareUploadedApp applies --public and --share-with after an upload
+func shareUploadedApp(folderPath string) error {
+	if !uploadPublic && len(shareOrgs) == 0 {
+		return nil
+	}
+
+	apiData, err := parseAPIYaml(filepath.Join(folderPath, "api.yaml"))
+	if err != nil {
+		return err
+	}
+
+	apps, err := GetApps()
+	if err != nil {
+		return err
+	}
+
+	app, err := findApp(apps, apiData.Name, apiData.AppVersion)
+	if err != nil {
+		return fmt.Errorf("can't find the uploaded app: %s", err)
+	}
+
+	if uploadPublic {
+		if err := setAppPublic(app.ID, true); err != nil {
+			return err
+		}
+
+		log.Printf("[INFO] Published %s %s (%s)", app.Name, app.AppVersion, app.ID)
+	}
+
+	if len(shareOrgs) > 0 {
+		return shareApp(app.ID, shareOrgs)
+	}
+
+	return nil
+}
+
+// publishCommand makes the publish and unpublish commands
+func publishCommand(public bool) *cobra.Command {
+	name, short, done := "unpublish", "Makes a public app private again: unpublish <id>", "Unpublished"
+	if public {
+		name, short, done = "publish", "Makes an app public in the app library: publish <id>", "Published"
+	}
+
+	return &cobra.Command{
+		Use:   name,
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) <= 0 {
+				log.Println("[ERROR] No app ID provided.")
+				return
+			}
+
+			requireApikey()
+			if err := setAppPublic(args[0], public); err != nil {
+				log.Printf("[ERROR] Problem trying to %s app %s: %s", name, args[0], err)
+				os.Exit(uploadExitCode(err))
+			}
+
+			log.Printf("[INFO] %s app %s", done, args[0])
+		},
+	}
+}
+
+var shareAppCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Shares an app with other orgs: share <id> --org <child org id>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No app ID provided.")
+			return
+		}
+
+		if len(shareOrgs) == 0 {
+			log.Println("[ERROR] Use --org with the ID of the org to share with.")
+			os.Exit(1)
+		}
+
+		requireApikey()
+		if err := shareApp(args[0], shareOrgs); err != nil {
+			log.Printf("[ERROR] Problem sharing app %s: %s", args[0], err)
+			os.Exit(uploadExitCode(err))
+		}
+	},
+}
+
+func init() {
+	appCmd.AddCommand(listApps)
+	appCmd.AddCommand(deleteAppCmd)
+	appCmd.AddCommand(activationCommand(true))
+	appCmd.AddCommand(activationCommand(false))
+	appCmd.AddCommand(publishCommand(true))
+	appCmd.AddCommand(publishCommand(false))
+	appCmd.AddCommand(shareAppCmd)
+
+	listApps.Flags().StringVar(&outputFormat, "format", "table", "Output format: table, json or yaml")
+	listApps.Flags().StringVar(&filterName, "name", "", "Only apps with this in the name")
+	listApps.Flags().StringVar(&filterVersion, "version", "", "Only this app version")
+	listApps.Flags().StringVar(&filterSharing, "sharing", "", "Only apps that are public, shared or private")
+
+	shareAppCmd.Flags().StringSliceVar(&shareOrgs, "org", nil, "Org ID to share with. Can be repeated or comma separated")
+	deleteAppCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Don't ask for confirmation")
+}
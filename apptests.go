@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tests.yaml lives next to api.yaml and describes expected action behaviour
+const appTestsFile = "tests.yaml"
+
+var testReportPath string
+
+type AppTestFile struct {
+	Tests []AppTestCase `yaml:"tests"`
+}
+
+// AppTestCase runs one action and checks the result. Cassette is an optional
+// recording from 'app exec --record' to answer the action's HTTP requests.
+type AppTestCase struct {
+	Name       string            `yaml:"name"`
+	Action     string            `yaml:"action"`
+	Parameters map[string]string `yaml:"parameters"`
+	Cassette   string            `yaml:"cassette"`
+	Expect     AppTestExpect     `yaml:"expect"`
+}
+
+// AppTestExpect holds the assertions. Everything that is set has to pass.
+type AppTestExpect struct {
+	Success     *bool             `yaml:"success"`
+	Equals      *string           `yaml:"equals"`
+	Contains    string            `yaml:"contains"`
+	Regex       string            `yaml:"regex"`
+	Status      string            `yaml:"status"`
+	JSONPath    map[string]string `yaml:"json"`
+	MaxDuration string            `yaml:"max_duration"`
+}
+
+func loadAppTests(folderPath string) ([]AppTestCase, error) {
+	data, err := ioutil.ReadFile(filepath.Join(folderPath, appTestsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	testFile := AppTestFile{}
+	if err := yaml.Unmarshal(data, &testFile); err != nil {
+		return nil, fmt.Errorf("Problem parsing %s: %w", appTestsFile, err)
+	}
+
+	for index, testCase := range testFile.Tests {
+		if len(testCase.Action) == 0 {
+			return nil, fmt.Errorf("test %d in %s has no action", index+1, appTestsFile)
+		}
+
+		if testCase.Parameters == nil {
+			testFile.Tests[index].Parameters = map[string]string{}
+		}
+
+		if len(testCase.Name) == 0 {
+			testFile.Tests[index].Name = fmt.Sprintf("%s #%d", testCase.Action, index+1)
+		}
+	}
+
+	return testFile.Tests, nil
+}
+
+// checkExpectations returns every assertion that failed
+func checkExpectations(expect AppTestExpect, result ActionRunResult) []string {
+	failures := []string{}
+	expectSuccess := expect.Success == nil || *expect.Success
+	if result.Success != expectSuccess {
+		failures = append(failures, fmt.Sprintf("success was %t, expected %t. %s", result.Success, expectSuccess, result.Error))
+		return failures
+	}
+
+	if expect.Equals != nil && !sameResult(*expect.Equals, result.Result) {
+		failures = append(failures, fmt.Sprintf("result '%s' doesn't equal '%s'", result.Result, *expect.Equals))
+	}
+
+	if len(expect.Contains) > 0 && !strings.Contains(result.Result, expect.Contains) {
+		failures = append(failures, fmt.Sprintf("result doesn't contain '%s'", expect.Contains))
+	}
+
+	if len(expect.Regex) > 0 {
+		matched, err := regexp.MatchString(expect.Regex, result.Result)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("bad regex '%s': %s", expect.Regex, err))
+		} else if !matched {
+			failures = append(failures, fmt.Sprintf("result doesn't match /%s/", expect.Regex))
+		}
+	}
+
+	jsonPaths := map[string]string{}
+	for path, value := range expect.JSONPath {
+		jsonPaths[path] = value
+	}
+
+	// status is shorthand for the status field most Shuffle apps return
+	if len(expect.Status) > 0 {
+		jsonPaths["status"] = expect.Status
+	}
+
+	paths := []string{}
+	for path := range jsonPaths {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	for _, path := range paths {
+		expected := jsonPaths[path]
+		actual, found := lookupJSONPath(result.Result, path)
+		if !found {
+			failures = append(failures, fmt.Sprintf("%s not found in result", path))
+		} else if !sameResult(expected, actual) {
+			failures = append(failures, fmt.Sprintf("%s is '%s', expected '%s'", path, actual, expected))
+		}
+	}
+
+	if len(expect.MaxDuration) > 0 {
+		maxDuration, err := time.ParseDuration(expect.MaxDuration)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("bad max_duration '%s': %s", expect.MaxDuration, err))
+		} else if result.Duration > maxDuration.Seconds() {
+			failures = append(failures, fmt.Sprintf("took %.2fs, max is %s", result.Duration, maxDuration))
+		}
+	}
+
+	return failures
+}
+
+// lookupJSONPath finds a dotted path (e.g. body.items.#0.id) in a JSON result
+func lookupJSONPath(data, path string) (string, bool) {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(data), &parsed); err != nil {
+		return "", false
+	}
+
+	value := walkJSONPath(parsed, strings.Split(strings.TrimPrefix(path, "$."), "."))
+	if value == nil {
+		return "", false
+	}
+
+	return jsonValueToString(value), true
+}
+
+// runAppTests runs the cases in tests.yaml in parallel and returns the results
+// in the order of the file
+func runAppTests(folderPath string) ([]CaseResult, error) {
+	testCases, err := loadAppTests(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]CaseResult, len(testCases))
+	limit := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for index, testCase := range testCases {
+		wg.Add(1)
+		go func(index int, testCase AppTestCase) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			caseResult := CaseResult{Name: testCase.Name, Source: appTestsFile, Action: testCase.Action}
+			var result ActionRunResult
+			var problems []string
+			var err error
+			if len(testCase.Cassette) > 0 {
+				result, problems, err = replayAction(folderPath, testCase.Action, testCase.Parameters, filepath.Join(folderPath, testCase.Cassette))
+			} else {
+				result, err = runAppAction(folderPath, testCase.Action, testCase.Parameters, "")
+			}
+
+			caseResult.Duration = result.Duration
+			if err != nil {
+				problems = append(problems, err.Error())
+			} else {
+				problems = append(problems, checkExpectations(testCase.Expect, result)...)
+			}
+
+			caseResult.Failures = problems
+			caseResult.Passed = len(problems) == 0
+			results[index] = caseResult
+		}(index, testCase)
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+// logCaseResults prints pass/fail per case and errors if any failed
+func logCaseResults(results []CaseResult) error {
+	failed := []string{}
+	for _, result := range results {
+		if result.Passed {
+			log.Printf("[INFO] PASS %s (%s) in %.2fs", result.Name, result.Action, result.Duration)
+			continue
+		}
+
+		failed = append(failed, result.Name)
+		log.Printf("[ERROR] FAIL %s (%s): %s", result.Name, result.Action, strings.Join(result.Failures, "; "))
+	}
+
+	log.Printf("[INFO] Test cases: %d passed, %d failed", len(results)-len(failed), len(failed))
+	if len(failed) > 0 {
+		return fmt.Errorf("test cases failed: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// runAppTestCases runs tests.yaml if it exists, and the fixtures if asked for
+func runAppTestCases(folderPath string) ([]CaseResult, error) {
+	results := []CaseResult{}
+	if _, err := os.Stat(filepath.Join(folderPath, appTestsFile)); err == nil {
+		log.Printf("[DEBUG] Running test cases from %s", appTestsFile)
+		caseResults, err := runAppTests(folderPath)
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, caseResults...)
+	}
+
+	if len(fixturesPath) > 0 {
+		fixtureResults, err := runFixtures(folderPath, fixturesPath)
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, fixtureResults...)
+	}
+
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	return results, logCaseResults(results)
+}
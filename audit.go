@@ -0,0 +1,625 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// OsvAdvisory is the subset of the OSV schema used for matching.
+// See https://ossf.github.io/osv-schema/
+type OsvAdvisory struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases"`
+	Summary  string   `json:"summary"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Versions []string `json:"versions"`
+		Ranges   []struct {
+			Type   string `json:"type"`
+			Events []struct {
+				Introduced   string `json:"introduced"`
+				Fixed        string `json:"fixed"`
+				LastAffected string `json:"last_affected"`
+			} `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+}
+
+// AuditedPackage is one resolved dependency with its findings
+type AuditedPackage struct {
+	Name            string
+	Version         string
+	License         string
+	LicenseClass    string
+	Vulnerabilities []OsvAdvisory
+}
+
+var auditDependencies bool
+var osvDatabasePath string
+
+// defaultOsvDatabasePath is where 'app audit import' puts the database
+func defaultOsvDatabasePath() string {
+	if len(os.Getenv("SHUFFLE_OSV_DB")) > 0 {
+		return os.Getenv("SHUFFLE_OSV_DB")
+	}
+
+	// Imports keep the type of the source, so look for each of them
+	basePath := osvDatabaseBasePath()
+	for _, candidate := range osvDatabaseCandidates(basePath) {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return basePath + ".zip"
+}
+
+// osvDatabaseBasePath is the imported database path without its extension
+func osvDatabaseBasePath() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "."
+	}
+
+	return filepath.Join(configDir, "shufflecli", "osv-pypi")
+}
+
+// osvDatabaseCandidates are the names an imported zip, JSON file or folder
+// is stored under
+func osvDatabaseCandidates(basePath string) []string {
+	return []string{basePath + ".zip", basePath + ".json", basePath}
+}
+
+// importOsvDatabaseFrom copies a zip, JSON file or folder of JSON advisories
+// to where audits read it from, replacing an earlier import. With exactPath
+// the copy is put at basePath without adding an extension.
+func importOsvDatabaseFrom(sourcePath, basePath string, exactPath bool) (string, error) {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(basePath), 0755); err != nil {
+		return "", err
+	}
+
+	candidates := osvDatabaseCandidates(basePath)
+	if exactPath {
+		candidates = []string{basePath}
+	}
+
+	for _, candidate := range candidates {
+		if err := os.RemoveAll(candidate); err != nil {
+			return "", err
+		}
+	}
+
+	if info.IsDir() {
+		err := filepath.Walk(sourcePath, func(path string, fileInfo os.FileInfo, err error) error {
+			if err != nil || fileInfo.IsDir() || !strings.HasSuffix(path, ".json") {
+				return err
+			}
+
+			relativePath, err := filepath.Rel(sourcePath, path)
+			if err != nil {
+				return err
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			targetPath := filepath.Join(basePath, relativePath)
+			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+				return err
+			}
+
+			return ioutil.WriteFile(targetPath, data, 0644)
+		})
+
+		return basePath, err
+	}
+
+	data, err := ioutil.ReadFile(sourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	targetPath := basePath
+	if !exactPath && strings.HasPrefix(string(data), "PK") {
+		targetPath = basePath + ".zip"
+	} else if !exactPath {
+		targetPath = basePath + ".json"
+	}
+
+	return targetPath, ioutil.WriteFile(targetPath, data, 0644)
+}
+
+// loadOsvDatabase reads PyPI advisories from an OSV export. Supports the zip
+// files from https://osv-vulnerabilities.storage.googleapis.com/PyPI/all.zip,
+// a folder of advisory JSON files or a single JSON file/array.
+func loadOsvDatabase(databasePath string) (map[string][]OsvAdvisory, error) {
+	advisories := map[string][]OsvAdvisory{}
+	addAdvisory := func(data []byte) {
+		data = []byte(strings.TrimSpace(string(data)))
+		parsed := []OsvAdvisory{}
+		if strings.HasPrefix(string(data), "[") {
+			if err := json.Unmarshal(data, &parsed); err != nil {
+				log.Printf("[WARNING] Skipping bad OSV advisory list: %s", err)
+				return
+			}
+		} else {
+			advisory := OsvAdvisory{}
+			if err := json.Unmarshal(data, &advisory); err != nil {
+				log.Printf("[WARNING] Skipping bad OSV advisory: %s", err)
+				return
+			}
+
+			parsed = append(parsed, advisory)
+		}
+
+		for _, advisory := range parsed {
+			for _, affected := range advisory.Affected {
+				if affected.Package.Ecosystem != "PyPI" {
+					continue
+				}
+
+				name := normalizePackageName(affected.Package.Name)
+				advisories[name] = append(advisories[name], advisory)
+			}
+		}
+	}
+
+	info, err := os.Stat(databasePath)
+	if err != nil {
+		return advisories, fmt.Errorf("no OSV database at %s. Import one with 'shufflecli app audit import <file>': %w", databasePath, err)
+	}
+
+	if info.IsDir() {
+		err = filepath.Walk(databasePath, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(path, ".json") {
+				return err
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			addAdvisory(data)
+			return nil
+		})
+
+		return advisories, err
+	}
+
+	data, err := ioutil.ReadFile(databasePath)
+	if err != nil {
+		return advisories, err
+	}
+
+	if !strings.HasPrefix(string(data), "PK") {
+		addAdvisory(data)
+		return advisories, nil
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return advisories, err
+	}
+
+	for _, file := range reader.File {
+		if !strings.HasSuffix(file.Name, ".json") {
+			continue
+		}
+
+		zipfile, err := file.Open()
+		if err != nil {
+			return advisories, err
+		}
+
+		fileData, err := io.ReadAll(zipfile)
+		zipfile.Close()
+		if err != nil {
+			return advisories, err
+		}
+
+		addAdvisory(fileData)
+	}
+
+	return advisories, nil
+}
+
+// isAffected checks if a version matches an advisory's version list or ranges
+func (advisory OsvAdvisory) isAffected(name, version string) bool {
+	for _, affected := range advisory.Affected {
+		if affected.Package.Ecosystem != "PyPI" || normalizePackageName(affected.Package.Name) != name {
+			continue
+		}
+
+		for _, affectedVersion := range affected.Versions {
+			if comparePythonVersions(affectedVersion, version) == 0 {
+				return true
+			}
+		}
+
+		for _, versionRange := range affected.Ranges {
+			if versionRange.Type != "ECOSYSTEM" {
+				continue
+			}
+
+			// Events are ordered: introduced opens a range, fixed or last_affected closes it
+			inRange := false
+			for _, event := range versionRange.Events {
+				if len(event.Introduced) > 0 && (event.Introduced == "0" || comparePythonVersions(version, event.Introduced) >= 0) {
+					inRange = true
+				}
+
+				if len(event.Fixed) > 0 && comparePythonVersions(version, event.Fixed) >= 0 {
+					inRange = false
+				}
+
+				if len(event.LastAffected) > 0 && comparePythonVersions(version, event.LastAffected) > 0 {
+					inRange = false
+				}
+			}
+
+			if inRange {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+var versionPartRegex = regexp.MustCompile(`^(\d+)(.*)$`)
+
+// comparePythonVersions compares two PEP 440 style versions. Good enough for
+// release numbers with a/b/rc/post/dev suffixes, not for local versions.
+func comparePythonVersions(a, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(strings.ToLower(a), "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(strings.ToLower(b), "v"), ".")
+
+	for index := 0; index < len(aParts) || index < len(bParts); index++ {
+		aPart, bPart := "", ""
+		if index < len(aParts) {
+			aPart = aParts[index]
+		}
+
+		if index < len(bParts) {
+			bPart = bParts[index]
+		}
+
+		aMatch := versionPartRegex.FindStringSubmatch(aPart)
+		bMatch := versionPartRegex.FindStringSubmatch(bPart)
+		switch {
+		case aMatch != nil && bMatch != nil:
+			if result := compareNumbers(aMatch[1], bMatch[1]); result != 0 {
+				return result
+			}
+
+			if result := compareVersionSuffix(aMatch[2], bMatch[2]); result != 0 {
+				return result
+			}
+		case aMatch != nil:
+			// 1.0.1 > 1.0.post1 > 1.0 == 1.0.0 > 1.0.dev1
+			if len(bPart) > 0 || compareNumbers(aMatch[1], "0") != 0 {
+				return 1
+			}
+		case bMatch != nil:
+			if len(aPart) > 0 || compareNumbers(bMatch[1], "0") != 0 {
+				return -1
+			}
+		default:
+			if result := compareVersionSuffix(aPart, bPart); result != 0 {
+				return result
+			}
+		}
+	}
+
+	return 0
+}
+
+func compareNumbers(a, b string) int {
+	aNumber, _ := strconv.Atoi(a)
+	bNumber, _ := strconv.Atoi(b)
+	if aNumber < bNumber {
+		return -1
+	} else if aNumber > bNumber {
+		return 1
+	}
+
+	return 0
+}
+
+// compareVersionSuffix orders dev < a < b < rc < release < post
+func compareVersionSuffix(a, b string) int {
+	rank := func(suffix string) (int, string) {
+		suffix = strings.TrimLeft(suffix, "-_.")
+		for index, prefix := range []string{"dev", "a", "b", "rc", "", "post"} {
+			if len(prefix) > 0 && strings.HasPrefix(suffix, prefix) {
+				return index, strings.TrimPrefix(suffix, prefix)
+			}
+		}
+
+		return 4, suffix
+	}
+
+	aRank, aRest := rank(a)
+	bRank, bRest := rank(b)
+	if aRank != bRank {
+		return compareNumbers(strconv.Itoa(aRank), strconv.Itoa(bRank))
+	}
+
+	_, aErr := strconv.Atoi(aRest)
+	_, bErr := strconv.Atoi(bRest)
+	if aErr == nil && bErr == nil {
+		return compareNumbers(aRest, bRest)
+	}
+
+	return strings.Compare(aRest, bRest)
+}
+
+// License names and SPDX IDs by class. Matched as whole words, so that "mit"
+// doesn't match "permitted" and "mpl" doesn't match "simplified".
+var (
+	permissiveLicenseRegex   = regexp.MustCompile(`\b(mit|0?bsd|apache|isc|psfl?|unlicense|zlib|python software foundation|public domain)\b`)
+	weakCopyleftLicenseRegex = regexp.MustCompile(`\b(lgpl(v?[0-9.]*)?|mpl|mozilla public|epl|eclipse public|lesser general public)\b`)
+	copyleftLicenseRegex     = regexp.MustCompile(`\b(a?gpl(v?[0-9.]*)?|sspl|general public license|affero)\b`)
+)
+
+// classifyLicense sorts a license string or trove classifier into permissive,
+// weak-copyleft, copyleft or unknown. Dual licenses with a permissive option
+// count as permissive.
+func classifyLicense(license string) string {
+	license = strings.ToLower(license)
+	switch {
+	case len(strings.TrimSpace(license)) == 0 || license == "unknown":
+		return "unknown"
+	case permissiveLicenseRegex.MatchString(license):
+		return "permissive"
+	case weakCopyleftLicenseRegex.MatchString(license):
+		return "weak-copyleft"
+	case copyleftLicenseRegex.MatchString(license):
+		return "copyleft"
+	}
+
+	return "unknown"
+}
+
+// installedPackageLicenses reads license metadata of installed python packages.
+// Packages that aren't installed locally get no entry.
+func installedPackageLicenses(names []string) map[string]string {
+	licenses := map[string]string{}
+	script := `
+import json, sys
+from importlib import metadata
+output = {}
+for name in sys.argv[1:]:
+    try:
+        meta = metadata.metadata(name)
+    except metadata.PackageNotFoundError:
+        continue
+    license = meta.get("License-Expression") or meta.get("License") or ""
+    if len(license) > 100 or license.strip().upper() in ("", "UNKNOWN"):
+        license = ", ".join([c.split("::")[-1].strip() for c in meta.get_all("Classifier") or [] if c.startswith("License ::")])
+    output[name] = license
+print(json.dumps(output))
+`
+
+	output, err := exec.Command("python3", append([]string{"-c", script}, names...)...).Output()
+	if err != nil {
+		log.Printf("[WARNING] Problem reading package licenses with python3: %s", err)
+		return licenses
+	}
+
+	if err := json.Unmarshal(output, &licenses); err != nil {
+		log.Printf("[WARNING] Problem parsing package licenses: %s", err)
+	}
+
+	return licenses
+}
+
+// resolveAppDependencies returns name -> version for an app. requirements.lock
+// from 'app test --lock' is preferred as it contains the transitive packages.
+func resolveAppDependencies(folderPath string) (map[string]string, error) {
+	requirementsPath := fmt.Sprintf("%s/requirements.lock", folderPath)
+	if _, err := os.Stat(requirementsPath); err != nil {
+		requirementsPath = fmt.Sprintf("%s/requirements.txt", folderPath)
+	}
+
+	log.Printf("[DEBUG] Resolving dependencies from %s", requirementsPath)
+	requirements, err := parseRequirementsFile(requirementsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies := map[string]string{}
+	for _, requirement := range requirements {
+		version := requirement.pinnedVersion()
+		if len(version) == 0 {
+			log.Printf("[WARNING] Can't audit unpinned requirement '%s'. Pin it or run 'shufflecli app test %s --lock'", requirement.Raw, folderPath)
+		}
+
+		dependencies[requirement.Name] = version
+	}
+
+	return dependencies, nil
+}
+
+// auditApp matches an app's dependencies against the OSV database and
+// classifies their licenses
+func auditApp(folderPath, databasePath string) ([]AuditedPackage, error) {
+	dependencies, err := resolveAppDependencies(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	advisories, err := loadOsvDatabase(databasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range dependencies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	licenses := installedPackageLicenses(names)
+	packages := []AuditedPackage{}
+	for _, name := range names {
+		auditedPackage := AuditedPackage{
+			Name:         name,
+			Version:      dependencies[name],
+			License:      licenses[name],
+			LicenseClass: classifyLicense(licenses[name]),
+		}
+
+		if len(auditedPackage.Version) > 0 {
+			for _, advisory := range advisories[name] {
+				if advisory.isAffected(name, auditedPackage.Version) {
+					auditedPackage.Vulnerabilities = append(auditedPackage.Vulnerabilities, advisory)
+				}
+			}
+		}
+
+		packages = append(packages, auditedPackage)
+	}
+
+	return packages, nil
+}
+
+// runDependencyAudit prints the audit and fails on known vulnerabilities or
+// copyleft licenses
+func runDependencyAudit(folderPath string) error {
+	databasePath := osvDatabasePath
+	if len(databasePath) == 0 {
+		databasePath = defaultOsvDatabasePath()
+	}
+
+	packages, err := auditApp(folderPath, databasePath)
+	if err != nil {
+		return err
+	}
+
+	vulnerable := 0
+	copyleft := 0
+	fmt.Printf("\n%-30s %-15s %-15s %s\n", "PACKAGE", "VERSION", "LICENSE", "VULNERABILITIES")
+	for _, auditedPackage := range packages {
+		ids := []string{}
+		for _, advisory := range auditedPackage.Vulnerabilities {
+			id := advisory.ID
+			for _, alias := range advisory.Aliases {
+				if strings.HasPrefix(alias, "CVE-") {
+					id = fmt.Sprintf("%s (%s)", alias, advisory.ID)
+					break
+				}
+			}
+
+			ids = append(ids, id)
+		}
+
+		version := auditedPackage.Version
+		if len(version) == 0 {
+			version = "unpinned"
+		}
+
+		fmt.Printf("%-30s %-15s %-15s %s\n", auditedPackage.Name, version, auditedPackage.LicenseClass, strings.Join(ids, ", "))
+		for _, advisory := range auditedPackage.Vulnerabilities {
+			log.Printf("[ERROR] %s==%s: %s %s", auditedPackage.Name, auditedPackage.Version, advisory.ID, advisory.Summary)
+		}
+
+		if len(auditedPackage.Vulnerabilities) > 0 {
+			vulnerable += 1
+		}
+
+		if auditedPackage.LicenseClass == "copyleft" {
+			log.Printf("[ERROR] %s is copyleft licensed: %s", auditedPackage.Name, auditedPackage.License)
+			copyleft += 1
+		}
+	}
+
+	fmt.Println()
+	if vulnerable > 0 || copyleft > 0 {
+		return fmt.Errorf("%d vulnerable and %d copyleft dependencies", vulnerable, copyleft)
+	}
+
+	log.Printf("[INFO] Audited %d dependencies without findings", len(packages))
+	return nil
+}
+
+var auditApps = &cobra.Command{
+	Use:   "audit",
+	Short: "Audits app dependencies for known vulnerabilities and licenses using a local OSV database",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No directory provided. Use the absolute path to the app directory.")
+			return
+		}
+
+		err := runDependencyAudit(args[0])
+		if err != nil {
+			log.Printf("[ERROR] Audit failed: %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var importOsvDatabase = &cobra.Command{
+	Use:   "import",
+	Short: "Imports an OSV PyPI database export (all.zip) for offline audits",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No file provided. Download https://osv-vulnerabilities.storage.googleapis.com/PyPI/all.zip first.")
+			return
+		}
+
+		advisories, err := loadOsvDatabase(args[0])
+		if err != nil {
+			log.Printf("[ERROR] Problem reading OSV database: %s", err)
+			return
+		}
+
+		// SHUFFLE_OSV_DB is used as is, otherwise the type of the source decides the name
+		databasePath := ""
+		if len(os.Getenv("SHUFFLE_OSV_DB")) > 0 {
+			databasePath, err = importOsvDatabaseFrom(args[0], os.Getenv("SHUFFLE_OSV_DB"), true)
+		} else {
+			databasePath, err = importOsvDatabaseFrom(args[0], osvDatabaseBasePath(), false)
+		}
+
+		if err != nil {
+			log.Printf("[ERROR] Problem importing %s: %s", args[0], err)
+			return
+		}
+
+		log.Printf("[INFO] Imported advisories for %d packages to %s", len(advisories), databasePath)
+	},
+}
+
+func init() {
+	auditApps.AddCommand(importOsvDatabase)
+	appCmd.AddCommand(auditApps)
+
+	auditApps.Flags().StringVar(&osvDatabasePath, "osv-db", "", "OSV database file or folder (default from SHUFFLE_OSV_DB or the imported one)")
+}
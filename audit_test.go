@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassifyLicense(t *testing.T) {
+	tests := []struct {
+		license  string
+		expected string
+	}{
+		{"MIT", "permissive"},
+		{"MIT License", "permissive"},
+		{"Simplified BSD", "permissive"},
+		{"BSD-3-Clause", "permissive"},
+		{"Apache-2.0", "permissive"},
+		{"Apache Software License", "permissive"},
+		{"Python Software Foundation License", "permissive"},
+		{"ISC License (ISCL)", "permissive"},
+		{"MIT OR GPL-2.0", "permissive"},
+		{"MPL-2.0", "weak-copyleft"},
+		{"Mozilla Public License 2.0 (MPL 2.0)", "weak-copyleft"},
+		{"GNU Lesser General Public License v3 or later (LGPLv3+)", "weak-copyleft"},
+		{"LGPL-2.1-or-later", "weak-copyleft"},
+		{"EPL-2.0", "weak-copyleft"},
+		{"GPLv3", "copyleft"},
+		{"GNU General Public License v2 (GPLv2)", "copyleft"},
+		{"AGPL-3.0-only", "copyleft"},
+		{"Use is permitted for anything", "unknown"},
+		{"Replaced by a commercial license", "unknown"},
+		{"", "unknown"},
+		{"UNKNOWN", "unknown"},
+	}
+
+	for _, test := range tests {
+		if result := classifyLicense(test.license); result != test.expected {
+			t.Errorf("classifyLicense(%q) = %q, expected %q", test.license, result, test.expected)
+		}
+	}
+}
+
+const testAdvisory = `{"id": "GHSA-test", "affected": [{"package": {"ecosystem": "PyPI", "name": "requests"}, "versions": ["2.31.0"]}]}`
+
+func TestImportOsvDatabase(t *testing.T) {
+	source := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(source, "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(source, "nested", "GHSA-test.json"), []byte(testAdvisory), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonFile := filepath.Join(t.TempDir(), "advisory.json")
+	if err := ioutil.WriteFile(jsonFile, []byte(testAdvisory), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{"folder", source, "osv-pypi"},
+		{"json file", jsonFile, "osv-pypi.json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			basePath := filepath.Join(t.TempDir(), "osv-pypi")
+			databasePath, err := importOsvDatabaseFrom(test.source, basePath, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if filepath.Base(databasePath) != test.expected {
+				t.Errorf("imported to %s, expected %s", filepath.Base(databasePath), test.expected)
+			}
+
+			advisories, err := loadOsvDatabase(databasePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(advisories["requests"]) != 1 {
+				t.Errorf("got %d advisories for requests from the import, expected 1", len(advisories["requests"]))
+			}
+		})
+	}
+}
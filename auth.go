@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var authFields []string
+var authLabel string
+var authAppFilter string
+
+// execAuth is the authentication app exec runs the action with
+var execAuth string
+
+// The backend shows this instead of a stored value
+const redactedAuthValue = "Secret. Replaced during app execution!"
+
+// authListing is the summary of an authentication shown by auth list. The
+// values are never shown.
+type authListing struct {
+	Label   string   `json:"label" yaml:"label"`
+	ID      string   `json:"id" yaml:"id"`
+	App     string   `json:"app" yaml:"app"`
+	Version string   `json:"version" yaml:"version"`
+	Fields  []string `json:"fields" yaml:"fields"`
+	Status  string   `json:"status" yaml:"status"`
+	Active  bool     `json:"active" yaml:"active"`
+}
+
+func newAuthListing(auth shuffle.AppAuthenticationStorage) authListing {
+	listing := authListing{
+		Label:   auth.Label,
+		ID:      auth.Id,
+		App:     auth.App.Name,
+		Version: auth.App.AppVersion,
+		Fields:  []string{},
+		Status:  "untested",
+		Active:  auth.Active,
+	}
+
+	for _, field := range auth.Fields {
+		listing.Fields = append(listing.Fields, field.Key)
+	}
+
+	if auth.Validation.ValidationRan {
+		listing.Status = "invalid"
+		if auth.Validation.Valid {
+			listing.Status = "valid"
+		}
+	}
+
+	return listing
+}
+
+var stdinLines *bufio.Reader
+
+// readSecret reads a value without echo on a terminal, or one line from
+// stdin when it is piped
+func readSecret(key string) (string, error) {
+	if isInteractive() {
+		fmt.Fprintf(os.Stderr, "%s: ", key)
+		value, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(value), err
+	}
+
+	if stdinLines == nil {
+		stdinLines = bufio.NewReader(os.Stdin)
+	}
+
+	line, err := stdinLines.ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", fmt.Errorf("no value for %s on stdin", key)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseAuthFields reads the --field flags. "key" reads the value from stdin
+// and "key=env:NAME" from an environment variable. Literal values are refused,
+// as they end up in the shell history and the process list.
+func parseAuthFields(specs []string) ([]shuffle.AuthenticationStore, error) {
+	fields := []shuffle.AuthenticationStore{}
+	for _, spec := range specs {
+		key, value, hasValue := strings.Cut(spec, "=")
+		if len(key) == 0 {
+			return fields, fmt.Errorf("bad field '%s'. Use key or key=env:NAME", spec)
+		}
+
+		if hasValue && !strings.HasPrefix(value, "env:") {
+			return fields, fmt.Errorf("field %s has a value on the command line, where it ends up in the shell history. Use --field %s to read it from stdin, or --field %s=env:NAME", key, key, key)
+		}
+
+		if !hasValue {
+			var err error
+			value, err = readSecret(key)
+			if err != nil {
+				return fields, err
+			}
+		} else {
+			envName := strings.TrimPrefix(value, "env:")
+			value = os.Getenv(envName)
+			if len(value) == 0 {
+				return fields, fmt.Errorf("environment variable %s for field %s is empty", envName, key)
+			}
+		}
+
+		fields = append(fields, shuffle.AuthenticationStore{Key: key, Value: value})
+	}
+
+	return fields, nil
+}
+
+// authApp finds the app to add an authentication for, by name, ID or the
+// folder of an uploaded app
+func authApp(nameIdOrPath string) (shuffle.WorkflowApp, error) {
+	name, version := nameIdOrPath, ""
+	if apiData, err := parseAPIYaml(filepath.Join(nameIdOrPath, "api.yaml")); err == nil {
+		name, version = apiData.Name, apiData.AppVersion
+	}
+
+	apps, err := GetApps()
+	if err != nil {
+		return shuffle.WorkflowApp{}, err
+	}
+
+	found, err := findApp(apps, name, version)
+	if err != nil {
+		return found, fmt.Errorf("%s. Upload the app first", err)
+	}
+
+	// The app list may leave out the authentication details
+	if app, _, err := GetAppConfig(found.ID); err == nil && len(app.Name) > 0 {
+		return app, nil
+	}
+
+	return found, nil
+}
+
+// authFieldProblems compares the fields with the authentication parameters
+// the app declares in api.yaml
+func authFieldProblems(app shuffle.WorkflowApp, fields []shuffle.AuthenticationStore) []string {
+	problems := []string{}
+	if len(app.Authentication.Parameters) == 0 {
+		return append(problems, fmt.Sprintf("%s has no authentication parameters in api.yaml", app.Name))
+	}
+
+	given := map[string]bool{}
+	for _, field := range fields {
+		given[field.Key] = len(field.Value) > 0
+	}
+
+	known := []string{}
+	for _, param := range app.Authentication.Parameters {
+		known = append(known, param.Name)
+		if param.Required && !given[param.Name] {
+			problems = append(problems, fmt.Sprintf("missing required field %s", param.Name))
+		}
+
+		delete(given, param.Name)
+	}
+
+	unknown := []string{}
+	for key := range given {
+		unknown = append(unknown, key)
+	}
+
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("unknown field %s. %s uses %s", key, app.Name, strings.Join(known, ", ")))
+	}
+
+	return problems
+}
+
+// findAuth matches an authentication by ID or label
+func findAuth(auths []shuffle.AppAuthenticationStorage, idOrLabel string) (shuffle.AppAuthenticationStorage, error) {
+	for _, auth := range auths {
+		if auth.Id == idOrLabel {
+			return auth, nil
+		}
+	}
+
+	matches := []shuffle.AppAuthenticationStorage{}
+	for _, auth := range auths {
+		if strings.EqualFold(auth.Label, idOrLabel) {
+			matches = append(matches, auth)
+		}
+	}
+
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	if len(matches) > 1 {
+		return shuffle.AppAuthenticationStorage{}, fmt.Errorf("%d authentications are called %s. Use the ID instead", len(matches), idOrLabel)
+	}
+
+	return shuffle.AppAuthenticationStorage{}, fmt.Errorf("no authentication with label or ID %s", idOrLabel)
+}
+
+// authParameters gets the stored values of an authentication as action
+// parameters. Only the local dev server returns the values, the backend
+// replaces them during execution.
+func authParameters(idOrLabel string) (map[string]string, error) {
+	params := map[string]string{}
+	auths, err := GetAppAuths()
+	if err != nil {
+		return params, err
+	}
+
+	auth, err := findAuth(auths, idOrLabel)
+	if err != nil {
+		return params, err
+	}
+
+	for _, field := range auth.Fields {
+		if auth.Encrypted || strings.Contains(field.Value, redactedAuthValue) {
+			return params, fmt.Errorf("%s doesn't return the values of %s. Run against 'shufflecli dev server' to use stored credentials locally", uploadUrl, auth.Label)
+		}
+
+		params[field.Key] = field.Value
+	}
+
+	return params, nil
+}
+
+var listAuths = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the app authentications in the active org. Values are not shown",
+	Run: func(cmd *cobra.Command, args []string) {
+		requireApikey()
+		auths, err := GetAppAuths()
+		exitOnApiError("Problem listing authentications", err)
+
+		listings := []authListing{}
+		rows := [][]string{}
+		for _, auth := range auths {
+			if len(authAppFilter) > 0 && auth.App.ID != authAppFilter && !strings.EqualFold(auth.App.Name, authAppFilter) {
+				continue
+			}
+
+			listing := newAuthListing(auth)
+			listings = append(listings, listing)
+			rows = append(rows, []string{listing.Label, listing.ID, listing.App, listing.Version, strings.Join(listing.Fields, ","), listing.Status})
+		}
+
+		if err := printFormatted(outputFormat, listings, []string{"LABEL", "ID", "APP", "VERSION", "FIELDS", "STATUS"}, rows); err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var addAuth = &cobra.Command{
+	Use:   "add",
+	Short: "Adds an authentication for an app: add <app name|id|directory> --field key",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No app provided. Use the app name, ID or directory.")
+			return
+		}
+
+		requireApikey()
+		app, err := authApp(args[0])
+		exitOnApiError("Problem finding app", err)
+
+		fields, err := parseAuthFields(authFields)
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+
+		// Ask for the required fields that weren't given
+		for _, param := range app.Authentication.Parameters {
+			missing := param.Required
+			for _, field := range fields {
+				if field.Key == param.Name {
+					missing = false
+				}
+			}
+
+			if missing && isInteractive() {
+				value, err := readSecret(param.Name)
+				if err != nil {
+					log.Printf("[ERROR] %s", err)
+					os.Exit(1)
+				}
+
+				fields = append(fields, shuffle.AuthenticationStore{Key: param.Name, Value: value})
+			}
+		}
+
+		if problems := authFieldProblems(app, fields); len(problems) > 0 {
+			log.Printf("[ERROR] Bad authentication for %s:\n- %s", app.Name, strings.Join(problems, "\n- "))
+			os.Exit(1)
+		}
+
+		label := authLabel
+		if len(label) == 0 {
+			label = fmt.Sprintf("Auth for %s", app.Name)
+		}
+
+		auth := shuffle.AppAuthenticationStorage{
+			Label:  label,
+			Active: true,
+			App: shuffle.WorkflowApp{
+				ID:         app.ID,
+				Name:       app.Name,
+				AppVersion: app.AppVersion,
+				LargeImage: app.LargeImage,
+			},
+			Fields: fields,
+			Type:   app.Authentication.Type,
+		}
+
+		authId, err := SetAppAuth(auth)
+		exitOnApiError("Problem adding authentication", err)
+		log.Printf("[INFO] Added authentication %s (%s) for %s", label, authId, app.Name)
+	},
+}
+
+var testAuth = &cobra.Command{
+	Use:   "test",
+	Short: "Checks an authentication against the app, and optionally runs an action with it: test <id|label> [<app directory> <action> [param=value ...]]",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No authentication provided. Use the label or ID from 'auth list'.")
+			return
+		}
+
+		if len(args) == 2 {
+			log.Println("[ERROR] Give both the app directory and the action to run")
+			return
+		}
+
+		requireApikey()
+		auths, err := GetAppAuths()
+		exitOnApiError("Problem listing authentications", err)
+
+		auth, err := findAuth(auths, args[0])
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+
+		app, _, err := GetAppConfig(auth.App.ID)
+		exitOnApiError(fmt.Sprintf("Problem getting app %s", auth.App.Name), err)
+
+		failed := false
+		if problems := authFieldProblems(app, auth.Fields); len(problems) > 0 {
+			log.Printf("[ERROR] %s doesn't match %s %s:\n- %s", auth.Label, app.Name, app.AppVersion, strings.Join(problems, "\n- "))
+			failed = true
+		} else {
+			log.Printf("[INFO] %s has every field %s %s needs", auth.Label, app.Name, app.AppVersion)
+		}
+
+		if auth.Validation.ValidationRan && !auth.Validation.Valid {
+			log.Printf("[WARNING] The last workflow run with %s failed to authenticate", auth.Label)
+		}
+
+		if len(args) >= 3 {
+			params, err := authParameters(auth.Id)
+			if err != nil {
+				log.Printf("[ERROR] %s", err)
+				os.Exit(1)
+			}
+
+			extra, err := parseKeyValueArgs(args[3:])
+			if err != nil {
+				log.Printf("[ERROR] %s", err)
+				os.Exit(1)
+			}
+
+			for key, value := range extra {
+				params[key] = value
+			}
+
+			result, err := runAppAction(args[1], args[2], params, "")
+			if err != nil {
+				log.Printf("[ERROR] Problem running action %s: %s", args[2], err)
+				os.Exit(1)
+			}
+
+			if !result.Success {
+				log.Printf("[ERROR] Action %s failed with %s: %s", args[2], auth.Label, result.Error)
+				failed = true
+			} else {
+				log.Printf("[INFO] Action %s works with %s", args[2], auth.Label)
+			}
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var deleteAuth = &cobra.Command{
+	Use:   "delete",
+	Short: "Deletes an authentication: delete <id|label>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No authentication provided. Use the label or ID from 'auth list'.")
+			return
+		}
+
+		requireApikey()
+		auths, err := GetAppAuths()
+		exitOnApiError("Problem listing authentications", err)
+
+		auth, err := findAuth(auths, args[0])
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+
+		if !assumeYes && !isInteractive() {
+			log.Println("[ERROR] Use --yes to delete an authentication without a terminal.")
+			os.Exit(1)
+		}
+
+		if !confirm(fmt.Sprintf("Delete %s for %s? Workflows using it will stop working", auth.Label, auth.App.Name), false) {
+			log.Println("[INFO] Not deleting.")
+			return
+		}
+
+		exitOnApiError("Problem deleting authentication", DeleteAppAuth(auth.Id))
+		log.Printf("[INFO] Deleted %s", auth.Label)
+	},
+}
+
+func init() {
+	authCmd.AddCommand(listAuths)
+	authCmd.AddCommand(addAuth)
+	authCmd.AddCommand(testAuth)
+	authCmd.AddCommand(deleteAuth)
+
+	listAuths.Flags().StringVar(&outputFormat, "format", "table", "Output format: table, json or yaml")
+	listAuths.Flags().StringVar(&authAppFilter, "app", "", "Only show authentications for this app name or ID")
+
+	addAuth.Flags().StringArrayVar(&authFields, "field", []string{}, "Field to set. 'key' reads the value from stdin, 'key=env:NAME' from an environment variable")
+	addAuth.Flags().StringVar(&authLabel, "label", "", "Label for the authentication. Defaults to 'Auth for <app>'")
+
+	deleteAuth.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Don't ask for confirmation")
+}
@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"log"
 	"io"
+	"io/fs"
 	"time"
 	"bytes"
-	"context"
 	"os/exec"
 	"strings"
 	"net/http"
@@ -28,6 +28,11 @@ var uploadUrl = "https://shuffler.io"
 var orgId = "orgId"
 var shuffleCodePath = "./shuffle_code"
 
+// Flags for 'app test'
+var lockRequirementsFlag bool
+var lockIndexUrl string
+var lockWheelhouse string
+
 func main() {
 
 	shuffleLogo := ``
@@ -35,7 +40,7 @@ func main() {
 	rootCmd := &cobra.Command{
 		Use:   "shufflecli",
 		Short: "Shuffle CLI",
-		Long:  "A CLI tool to help with building apps in Shuffle. SHUFFLE_APIKEY, SHUFFLE_URL and SHUFFLE_ORGID environment variables can be used to overwrite the default values.",
+		Long:  "A CLI tool to help with building apps in Shuffle. SHUFFLE_APIKEY, SHUFFLE_URL and SHUFFLE_ORGID environment variables can be used to overwrite the default values. SHUFFLE_PROFILE picks the profile from 'org switch'.",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Printf("%s\n\nWelcome to the Shuffle CLI! Use -h to see available commands.", shuffleLogo)
 		},
@@ -55,6 +60,10 @@ func main() {
 		uploadUrl = os.Getenv("SHUFFLE_URL")
 	}
 
+	if err := applyProfile(); err != nil {
+		log.Printf("[WARNING] Problem reading profile from %s: %s", configPath(), err)
+	}
+
 	if len(os.Getenv("SHUFFLE_ORGID")) > 0 {
 		orgId = os.Getenv("SHUFFLE_ORGID")
 	}
@@ -67,6 +76,9 @@ func main() {
 	//rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(appCmd)
 	rootCmd.AddCommand(devCmd)
+	rootCmd.AddCommand(workflowCmd)
+	rootCmd.AddCommand(orgCmd)
+	rootCmd.AddCommand(authCmd)
 	//rootCmd.AddCommand(mathCmd)
 
 	// Execute root command
@@ -93,8 +105,34 @@ func TestApp(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	report := TestReport{App: args[0], Errors: []string{}, Cases: []CaseResult{}}
+	// Failed validation and failed test cases exit with exitValidationFailed,
+	// after the report is written
+	exitCode := 0
+	defer func() {
+		if len(testReportPath) > 0 {
+			writeReport(testReportPath, report)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	if lockRequirementsFlag {
+		err := lockRequirements(args[0], lockIndexUrl, lockWheelhouse)
+		if err != nil {
+			log.Printf("[ERROR] Problem locking requirements: %s", err)
+			report.Errors = append(report.Errors, err.Error())
+			exitCode = 1
+			return
+		}
+	}
+
 	err := runUploadValidation(args)
 	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		exitCode = exitValidationFailed
 		if strings.Contains(err.Error(), "no such file") {
 			if strings.Contains(err.Error(), "api.yaml") {
 				log.Printf("[ERROR] Can't find api.yaml file in '%s'. Make sure to point into a VERSION of the app, containing the 'src' folder.", args[0])
@@ -111,6 +149,15 @@ func TestApp(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	report.Cases, err = runAppTestCases(args[0])
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+		report.Errors = append(report.Errors, err.Error())
+		exitCode = exitValidationFailed
+		return
+	}
+
+	report.Passed = true
 	log.Printf("[INFO] App validated successfully. Upload it with command: \n'shufflecli app upload %s'", args[0])
 }
 
@@ -184,36 +231,28 @@ func validatePythonfile(filepath string) error {
 		return err
 	}
 
-	// Make a copy of the app.py file and run it
-	copyFilepath := "/tmp/shuffle_app.py"
-	if len(os.Getenv("TESTDIR")) > 0 { 
-		copyFilepath = fmt.Sprintf("%s/shuffle_app.py", os.Getenv("TESTDIR"))
-	}
-
-	newFile, err := os.Create(copyFilepath)
+	// Run a copy of app.py with the walkoff import rewritten. Every run gets
+	// its own folder, so parallel runs don't overwrite each other.
+	tempDir, err := ioutil.TempDir(os.Getenv("TESTDIR"), "shufflecli-app-")
 	if err != nil {
-		log.Printf("[ERROR] Problem creating copy of python file: %s", err)
+		log.Printf("[ERROR] Problem creating temp folder for python file: %s", err)
 		return err
 	}
 
-	defer newFile.Close()
-	original, err := os.Open(filepath)
-	if err != nil {
-		log.Printf("[ERROR] Problem opening python file: %s", err)
-		return err
+	if keepTempFiles {
+		log.Printf("[DEBUG] Keeping the app.py copy in %s", tempDir)
+	} else {
+		defer os.RemoveAll(tempDir)
 	}
 
-	// Read the content of outFile and change it
-	filedata, err := ioutil.ReadAll(original)
+	filedata, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		log.Printf("[ERROR] Problem reading original app.py file: %s", err)
 		return err
 	}
 
-	filedata = []byte(strings.Replace(string(filedata), "from walkoff_app_sdk.app_base", "from shuffle_sdk", -1))
-
-	// Write it back to the new file
-	_, err = newFile.Write(filedata)
+	copyFilepath := fmt.Sprintf("%s/app.py", tempDir)
+	err = ioutil.WriteFile(copyFilepath, rewriteSdkImports(filedata), 0644)
 	if err != nil {
 		log.Printf("[ERROR] Problem writing to new app.py file: %s", err)
 		return err
@@ -221,82 +260,11 @@ func validatePythonfile(filepath string) error {
 
 	log.Printf("[DEBUG] Copying app.py file to %s to make edits for the test", copyFilepath)
 
-	//pythonCommand := fmt.Sprintf("python3 %s", filepath)
-
-	// Any way we can INJECT the shuffle/walkoff API into the python file?
-
-	// Run the python file as a test
-	// Clear buffers
-
-	pythonCommand := fmt.Sprintf("python3 %s", copyFilepath)
-
-	timeout := 3 * time.Second
-	log.Printf("[DEBUG] Validating python file by running '%s' for up to %d seconds.", pythonCommand, int(timeout)/1000000000)
-
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel() // Ensure resources are released
-
-	// Run for maximum 5 seconds
-	//cmd = exec.Command("python3", copyFilepath)
-	cmd = exec.CommandContext(ctx, "python3", copyFilepath)
-	cmd.Stdout = &stdoutBuffer
-	cmd.Stderr = &stderrBuffer
-	err = cmd.Run()
-	if ctx.Err() == context.DeadlineExceeded {
-		fmt.Println("Command timed out")
-	}
-
+	err = runAppStartup(copyFilepath, filepath, validationTimeout)
 	if err != nil {
-		if strings.Contains(err.Error(), "signal: killed") {
-			err = nil
-		}
-
-		if err != nil {
-			log.Printf("[ERROR] Local run of python file: %s", err)
-		}
-
-		stdout := stdoutBuffer.String()
-		if len(stdout) > 0 {
-			log.Printf("\n\n===== Python run (stdout) ===== \n")
-
-			for _, line := range strings.Split(stdout, "\n") {
-				if strings.Contains(strings.ToLower(line), "traceback") && !strings.Contains(strings.ToLower(line), "Bad resp") {
-					log.Printf("[ERROR] Python run Error: %s", line)
-				} else if strings.Contains(strings.ToLower(line), "already satisfied") {
-					continue
-				} else {
-					fmt.Println(line)
-				}
-			}
-		}
-
-		stderr := stderrBuffer.String()
-		if len(stderr) > 0 {
-			log.Printf("\n\n===== Python run (stderr) ===== \n")
-
-			for _, line := range strings.Split(stdout, "\n") {
-				if strings.Contains(strings.ToLower(line), "traceback") {
-					log.Printf("[ERROR] Python run Error: %s", line)
-				} else if strings.Contains(strings.ToLower(line), "already satisfied") || strings.Contains(strings.ToLower(line), "[ERROR]") || strings.Contains(strings.ToLower(line), "[WARNING]") || strings.Contains(strings.ToLower(line), "[INFO]") || strings.Contains(strings.ToLower(line), "[DEBUG]") {
-					continue
-				} else {
-					fmt.Println(line)
-				}
-			}
-
-		}
-
 		return err
 	}
 
-	// Remove the copy
-	/*
-	err = os.Remove(copyFilepath)
-	if err != nil {
-		log.Printf("[ERROR] Problem removing copy of python file '%s': %s", copyFilepath, err)
-	}
-	*/
-
 	log.Printf("[INFO] Python file ran successfully\n")
 
 	return nil
@@ -352,8 +320,34 @@ func runUploadValidation(args []string) error {
 		return fmt.Errorf("Validation failed because of %s", strings.Join(errors, ", "))
 	}
 
+	if auditDependencies {
+		err = runDependencyAudit(args[0])
+		if err != nil {
+			log.Printf("[ERROR] Dependency audit failed: %s", err)
+			return err
+		}
+	}
+
+	if skipTests {
+		log.Printf("[WARNING] Skipping the app run and tests because of --skip-tests")
+		return nil
+	}
+
+	if useDocker {
+		err = validateAppInDocker(args[0])
+		if err != nil {
+			log.Printf("[ERROR] Problem validating app in Docker: %s", err)
+			return err
+		}
+
+		log.Printf("[INFO] Zip + Uploading app from directory: %s", args[0])
+		return nil
+	}
+
 	pyFile := fmt.Sprintf("%s/src/app.py", args[0])
+	pythonEnvLock.Lock()
 	err = validatePythonfile(pyFile) 
+	pythonEnvLock.Unlock()
 	if err != nil {
 		log.Printf("[ERROR] Problem validating python file: %s", err)
 		return err
@@ -420,6 +414,9 @@ func ZipFiles(filename string, files []string) error {
 
 func UploadAppFromRepo(folderpath string) error {
 	log.Printf("[DEBUG] Uploading app from %#v: ", folderpath)
+	if err := checkPulledStub(folderpath); err != nil {
+		return err
+	}
 
 
 	// Walk the path and add 
@@ -429,6 +426,15 @@ func UploadAppFromRepo(folderpath string) error {
 			return err
 		}
 
+		relativePath, _ := filepath.Rel(folderpath, path)
+		if skipUploadFile(filepath.ToSlash(relativePath), fs.FileInfoToDirEntry(info)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
 		if !info.IsDir() {
 			allFiles = append(allFiles, path)
 		}
@@ -440,6 +446,23 @@ func UploadAppFromRepo(folderpath string) error {
 		log.Printf("[ERROR] Problem walking path: %s", err)
 	}
 
+	// Block the upload if anything in the archive looks like a secret
+	secretFindings, err := scanFilesForSecrets(folderpath, allFiles)
+	if err != nil {
+		log.Printf("[ERROR] Problem scanning files for secrets: %s", err)
+		return err
+	}
+
+	logFindings(secretFindings)
+	if hasErrorFindings(secretFindings) {
+		if !allowSecrets {
+			log.Printf("[ERROR] Found %d possible secrets in the files to upload. Remove them, add them to %s/%s, or use --allow-secrets.", len(secretFindings), folderpath, secretsAllowlistFile)
+			return fmt.Errorf("%w: upload blocked because of %d possible secrets", errValidationFailed, len(secretFindings))
+		}
+
+		log.Printf("[WARNING] Uploading despite %d possible secrets because of --allow-secrets", len(secretFindings))
+	}
+
 	zipLocation := fmt.Sprintf("%s/upload.zip", folderpath)
 	err = ZipFiles(zipLocation, allFiles)
 	if err != nil {
@@ -493,12 +516,13 @@ func UploadAppFromRepo(folderpath string) error {
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apikey))
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	setOrgHeader(req, "")
 
 	// Upload the file
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[ERROR] Problem uploading file: %s", err)
-		return err
+		return fmt.Errorf("%w: %s", errServerFailed, err)
 	}
 
 	outputBody, err := ioutil.ReadAll(resp.Body)
@@ -523,7 +547,7 @@ func UploadAppFromRepo(folderpath string) error {
 	*/
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Bad status: %s. Raw: %s", resp.Status, string(outputBody))
+		return statusError(resp.StatusCode, resp.Status, outputBody)
 	}
 
 	log.Printf("[INFO] File uploaded successfully: %s", resp.Status)
@@ -700,7 +724,7 @@ var runParameter = &cobra.Command{
 			workflow.Actions[foundActionIndex].Parameters[foundParamIndex].Value = actionCode
 			go UploadWorkflow(workflow)
 
-			log.Printf("[INFO] Code changed and uploading.", dockerCommand)
+			log.Printf("[INFO] Code changed and uploading. Test with: %s", dockerCommand)
 
 			time.Sleep(1 * time.Second)
 		}
@@ -709,16 +733,16 @@ var runParameter = &cobra.Command{
 
 var uploadApp = &cobra.Command{
 	Use:   "upload",
-	Short: "Uploads and app from a directory containing the api.yaml",
+	Short: "Uploads and app from a directory containing the api.yaml, or every app in a repository with --all",
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) <= 0 {
+		if len(args) <= 0 && !uploadAll {
 			log.Println("[ERROR] No directory provided. Use the absolute path to the app directory.")
 			return
 		}
 
 		if len(apikey) <= 0 {
 			fmt.Println("Please set the SHUFFLE_APIKEY or SHUFFLE_AUTHORIZATION environment variables to help with upload/download.")
-			os.Exit(1)
+			os.Exit(exitAuthFailed)
 		}
 
 		// Look for if there is a filepath or not, which contains an api.yaml file AND a src/app.py file
@@ -727,46 +751,130 @@ var uploadApp = &cobra.Command{
 			log.Println("[DEBUG] No directory provided. Using current directory.")
 		}
 
-		err := runUploadValidation(args)
+		if uploadAll {
+			summary, err := uploadAllApps(args[0])
+			if err != nil {
+				log.Printf("[ERROR] Problem uploading apps from %s: %s", args[0], err)
+				os.Exit(1)
+			}
+
+			summary.printTable(args[0])
+			if err := summary.firstError(); err != nil {
+				os.Exit(uploadExitCode(err))
+			}
+
+			return
+		}
+
+		if len(changedSince) > 0 {
+			log.Println("[ERROR] --changed-since only works with --all")
+			os.Exit(1)
+		}
+
+		summary := &uploadSummary{}
+		start := time.Now()
+		status, err := uploadAppFolder(args[0], false)
+		summary.add(args[0], status, time.Since(start), err)
+		summary.log()
 		if err != nil {
-			if strings.Contains(err.Error(), "no such file") {
-				if strings.Contains(err.Error(), "api.yaml") {
-					log.Printf("[ERROR] Can't find api.yaml file in '%s'. Make sure to point into a VERSION of the app, containing the 'src' folder.", args[0])
-				} else if strings.Contains(err.Error(), "app.py") {
-					log.Printf("[ERROR] Can't find app.py file in '%s'. Make sure to point into a VERSION of the app, containing the 'src' folder.", args[0])
-				} else {
-					log.Printf("[ERROR] Can't find app folder '%s'. Use the absolute path.", args[0])
-				}
+			os.Exit(uploadExitCode(err))
+		}
+	},
+}
 
-				return
+// uploadAppFolder validates and uploads one app version. Returns whether it
+// was uploaded, skipped or failed. confirmed skips the prompts when the
+// upload was already confirmed for a batch of apps.
+func uploadAppFolder(folderpath string, confirmed bool) (string, error) {
+	if err := checkPulledStub(folderpath); err != nil {
+		log.Printf("[ERROR] %s", err)
+		return "failed", err
+	}
+
+	unchanged, hashKey, hash := appUnchanged(folderpath)
+	if unchanged && !forceUpload {
+		log.Printf("[INFO] %s is unchanged since it was last uploaded. Skipping. Use --force to upload anyway.", folderpath)
+		if err := shareUploadedApp(folderpath); err != nil {
+			log.Printf("[ERROR] Problem sharing app: %s", err)
+			return "failed", err
+		}
+
+		return "skipped", nil
+	}
+
+	err := runUploadValidation([]string{folderpath})
+	if err == nil && !skipTests {
+		pythonEnvLock.Lock()
+		_, err = runAppTestCases(folderpath)
+		pythonEnvLock.Unlock()
+	}
+
+	if err != nil {
+		if strings.Contains(err.Error(), "no such file") {
+			if strings.Contains(err.Error(), "api.yaml") {
+				log.Printf("[ERROR] Can't find api.yaml file in '%s'. Make sure to point into a VERSION of the app, containing the 'src' folder.", folderpath)
+			} else if strings.Contains(err.Error(), "app.py") {
+				log.Printf("[ERROR] Can't find app.py file in '%s'. Make sure to point into a VERSION of the app, containing the 'src' folder.", folderpath)
+			} else {
+				log.Printf("[ERROR] Can't find app folder '%s'. Use the absolute path.", folderpath)
 			}
 
-			log.Printf("[ERROR] App validation issue: %s", err)
-			//return
+			return "failed", fmt.Errorf("%w: %s", errValidationFailed, err)
+		}
+
+		log.Printf("[ERROR] App validation issue: %s", err)
+		if !forceUpload {
+			log.Println("[ERROR] Not uploading an app that failed validation. Fix the issues above, or use --force to upload anyway.")
+			return "failed", fmt.Errorf("%w: %s", errValidationFailed, err)
 		}
 
-		// Get user input for whether to continue or not with Y/n
-		input := "Y"	
-		fmt.Print("\n\nContinue with upload? [Y/n]: ")
-		fmt.Scanln(&input)
+		if !confirmed && !assumeYes && !isInteractive() {
+			log.Println("[ERROR] --force without a terminal also needs --yes to confirm uploading an app that failed validation.")
+			return "failed", fmt.Errorf("%w: %s", errValidationFailed, err)
+		}
 
-		log.Printf("INPUT: %#v", input)
-		if strings.ToUpper(input) != "Y" {
+		if !confirmed && !confirm("Validation failed. Upload anyway?", false) {
 			log.Println("[INFO] Aborting upload.")
-			return
+			return "failed", fmt.Errorf("%w: %s", errValidationFailed, err)
 		}
 
-		// Upload the app
-		err = UploadAppFromRepo(args[0])
-		if err != nil {
-			log.Printf("[ERROR] Problem uploading app: %s", err)
-			return
+		log.Println("[WARNING] Uploading despite failed validation because of --force")
+	} else if !confirmed && !confirm("Continue with upload?", true) {
+		log.Println("[INFO] Aborting upload.")
+		return "skipped", nil
+	}
+
+	// Upload the app
+	if uploadSlots != nil {
+		uploadSlots <- struct{}{}
+	}
+
+	err = UploadAppFromRepo(folderpath)
+	if uploadSlots != nil {
+		<-uploadSlots
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] Problem uploading app: %s", err)
+		return "failed", err
+	}
+
+	if err := shareUploadedApp(folderpath); err != nil {
+		log.Printf("[ERROR] App uploaded, but there was a problem sharing it: %s", err)
+		return "failed", err
+	}
+
+	if len(hashKey) > 0 {
+		if err := saveUploadedHash(hashKey, hash); err != nil {
+			log.Printf("[WARNING] Problem saving the upload hash to %s: %s", uploadStatePath, err)
 		}
+	}
 
-		log.Println("[INFO] App uploaded successfully.")
-	},
+	log.Println("[INFO] App uploaded successfully.")
+	return "uploaded", nil
 }
 
+
 // Example command with subcommands: Math operations
 var appCmd = &cobra.Command{
 	Use:   "app",
@@ -778,11 +886,51 @@ var devCmd = &cobra.Command{
 	Short: "Development related commands",
 }
 
+var workflowCmd = &cobra.Command{
+	Use:   "workflow",
+	Short: "Workflow related commands",
+}
+
+var orgCmd = &cobra.Command{
+	Use:   "org",
+	Short: "Organization related commands",
+}
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "App authentication related commands",
+}
+
 func init() {
 	// Register subcommands to the math command
 	appCmd.AddCommand(uploadApp)
 	appCmd.AddCommand(testApp)
 
+	testApp.Flags().BoolVar(&lockRequirementsFlag, "lock", false, "Write a fully pinned requirements.lock with hashes")
+	testApp.Flags().StringVar(&lockIndexUrl, "index-url", "", "Python package index to resolve the lock file from")
+	testApp.Flags().StringVar(&lockWheelhouse, "wheelhouse", "", "Local folder of wheels to resolve the lock file from when offline")
+
+	for _, command := range []*cobra.Command{testApp, uploadApp} {
+		command.Flags().BoolVar(&keepTempFiles, "keep-temp", false, "Keep the temporary copy of app.py used for validation")
+		command.Flags().DurationVar(&validationTimeout, "timeout", validationTimeout, "Max time for app.py to start during validation")
+		command.Flags().BoolVar(&auditDependencies, "audit", false, "Fail validation on vulnerable or copyleft dependencies")
+		command.Flags().StringVar(&osvDatabasePath, "osv-db", "", "OSV database file or folder used by --audit")
+	}
+
+	testApp.Flags().BoolVar(&useDocker, "docker", false, "Build the app image with Docker and validate inside it")
+	testApp.Flags().StringVar(&testReportPath, "report", "", "Write a JSON report of the validation and test cases to a file")
+	testApp.Flags().StringVar(&fixturesPath, "fixtures", "", "Folder of cassettes recorded with 'app exec --record' to replay and compare")
+	uploadApp.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Don't ask for confirmation")
+	uploadApp.Flags().BoolVar(&forceUpload, "force", false, "Upload even if the app is unchanged or validation fails. Asks for confirmation unless --yes is set")
+	uploadApp.Flags().BoolVar(&uploadPublic, "public", false, "Publish the app to the public app library after uploading")
+	uploadApp.Flags().StringSliceVar(&shareOrgs, "share-with", nil, "Share the app with these org IDs after uploading, e.g. sub-organizations")
+	uploadApp.Flags().BoolVar(&uploadAll, "all", false, "Upload every app version (<app>/<version>/ folders) under the given repository root")
+	uploadApp.Flags().IntVar(&uploadConcurrency, "concurrency", uploadConcurrency, "How many apps to upload at once with --all")
+	uploadApp.Flags().StringVar(&changedSince, "changed-since", "", "With --all, only upload apps with files changed since this git ref")
+	uploadApp.Flags().StringVar(&uploadStatePath, "state", defaultUploadStatePath(), "File keeping the content hash of every uploaded app version")
+	uploadApp.Flags().BoolVar(&skipTests, "skip-tests", false, "Only run the static checks, not the app itself or tests.yaml")
+	uploadApp.Flags().BoolVar(&allowSecrets, "allow-secrets", false, "Upload even if files look like they contain secrets")
+
 	devCmd.AddCommand(runParameter)
 }
 
@@ -0,0 +1,1107 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var devServerPort int
+var devServerDataDir string
+var devServerApikey string
+
+// devServer is a local stand-in for the parts of /api/v1/ that apps and the
+// SDK use. All state is kept as JSON files in dataDir.
+type devServer struct {
+	dataDir string
+	apikey  string
+	mutex   sync.Mutex
+}
+
+// newUuid returns a random UUIDv4, the ID format Shuffle uses everywhere
+func newUuid() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+func (server *devServer) path(parts ...string) string {
+	return filepath.Join(append([]string{server.dataDir}, parts...)...)
+}
+
+// readJSON loads a stored object. Missing files are reported with os.ErrNotExist.
+func (server *devServer) readJSON(path string, target interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, target)
+}
+
+func (server *devServer) writeJSON(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// listJSON reads every stored object in a folder, sorted by filename
+func listJSON[T any](server *devServer, folder string) []T {
+	items := []T{}
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return items
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		var item T
+		if err := server.readJSON(filepath.Join(folder, file.Name()), &item); err != nil {
+			log.Printf("[WARNING] Skipping bad file %s: %s", file.Name(), err)
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// safeName keeps user controlled IDs and keys from escaping the data folder
+func safeName(name string) string {
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "\\", "_")
+	if name == "." || name == ".." {
+		return "_"
+	}
+
+	return name
+}
+
+func writeJSONResponse(resp http.ResponseWriter, status int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data = []byte(`{"success": false, "reason": "Failed to marshal response"}`)
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	resp.Write(data)
+}
+
+func writeFailure(resp http.ResponseWriter, status int, reason string) {
+	writeJSONResponse(resp, status, shuffle.ResultChecker{Success: false, Reason: reason})
+}
+
+// authenticate checks the Bearer apikey when the server was started with one
+func (server *devServer) authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, request *http.Request) {
+		log.Printf("[DEBUG] %s %s", request.Method, request.URL.Path)
+		if len(server.apikey) > 0 && strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ") != server.apikey {
+			writeFailure(resp, http.StatusUnauthorized, "Bad apikey")
+			return
+		}
+
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		handler(resp, request)
+	}
+}
+
+func (server *devServer) handleGetWorkflows(resp http.ResponseWriter, request *http.Request) {
+	writeJSONResponse(resp, http.StatusOK, listJSON[shuffle.Workflow](server, server.path("workflows")))
+}
+
+func (server *devServer) handleGetWorkflow(resp http.ResponseWriter, request *http.Request) {
+	workflow := shuffle.Workflow{}
+	if err := server.readJSON(server.path("workflows", safeName(request.PathValue("id"))+".json"), &workflow); err != nil {
+		writeFailure(resp, http.StatusNotFound, "Workflow not found")
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, workflow)
+}
+
+func (server *devServer) handlePutWorkflow(resp http.ResponseWriter, request *http.Request) {
+	workflow := shuffle.Workflow{}
+	if err := json.NewDecoder(request.Body).Decode(&workflow); err != nil {
+		writeFailure(resp, http.StatusBadRequest, fmt.Sprintf("Bad workflow: %s", err))
+		return
+	}
+
+	workflow.ID = request.PathValue("id")
+	workflow.Edited = time.Now().Unix()
+	if err := server.writeJSON(server.path("workflows", safeName(workflow.ID)+".json"), workflow); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+func (server *devServer) handleListFiles(resp http.ResponseWriter, request *http.Request) {
+	writeJSONResponse(resp, http.StatusOK, listJSON[shuffle.File](server, server.path("files")))
+}
+
+func (server *devServer) handleCreateFile(resp http.ResponseWriter, request *http.Request) {
+	file := shuffle.File{}
+	if err := json.NewDecoder(request.Body).Decode(&file); err != nil {
+		writeFailure(resp, http.StatusBadRequest, fmt.Sprintf("Bad file: %s", err))
+		return
+	}
+
+	file.Id = fmt.Sprintf("file_%s", newUuid())
+	file.CreatedAt = time.Now().Unix()
+	file.UpdatedAt = file.CreatedAt
+	file.Status = "created"
+	if len(file.OrgId) == 0 {
+		file.OrgId = orgId
+	}
+
+	if err := server.writeJSON(server.path("files", file.Id+".json"), file); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, map[string]interface{}{"success": true, "id": file.Id})
+}
+
+// storeFileContent saves content for an existing file and updates its hashes
+func (server *devServer) storeFileContent(id string, content []byte) (shuffle.File, error) {
+	file := shuffle.File{}
+	metaPath := server.path("files", safeName(id)+".json")
+	if err := server.readJSON(metaPath, &file); err != nil {
+		return file, err
+	}
+
+	if err := ioutil.WriteFile(server.path("files", safeName(id)+".content"), content, 0644); err != nil {
+		return file, err
+	}
+
+	md5sum := md5.Sum(content)
+	sha256sum := sha256.Sum256(content)
+	file.Md5sum = hex.EncodeToString(md5sum[:])
+	file.Sha256sum = hex.EncodeToString(sha256sum[:])
+	file.FileSize = int64(len(content))
+	file.Status = "active"
+	file.UpdatedAt = time.Now().Unix()
+	return file, server.writeJSON(metaPath, file)
+}
+
+func (server *devServer) handleUploadFile(resp http.ResponseWriter, request *http.Request) {
+	uploaded, _, err := request.FormFile("shuffle_file")
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Missing shuffle_file in form")
+		return
+	}
+
+	defer uploaded.Close()
+	content, err := io.ReadAll(uploaded)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, err := server.storeFileContent(request.PathValue("id"), content)
+	if err != nil {
+		writeFailure(resp, http.StatusNotFound, "File not found")
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, map[string]interface{}{"success": true, "file_id": file.Id})
+}
+
+func (server *devServer) handleEditFile(resp http.ResponseWriter, request *http.Request) {
+	content, err := io.ReadAll(request.Body)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := server.storeFileContent(request.PathValue("id"), content); err != nil {
+		writeFailure(resp, http.StatusNotFound, "File not found")
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+func (server *devServer) handleGetFile(resp http.ResponseWriter, request *http.Request) {
+	file := shuffle.File{}
+	if err := server.readJSON(server.path("files", safeName(request.PathValue("id"))+".json"), &file); err != nil {
+		writeFailure(resp, http.StatusNotFound, "File not found")
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, file)
+}
+
+func (server *devServer) handleGetFileContent(resp http.ResponseWriter, request *http.Request) {
+	content, err := ioutil.ReadFile(server.path("files", safeName(request.PathValue("id"))+".content"))
+	if err != nil {
+		writeFailure(resp, http.StatusNotFound, "File content not found")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	resp.Write(content)
+}
+
+func (server *devServer) handleDeleteFile(resp http.ResponseWriter, request *http.Request) {
+	id := safeName(request.PathValue("id"))
+	if err := os.Remove(server.path("files", id+".json")); err != nil {
+		writeFailure(resp, http.StatusNotFound, "File not found")
+		return
+	}
+
+	os.Remove(server.path("files", id+".content"))
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+// handleGetFileSubpath serves both /files/{id}/content and /files/namespaces/{namespace},
+// as the two patterns overlap in the router
+func (server *devServer) handleGetFileSubpath(resp http.ResponseWriter, request *http.Request) {
+	if request.PathValue("id") == "namespaces" {
+		server.handleFileNamespace(resp, request, request.PathValue("sub"))
+		return
+	}
+
+	if request.PathValue("sub") != "content" {
+		writeFailure(resp, http.StatusNotFound, "Not found")
+		return
+	}
+
+	server.handleGetFileContent(resp, request)
+}
+
+// handleFileNamespace returns the files in a namespace, as ID/name pairs with
+// ?ids=true or as a zip of their contents otherwise
+func (server *devServer) handleFileNamespace(resp http.ResponseWriter, request *http.Request, namespace string) {
+	files := []shuffle.File{}
+	for _, file := range listJSON[shuffle.File](server, server.path("files")) {
+		if file.Namespace == namespace {
+			files = append(files, file)
+		}
+	}
+
+	if request.URL.Query().Get("ids") == "true" {
+		idList := []map[string]string{}
+		for _, file := range files {
+			idList = append(idList, map[string]string{"id": file.Id, "name": file.Filename})
+		}
+
+		writeJSONResponse(resp, http.StatusOK, idList)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buf)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(server.path("files", file.Id+".content"))
+		if err != nil {
+			continue
+		}
+
+		writer, err := zipWriter.Create(file.Filename)
+		if err != nil {
+			continue
+		}
+
+		writer.Write(content)
+	}
+
+	zipWriter.Close()
+	resp.Header().Set("Content-Type", "application/zip")
+	resp.Write(buf.Bytes())
+}
+
+// readCacheRequest parses the body the SDK sends for the cache endpoints
+func readCacheRequest(request *http.Request) (shuffle.CacheKeyData, error) {
+	cacheData := shuffle.CacheKeyData{}
+	err := json.NewDecoder(request.Body).Decode(&cacheData)
+	if err == nil && len(cacheData.Key) == 0 {
+		err = fmt.Errorf("missing key")
+	}
+
+	return cacheData, err
+}
+
+func (server *devServer) handleSetCache(resp http.ResponseWriter, request *http.Request) {
+	cacheData, err := readCacheRequest(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cachePath := server.path("cache", safeName(request.PathValue("org")), safeName(cacheData.Key)+".json")
+	existing := shuffle.CacheKeyData{}
+	if err := server.readJSON(cachePath, &existing); err == nil {
+		cacheData.Created = existing.Created
+	} else {
+		cacheData.Created = time.Now().Unix()
+	}
+
+	cacheData.OrgId = request.PathValue("org")
+	cacheData.Authorization = ""
+	cacheData.Edited = time.Now().Unix()
+	cacheData.Success = true
+	if err := server.writeJSON(cachePath, cacheData); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+func (server *devServer) handleGetCache(resp http.ResponseWriter, request *http.Request) {
+	cacheData, err := readCacheRequest(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stored := shuffle.CacheKeyData{}
+	if err := server.readJSON(server.path("cache", safeName(request.PathValue("org")), safeName(cacheData.Key)+".json"), &stored); err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Failed to get key")
+		return
+	}
+
+	stored.Success = true
+	writeJSONResponse(resp, http.StatusOK, stored)
+}
+
+func (server *devServer) handleDeleteCache(resp http.ResponseWriter, request *http.Request) {
+	cacheData, err := readCacheRequest(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := os.Remove(server.path("cache", safeName(request.PathValue("org")), safeName(cacheData.Key)+".json")); err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Failed to delete key")
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+func (server *devServer) handleListCache(resp http.ResponseWriter, request *http.Request) {
+	keys := listJSON[shuffle.CacheKeyData](server, server.path("cache", safeName(request.PathValue("org"))))
+	writeJSONResponse(resp, http.StatusOK, map[string]interface{}{"success": true, "keys": keys})
+}
+
+func (server *devServer) handleGetNotifications(resp http.ResponseWriter, request *http.Request) {
+	notifications := listJSON[shuffle.Notification](server, server.path("notifications"))
+	writeJSONResponse(resp, http.StatusOK, map[string]interface{}{"success": true, "notifications": notifications})
+}
+
+func (server *devServer) handleCreateNotification(resp http.ResponseWriter, request *http.Request) {
+	notification := shuffle.Notification{}
+	if err := json.NewDecoder(request.Body).Decode(&notification); err != nil {
+		writeFailure(resp, http.StatusBadRequest, fmt.Sprintf("Bad notification: %s", err))
+		return
+	}
+
+	notification.Id = newUuid()
+	notification.CreatedAt = time.Now().Unix()
+	notification.UpdatedAt = notification.CreatedAt
+	notification.Amount = 1
+	if len(notification.OrgId) == 0 {
+		notification.OrgId = orgId
+	}
+
+	if err := server.writeJSON(server.path("notifications", notification.Id+".json"), notification); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("[INFO] Notification: %s - %s", notification.Title, notification.Description)
+	writeJSONResponse(resp, http.StatusOK, map[string]interface{}{"success": true, "id": notification.Id})
+}
+
+func (server *devServer) handleMarkNotificationRead(resp http.ResponseWriter, request *http.Request) {
+	notificationPath := server.path("notifications", safeName(request.PathValue("id"))+".json")
+	notification := shuffle.Notification{}
+	if err := server.readJSON(notificationPath, &notification); err != nil {
+		writeFailure(resp, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	notification.Read = true
+	notification.UpdatedAt = time.Now().Unix()
+	if err := server.writeJSON(notificationPath, notification); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+func (server *devServer) handleUploadApp(resp http.ResponseWriter, request *http.Request) {
+	uploaded, _, err := request.FormFile("shuffle_file")
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Missing shuffle_file in form")
+		return
+	}
+
+	defer uploaded.Close()
+	content, err := io.ReadAll(uploaded)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, fmt.Sprintf("Not a zip file: %s", err))
+		return
+	}
+
+	app := shuffle.WorkflowApp{}
+	found := false
+	for _, file := range archive.File {
+		if filepath.Base(file.Name) != "api.yaml" {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			writeFailure(resp, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err == nil {
+			err = yaml.Unmarshal(data, &app)
+		}
+
+		if err != nil {
+			writeFailure(resp, http.StatusBadRequest, fmt.Sprintf("Bad api.yaml: %s", err))
+			return
+		}
+
+		found = true
+		break
+	}
+
+	if !found || len(app.Name) == 0 || len(app.AppVersion) == 0 {
+		writeFailure(resp, http.StatusBadRequest, "No api.yaml with name and app_version in the zip")
+		return
+	}
+
+	// Same app and version replaces the earlier upload, like in Shuffle
+	hasher := md5.New()
+	hasher.Write([]byte(strings.ToLower(app.Name) + app.AppVersion))
+	app.ID = hex.EncodeToString(hasher.Sum(nil))
+
+	appPath := server.path("apps", app.ID+".json")
+	existing := shuffle.WorkflowApp{}
+	if err := server.readJSON(appPath, &existing); err == nil {
+		app.Created = existing.Created
+		app.Activated = existing.Activated
+		app.Sharing = existing.Sharing
+		app.Public = existing.Public
+	} else {
+		app.Created = time.Now().Unix()
+		app.Activated = true
+	}
+
+	app.Edited = time.Now().Unix()
+	app.IsValid = true
+	if err := server.writeJSON(appPath, app); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("[INFO] App uploaded: %s %s (%s)", app.Name, app.AppVersion, app.ID)
+	writeJSONResponse(resp, http.StatusOK, map[string]interface{}{"success": true, "id": app.ID})
+}
+
+func (server *devServer) handleGetApps(resp http.ResponseWriter, request *http.Request) {
+	writeJSONResponse(resp, http.StatusOK, listJSON[shuffle.WorkflowApp](server, server.path("apps")))
+}
+
+// handleGetAppConfig returns the app and, if one was stored in
+// openapi/<id>.json, the OpenAPI spec it was generated from
+func (server *devServer) handleGetAppConfig(resp http.ResponseWriter, request *http.Request) {
+	app := shuffle.WorkflowApp{}
+	if err := server.readJSON(server.path("apps", safeName(request.PathValue("id"))+".json"), &app); err != nil {
+		writeFailure(resp, http.StatusNotFound, "App not found")
+		return
+	}
+
+	config := shuffle.AppParser{Success: true}
+	config.App, _ = json.Marshal(app)
+
+	openapi := shuffle.ParsedOpenApi{}
+	if err := server.readJSON(server.path("openapi", app.ID+".json"), &openapi); err == nil {
+		config.OpenAPI, _ = json.Marshal(openapi)
+	}
+
+	writeJSONResponse(resp, http.StatusOK, config)
+}
+
+func (server *devServer) handleDeleteApp(resp http.ResponseWriter, request *http.Request) {
+	if err := os.Remove(server.path("apps", safeName(request.PathValue("id"))+".json")); err != nil {
+		writeFailure(resp, http.StatusNotFound, "App not found")
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+func (server *devServer) handleActivateApp(resp http.ResponseWriter, request *http.Request) {
+	action := request.PathValue("action")
+	if action != "activate" && action != "deactivate" {
+		writeFailure(resp, http.StatusNotFound, fmt.Sprintf("Unknown app action %s", action))
+		return
+	}
+
+	appPath := server.path("apps", safeName(request.PathValue("id"))+".json")
+	app := shuffle.WorkflowApp{}
+	if err := server.readJSON(appPath, &app); err != nil {
+		writeFailure(resp, http.StatusNotFound, "App not found")
+		return
+	}
+
+	// The active org is sent with every request, so only another org than
+	// the one owning the apps counts as activating for another org
+	home, _ := server.homeOrg()
+	if activateOrgId := request.Header.Get("Org-Id"); len(activateOrgId) > 0 && activateOrgId != home.Id {
+		// Like the backend, only apps that are shared can be activated in
+		// another org
+		if action == "activate" && !app.Sharing && !app.Public {
+			writeFailure(resp, http.StatusBadRequest, "Only shared or public apps can be activated")
+			return
+		}
+
+		log.Printf("[INFO] App %s: %s for org %s", app.Name, action, activateOrgId)
+		writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+		return
+	}
+
+	app.Activated = action == "activate"
+	app.Edited = time.Now().Unix()
+	if err := server.writeJSON(appPath, app); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+func (server *devServer) handleUpdateApp(resp http.ResponseWriter, request *http.Request) {
+	appPath := server.path("apps", safeName(request.PathValue("id"))+".json")
+	app := shuffle.WorkflowApp{}
+	if err := server.readJSON(appPath, &app); err != nil {
+		writeFailure(resp, http.StatusNotFound, "App not found")
+		return
+	}
+
+	fields := struct {
+		Sharing       bool   `json:"sharing"`
+		SharingConfig string `json:"sharing_config"`
+		Public        bool   `json:"public"`
+	}{}
+
+	if err := json.NewDecoder(request.Body).Decode(&fields); err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	app.Sharing = fields.Sharing
+	app.SharingConfig = fields.SharingConfig
+	app.Public = fields.Public
+	app.Edited = time.Now().Unix()
+	if err := server.writeJSON(appPath, app); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+// orgAuths lists the app authentications stored for an org. Unlike the
+// backend, the values are returned so local app runs can use them.
+func (server *devServer) orgAuths(orgId string) []shuffle.AppAuthenticationStorage {
+	auths := []shuffle.AppAuthenticationStorage{}
+	for _, auth := range listJSON[shuffle.AppAuthenticationStorage](server, server.path("appauth")) {
+		if auth.OrgId == orgId {
+			auths = append(auths, auth)
+		}
+	}
+
+	return auths
+}
+
+func (server *devServer) handleGetAppAuths(resp http.ResponseWriter, request *http.Request) {
+	org, err := server.requestOrg(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Org not found")
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, map[string]interface{}{"success": true, "data": server.orgAuths(org.Id)})
+}
+
+// handleSetAppAuth adds or updates an app authentication. Like the backend,
+// every required field of the app has to be set.
+func (server *devServer) handleSetAppAuth(resp http.ResponseWriter, request *http.Request) {
+	org, err := server.requestOrg(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Org not found")
+		return
+	}
+
+	auth := shuffle.AppAuthenticationStorage{}
+	if err := json.NewDecoder(request.Body).Decode(&auth); err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	app := shuffle.WorkflowApp{}
+	if err := server.readJSON(server.path("apps", safeName(auth.App.ID)+".json"), &app); err != nil {
+		writeFailure(resp, http.StatusBadRequest, "App not found")
+		return
+	}
+
+	for _, param := range app.Authentication.Parameters {
+		found := false
+		for _, field := range auth.Fields {
+			if field.Key == param.Name && len(field.Value) > 0 {
+				found = true
+			}
+		}
+
+		if param.Required && !found {
+			writeFailure(resp, http.StatusBadRequest, "All auth fields required")
+			return
+		}
+	}
+
+	if len(auth.Id) == 0 {
+		fieldData := fmt.Sprintf("%s_%s", org.Id, auth.Label)
+		for _, field := range auth.Fields {
+			fieldData += field.Key + field.Value
+		}
+
+		hasher := md5.New()
+		hasher.Write([]byte(fieldData))
+		auth.Id = hex.EncodeToString(hasher.Sum(nil))
+		auth.Created = time.Now().Unix()
+	} else {
+		existing := shuffle.AppAuthenticationStorage{}
+		if err := server.readJSON(server.path("appauth", safeName(auth.Id)+".json"), &existing); err == nil {
+			if existing.OrgId != org.Id {
+				writeFailure(resp, http.StatusForbidden, "Authentication belongs to another org")
+				return
+			}
+
+			auth.Created = existing.Created
+		}
+	}
+
+	authPath := server.path("appauth", safeName(auth.Id)+".json")
+	auth.OrgId = org.Id
+	auth.App = shuffle.WorkflowApp{ID: app.ID, Name: app.Name, AppVersion: app.AppVersion, LargeImage: app.LargeImage}
+	auth.Active = true
+	auth.Defined = true
+	auth.Edited = time.Now().Unix()
+	if err := server.writeJSON(authPath, auth); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("[INFO] App authentication set: %s for %s (%s)", auth.Label, app.Name, auth.Id)
+	writeJSONResponse(resp, http.StatusOK, map[string]interface{}{"success": true, "id": auth.Id})
+}
+
+func (server *devServer) handleDeleteAppAuth(resp http.ResponseWriter, request *http.Request) {
+	org, err := server.requestOrg(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Org not found")
+		return
+	}
+
+	authPath := server.path("appauth", safeName(request.PathValue("id"))+".json")
+	auth := shuffle.AppAuthenticationStorage{}
+	if err := server.readJSON(authPath, &auth); err != nil || auth.OrgId != org.Id {
+		writeFailure(resp, http.StatusNotFound, "Authentication not found")
+		return
+	}
+
+	if err := os.Remove(authPath); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+// seedOrg makes the org and admin user the server acts as, the first time
+// it starts with a data folder
+func (server *devServer) seedOrg() error {
+	if len(listJSON[shuffle.OrgMini](server, server.path("orgdata"))) > 0 {
+		return nil
+	}
+
+	org := shuffle.OrgMini{Name: "Dev Org", Id: newUuid(), Role: "admin"}
+	if err := server.writeJSON(server.path("orgdata", org.Id+".json"), org); err != nil {
+		return err
+	}
+
+	user := shuffle.User{Id: newUuid(), Username: "admin@shuffle.local", Role: "admin", Roles: []string{"admin"}, Orgs: []string{org.Id}}
+	return server.writeJSON(server.path("users", user.Id+".json"), user)
+}
+
+// requestOrg is the org a request acts in: the Org-Id header, or the
+// first top level org
+func (server *devServer) requestOrg(request *http.Request) (shuffle.OrgMini, error) {
+	org := shuffle.OrgMini{}
+	if orgId := request.Header.Get("Org-Id"); len(orgId) > 0 {
+		err := server.readJSON(server.path("orgdata", safeName(orgId)+".json"), &org)
+		return org, err
+	}
+
+	return server.homeOrg()
+}
+
+// homeOrg is the parent org the dev server's apps belong to
+func (server *devServer) homeOrg() (shuffle.OrgMini, error) {
+	for _, stored := range listJSON[shuffle.OrgMini](server, server.path("orgdata")) {
+		if len(stored.CreatorOrg) == 0 {
+			return stored, nil
+		}
+	}
+
+	return shuffle.OrgMini{}, os.ErrNotExist
+}
+
+func (server *devServer) orgUsers(orgId string) []shuffle.User {
+	users := []shuffle.User{}
+	for _, user := range listJSON[shuffle.User](server, server.path("users")) {
+		for _, userOrg := range user.Orgs {
+			if userOrg == orgId {
+				users = append(users, user)
+				break
+			}
+		}
+	}
+
+	return users
+}
+
+func (server *devServer) handleGetOrgs(resp http.ResponseWriter, request *http.Request) {
+	writeJSONResponse(resp, http.StatusOK, listJSON[shuffle.OrgMini](server, server.path("orgdata")))
+}
+
+// handleCreateSubOrg checks the parent the same way the backend does: the
+// path, body and active org all have to match
+func (server *devServer) handleCreateSubOrg(resp http.ResponseWriter, request *http.Request) {
+	parent, err := server.requestOrg(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Org not found")
+		return
+	}
+
+	fields := struct {
+		OrgId string `json:"org_id"`
+		Name  string `json:"name"`
+	}{}
+
+	if err := json.NewDecoder(request.Body).Decode(&fields); err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if fields.OrgId != parent.Id || request.PathValue("org") != parent.Id {
+		writeFailure(resp, http.StatusBadRequest, "Can only create sub-orgs of the active org")
+		return
+	}
+
+	if len(fields.Name) < 3 {
+		writeFailure(resp, http.StatusBadRequest, "Name must be at least 3 characters")
+		return
+	}
+
+	org := shuffle.OrgMini{Name: fields.Name, Id: newUuid(), Role: "admin", CreatorOrg: parent.Id}
+	if err := server.writeJSON(server.path("orgdata", org.Id+".json"), org); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The admins of the parent are in the sub-org as well
+	for _, user := range server.orgUsers(parent.Id) {
+		if user.Role == "admin" {
+			user.Orgs = append(user.Orgs, org.Id)
+			server.writeJSON(server.path("users", user.Id+".json"), user)
+		}
+	}
+
+	log.Printf("[INFO] Sub-org created: %s (%s) under %s", org.Name, org.Id, parent.Id)
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true, Reason: fmt.Sprintf("Successfully created new sub-org %s", org.Id)})
+}
+
+func (server *devServer) handleGetUsers(resp http.ResponseWriter, request *http.Request) {
+	org, err := server.requestOrg(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Org not found")
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, server.orgUsers(org.Id))
+}
+
+func (server *devServer) handleRegisterUser(resp http.ResponseWriter, request *http.Request) {
+	org, err := server.requestOrg(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Org not found")
+		return
+	}
+
+	fields := struct {
+		Username string `json:"username"`
+	}{}
+
+	if err := json.NewDecoder(request.Body).Decode(&fields); err != nil || len(fields.Username) == 0 {
+		writeFailure(resp, http.StatusBadRequest, "Missing username")
+		return
+	}
+
+	for _, user := range listJSON[shuffle.User](server, server.path("users")) {
+		if !strings.EqualFold(user.Username, fields.Username) {
+			continue
+		}
+
+		for _, userOrg := range user.Orgs {
+			if userOrg == org.Id {
+				writeFailure(resp, http.StatusBadRequest, "User is already in the org")
+				return
+			}
+		}
+
+		user.Orgs = append(user.Orgs, org.Id)
+		if err := server.writeJSON(server.path("users", user.Id+".json"), user); err != nil {
+			writeFailure(resp, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+		return
+	}
+
+	user := shuffle.User{Id: newUuid(), Username: fields.Username, Role: "user", Roles: []string{"user"}, Orgs: []string{org.Id}}
+	if err := server.writeJSON(server.path("users", user.Id+".json"), user); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("[INFO] User invited: %s to %s", user.Username, org.Name)
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+func (server *devServer) handleUpdateUser(resp http.ResponseWriter, request *http.Request) {
+	fields := struct {
+		UserId string `json:"user_id"`
+		Role   string `json:"role"`
+	}{}
+
+	if err := json.NewDecoder(request.Body).Decode(&fields); err != nil {
+		writeFailure(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if fields.Role != "admin" && fields.Role != "user" && fields.Role != "org-reader" {
+		writeFailure(resp, http.StatusBadRequest, fmt.Sprintf("Bad role %s", fields.Role))
+		return
+	}
+
+	userPath := server.path("users", safeName(fields.UserId)+".json")
+	user := shuffle.User{}
+	if err := server.readJSON(userPath, &user); err != nil {
+		writeFailure(resp, http.StatusNotFound, "User not found")
+		return
+	}
+
+	user.Role = fields.Role
+	user.Roles = []string{fields.Role}
+	if err := server.writeJSON(userPath, user); err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+// handleDeleteUser removes the user from the active org, and deletes them
+// when it was their last one
+func (server *devServer) handleDeleteUser(resp http.ResponseWriter, request *http.Request) {
+	org, err := server.requestOrg(request)
+	if err != nil {
+		writeFailure(resp, http.StatusBadRequest, "Org not found")
+		return
+	}
+
+	userPath := server.path("users", safeName(request.PathValue("id"))+".json")
+	user := shuffle.User{}
+	if err := server.readJSON(userPath, &user); err != nil {
+		writeFailure(resp, http.StatusNotFound, "User not found")
+		return
+	}
+
+	orgs := []string{}
+	for _, userOrg := range user.Orgs {
+		if userOrg != org.Id {
+			orgs = append(orgs, userOrg)
+		}
+	}
+
+	if len(orgs) == len(user.Orgs) {
+		writeFailure(resp, http.StatusNotFound, "User not found in the org")
+		return
+	}
+
+	if len(orgs) == 0 {
+		err = os.Remove(userPath)
+	} else {
+		user.Orgs = orgs
+		err = server.writeJSON(userPath, user)
+	}
+
+	if err != nil {
+		writeFailure(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(resp, http.StatusOK, shuffle.ResultChecker{Success: true})
+}
+
+// routes registers every mocked endpoint
+func (server *devServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, server.authenticate(handler))
+	}
+
+	handle("GET /api/v1/workflows", server.handleGetWorkflows)
+	handle("GET /api/v1/workflows/{id}", server.handleGetWorkflow)
+	handle("PUT /api/v1/workflows/{id}", server.handlePutWorkflow)
+
+	handle("GET /api/v1/files", server.handleListFiles)
+	handle("POST /api/v1/files/create", server.handleCreateFile)
+	handle("GET /api/v1/files/{id}", server.handleGetFile)
+	handle("DELETE /api/v1/files/{id}", server.handleDeleteFile)
+	handle("GET /api/v1/files/{id}/{sub}", server.handleGetFileSubpath)
+	handle("POST /api/v1/files/{id}/upload", server.handleUploadFile)
+	handle("PUT /api/v1/files/{id}/edit", server.handleEditFile)
+
+	handle("GET /api/v1/apps", server.handleGetApps)
+	handle("POST /api/v1/apps/upload", server.handleUploadApp)
+	handle("GET /api/v1/apps/{id}/config", server.handleGetAppConfig)
+	handle("DELETE /api/v1/apps/{id}", server.handleDeleteApp)
+	handle("PATCH /api/v1/apps/{id}", server.handleUpdateApp)
+	handle("GET /api/v1/apps/{id}/{action}", server.handleActivateApp)
+	handle("GET /api/v1/apps/authentication", server.handleGetAppAuths)
+	handle("PUT /api/v1/apps/authentication", server.handleSetAppAuth)
+	handle("DELETE /api/v1/apps/authentication/{id}", server.handleDeleteAppAuth)
+
+	handle("POST /api/v1/orgs/{org}/set_cache", server.handleSetCache)
+	handle("POST /api/v1/orgs/{org}/get_cache", server.handleGetCache)
+	handle("POST /api/v1/orgs/{org}/delete_cache", server.handleDeleteCache)
+	handle("GET /api/v1/orgs/{org}/list_cache", server.handleListCache)
+
+	handle("GET /api/v1/orgs", server.handleGetOrgs)
+	handle("POST /api/v1/orgs/{org}/create_sub_org", server.handleCreateSubOrg)
+	handle("GET /api/v1/getusers", server.handleGetUsers)
+	handle("POST /api/v1/users/register", server.handleRegisterUser)
+	handle("PUT /api/v1/users/updateuser", server.handleUpdateUser)
+	handle("DELETE /api/v1/users/{id}", server.handleDeleteUser)
+
+	handle("GET /api/v1/notifications", server.handleGetNotifications)
+	handle("POST /api/v1/notifications", server.handleCreateNotification)
+	handle("GET /api/v1/notifications/{id}/markasread", server.handleMarkNotificationRead)
+
+	mux.HandleFunc("/", func(resp http.ResponseWriter, request *http.Request) {
+		log.Printf("[WARNING] Not mocked by the dev server: %s %s", request.Method, request.URL.Path)
+		writeFailure(resp, http.StatusNotImplemented, fmt.Sprintf("%s %s is not supported by the local dev server", request.Method, request.URL.Path))
+	})
+
+	return mux
+}
+
+var devServerCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Starts a local mock of the Shuffle backend for apps and workflows",
+	Run: func(cmd *cobra.Command, args []string) {
+		dataDir, err := filepath.Abs(devServerDataDir)
+		if err != nil {
+			log.Printf("[ERROR] Bad data directory: %s", err)
+			os.Exit(1)
+		}
+
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			log.Printf("[ERROR] Problem creating data directory %s: %s", dataDir, err)
+			os.Exit(1)
+		}
+
+		server := &devServer{
+			dataDir: dataDir,
+			apikey:  devServerApikey,
+		}
+
+		if err := server.seedOrg(); err != nil {
+			log.Printf("[ERROR] Problem creating the dev org: %s", err)
+			os.Exit(1)
+		}
+
+		address := fmt.Sprintf("127.0.0.1:%d", devServerPort)
+		log.Printf("[INFO] Shuffle dev server keeping state in %s. Use it from other commands with:\n\nexport SHUFFLE_URL=http://%s\n", dataDir, address)
+		if err := http.ListenAndServe(address, server.routes()); err != nil {
+			log.Printf("[ERROR] Dev server stopped: %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	devCmd.AddCommand(devServerCmd)
+
+	devServerCmd.Flags().IntVar(&devServerPort, "port", 5001, "Port to listen on")
+	devServerCmd.Flags().StringVar(&devServerDataDir, "data", "./.shuffle_dev", "Directory to keep the server state in")
+	devServerCmd.Flags().StringVar(&devServerApikey, "apikey", "", "Require this apikey as Bearer token. Any token is accepted if empty")
+}
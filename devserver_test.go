@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+const testDevApiYaml = `name: Dev Test
+app_version: 1.0.0
+description: Test app
+authentication:
+  required: true
+  parameters:
+    - name: apikey
+      required: true
+      schema:
+        type: string
+actions:
+  - name: hello
+`
+
+func newTestDevServer(t *testing.T, apikey string) (*devServer, *httptest.Server) {
+	t.Helper()
+
+	server := &devServer{dataDir: t.TempDir(), apikey: apikey}
+	if err := server.seedOrg(); err != nil {
+		t.Fatal(err)
+	}
+
+	testServer := httptest.NewServer(server.routes())
+	t.Cleanup(testServer.Close)
+	return server, testServer
+}
+
+// devRequest sends a request to the dev server and decodes the JSON reply
+// into target when it's set
+func devRequest(t *testing.T, testServer *httptest.Server, method, path string, body io.Reader, headers map[string]string, target interface{}) int {
+	t.Helper()
+
+	request, err := http.NewRequest(method, testServer.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target != nil {
+		if err := json.Unmarshal(data, target); err != nil {
+			t.Fatalf("%s %s: bad JSON %q: %s", method, path, data, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func jsonBody(t *testing.T, value interface{}) io.Reader {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.NewReader(data)
+}
+
+// uploadTestDevApp uploads a zip with testDevApiYaml the way "app upload" does
+func uploadTestDevApp(t *testing.T, testServer *httptest.Server) string {
+	t.Helper()
+
+	archive := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(archive)
+	file, err := zipWriter.Create("api.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte(testDevApiYaml))
+	zipWriter.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("shuffle_file", "upload.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	part.Write(archive.Bytes())
+	writer.Close()
+
+	result := struct {
+		Success bool   `json:"success"`
+		Id      string `json:"id"`
+	}{}
+
+	status := devRequest(t, testServer, "POST", "/api/v1/apps/upload", body, map[string]string{"Content-Type": writer.FormDataContentType()}, &result)
+	if status != http.StatusOK || !result.Success || len(result.Id) == 0 {
+		t.Fatalf("app upload = %d %+v, expected success with an id", status, result)
+	}
+
+	return result.Id
+}
+
+func TestDevServerAuthentication(t *testing.T) {
+	_, testServer := newTestDevServer(t, "secret-key")
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/workflows", nil, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("request without apikey = %d, expected %d", status, http.StatusUnauthorized)
+	}
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/workflows", nil, map[string]string{"Authorization": "Bearer wrong"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("request with a wrong apikey = %d, expected %d", status, http.StatusUnauthorized)
+	}
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/workflows", nil, map[string]string{"Authorization": "Bearer secret-key"}, nil); status != http.StatusOK {
+		t.Errorf("request with the apikey = %d, expected %d", status, http.StatusOK)
+	}
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/workflows/x/executions", nil, nil, nil); status != http.StatusNotImplemented {
+		t.Errorf("unmocked endpoint = %d, expected %d", status, http.StatusNotImplemented)
+	}
+}
+
+func TestDevServerWorkflows(t *testing.T) {
+	_, testServer := newTestDevServer(t, "")
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/workflows/missing", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("GET missing workflow = %d, expected %d", status, http.StatusNotFound)
+	}
+
+	workflow := shuffle.Workflow{
+		ID:   "ignored",
+		Name: "Test workflow",
+		Actions: []shuffle.Action{
+			{ID: "a1", Label: "start", AppName: "Dev Test", AppVersion: "1.0.0", Name: "hello"},
+		},
+		Start: "a1",
+	}
+
+	if status := devRequest(t, testServer, "PUT", "/api/v1/workflows/wf1", jsonBody(t, workflow), nil, nil); status != http.StatusOK {
+		t.Fatalf("PUT workflow = %d, expected %d", status, http.StatusOK)
+	}
+
+	stored := shuffle.Workflow{}
+	if status := devRequest(t, testServer, "GET", "/api/v1/workflows/wf1", nil, nil, &stored); status != http.StatusOK {
+		t.Fatalf("GET workflow = %d, expected %d", status, http.StatusOK)
+	}
+
+	if stored.ID != "wf1" || stored.Name != workflow.Name || stored.Start != "a1" || len(stored.Actions) != 1 || stored.Edited == 0 {
+		t.Errorf("GET workflow = %+v, expected the stored workflow with the path ID", stored)
+	}
+
+	workflows := []shuffle.Workflow{}
+	devRequest(t, testServer, "GET", "/api/v1/workflows", nil, nil, &workflows)
+	if len(workflows) != 1 || workflows[0].ID != "wf1" {
+		t.Errorf("GET workflows = %+v, expected wf1", workflows)
+	}
+
+	if status := devRequest(t, testServer, "PUT", "/api/v1/workflows/wf2", bytes.NewReader([]byte("not json")), nil, nil); status != http.StatusBadRequest {
+		t.Errorf("PUT bad workflow = %d, expected %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestDevServerApps(t *testing.T) {
+	server, testServer := newTestDevServer(t, "")
+	appId := uploadTestDevApp(t, testServer)
+
+	apps := []shuffle.WorkflowApp{}
+	devRequest(t, testServer, "GET", "/api/v1/apps", nil, nil, &apps)
+	if len(apps) != 1 || apps[0].ID != appId || apps[0].Name != "Dev Test" || !apps[0].Activated {
+		t.Fatalf("GET apps = %+v, expected the uploaded app, activated", apps)
+	}
+
+	// Uploading the same name and version replaces the app
+	if secondId := uploadTestDevApp(t, testServer); secondId != appId {
+		t.Errorf("second upload id = %s, expected %s", secondId, appId)
+	}
+
+	config := shuffle.AppParser{}
+	if status := devRequest(t, testServer, "GET", "/api/v1/apps/"+appId+"/config", nil, nil, &config); status != http.StatusOK || !config.Success {
+		t.Fatalf("GET app config = %d %+v, expected success", status, config)
+	}
+
+	app := shuffle.WorkflowApp{}
+	if err := json.Unmarshal(config.App, &app); err != nil || app.ID != appId {
+		t.Errorf("app config = %s, expected app %s", config.App, appId)
+	}
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/apps/"+appId+"/deactivate", nil, nil, nil); status != http.StatusOK {
+		t.Errorf("deactivate = %d, expected %d", status, http.StatusOK)
+	}
+
+	devRequest(t, testServer, "GET", "/api/v1/apps", nil, nil, &apps)
+	if apps[0].Activated {
+		t.Errorf("app is still activated after deactivate")
+	}
+
+	// The home org is sent as Org-Id by the CLI, which isn't cross-org
+	home, _ := server.homeOrg()
+	if status := devRequest(t, testServer, "GET", "/api/v1/apps/"+appId+"/activate", nil, map[string]string{"Org-Id": home.Id}, nil); status != http.StatusOK {
+		t.Errorf("activate in the home org = %d, expected %d", status, http.StatusOK)
+	}
+
+	otherOrg := map[string]string{"Org-Id": "other-org"}
+	if status := devRequest(t, testServer, "GET", "/api/v1/apps/"+appId+"/activate", nil, otherOrg, nil); status != http.StatusBadRequest {
+		t.Errorf("activate unshared app in another org = %d, expected %d", status, http.StatusBadRequest)
+	}
+
+	if status := devRequest(t, testServer, "PATCH", "/api/v1/apps/"+appId, jsonBody(t, map[string]interface{}{"sharing": true}), nil, nil); status != http.StatusOK {
+		t.Errorf("PATCH sharing = %d, expected %d", status, http.StatusOK)
+	}
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/apps/"+appId+"/activate", nil, otherOrg, nil); status != http.StatusOK {
+		t.Errorf("activate shared app in another org = %d, expected %d", status, http.StatusOK)
+	}
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/apps/"+appId+"/publish", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("unknown app action = %d, expected %d", status, http.StatusNotFound)
+	}
+
+	if status := devRequest(t, testServer, "DELETE", "/api/v1/apps/"+appId, nil, nil, nil); status != http.StatusOK {
+		t.Errorf("DELETE app = %d, expected %d", status, http.StatusOK)
+	}
+
+	if status := devRequest(t, testServer, "GET", "/api/v1/apps/"+appId+"/config", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("GET deleted app config = %d, expected %d", status, http.StatusNotFound)
+	}
+}
+
+func TestDevServerAppAuth(t *testing.T) {
+	server, testServer := newTestDevServer(t, "")
+	appId := uploadTestDevApp(t, testServer)
+
+	missing := shuffle.AppAuthenticationStorage{Label: "prod", App: shuffle.WorkflowApp{ID: appId}}
+	if status := devRequest(t, testServer, "PUT", "/api/v1/apps/authentication", jsonBody(t, missing), nil, nil); status != http.StatusBadRequest {
+		t.Errorf("PUT auth without required fields = %d, expected %d", status, http.StatusBadRequest)
+	}
+
+	auth := shuffle.AppAuthenticationStorage{
+		Label:  "prod",
+		App:    shuffle.WorkflowApp{ID: appId},
+		Fields: []shuffle.AuthenticationStore{{Key: "apikey", Value: "abc"}},
+	}
+
+	result := struct {
+		Success bool   `json:"success"`
+		Id      string `json:"id"`
+	}{}
+
+	if status := devRequest(t, testServer, "PUT", "/api/v1/apps/authentication", jsonBody(t, auth), nil, &result); status != http.StatusOK || len(result.Id) == 0 {
+		t.Fatalf("PUT auth = %d %+v, expected an id", status, result)
+	}
+
+	auths := struct {
+		Success bool                               `json:"success"`
+		Data    []shuffle.AppAuthenticationStorage `json:"data"`
+	}{}
+
+	devRequest(t, testServer, "GET", "/api/v1/apps/authentication", nil, nil, &auths)
+	if len(auths.Data) != 1 || auths.Data[0].Id != result.Id || auths.Data[0].App.Name != "Dev Test" || auths.Data[0].Fields[0].Value != "abc" {
+		t.Fatalf("GET auths = %+v, expected the stored auth with its values", auths.Data)
+	}
+
+	// Auths are per org
+	home, _ := server.homeOrg()
+	subOrg := shuffle.OrgMini{Name: "Sub", Id: "sub-org", CreatorOrg: home.Id}
+	server.writeJSON(server.path("orgdata", subOrg.Id+".json"), subOrg)
+
+	subHeaders := map[string]string{"Org-Id": subOrg.Id}
+	devRequest(t, testServer, "GET", "/api/v1/apps/authentication", nil, subHeaders, &auths)
+	if len(auths.Data) != 0 {
+		t.Errorf("GET auths in the sub-org = %+v, expected none", auths.Data)
+	}
+
+	if status := devRequest(t, testServer, "DELETE", "/api/v1/apps/authentication/"+result.Id, nil, subHeaders, nil); status != http.StatusNotFound {
+		t.Errorf("DELETE auth from another org = %d, expected %d", status, http.StatusNotFound)
+	}
+
+	if status := devRequest(t, testServer, "DELETE", "/api/v1/apps/authentication/"+result.Id, nil, nil, nil); status != http.StatusOK {
+		t.Errorf("DELETE auth = %d, expected %d", status, http.StatusOK)
+	}
+
+	devRequest(t, testServer, "GET", "/api/v1/apps/authentication", nil, nil, &auths)
+	if len(auths.Data) != 0 {
+		t.Errorf("GET auths after delete = %+v, expected none", auths.Data)
+	}
+}
+
+func TestDevServerOrgs(t *testing.T) {
+	server, testServer := newTestDevServer(t, "")
+	home, err := server.homeOrg()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]string{"org_id": home.Id, "name": "Child"}
+	if status := devRequest(t, testServer, "POST", "/api/v1/orgs/other/create_sub_org", jsonBody(t, body), nil, nil); status != http.StatusBadRequest {
+		t.Errorf("create sub-org with a mismatched path = %d, expected %d", status, http.StatusBadRequest)
+	}
+
+	if status := devRequest(t, testServer, "POST", "/api/v1/orgs/"+home.Id+"/create_sub_org", jsonBody(t, body), nil, nil); status != http.StatusOK {
+		t.Fatalf("create sub-org = %d, expected %d", status, http.StatusOK)
+	}
+
+	orgs := []shuffle.OrgMini{}
+	devRequest(t, testServer, "GET", "/api/v1/orgs", nil, nil, &orgs)
+
+	child := shuffle.OrgMini{}
+	for _, org := range orgs {
+		if org.Name == "Child" {
+			child = org
+		}
+	}
+
+	if child.CreatorOrg != home.Id {
+		t.Fatalf("GET orgs = %+v, expected Child under %s", orgs, home.Id)
+	}
+
+	// The seeded admin is in the new sub-org as well
+	users := []shuffle.User{}
+	devRequest(t, testServer, "GET", "/api/v1/getusers", nil, map[string]string{"Org-Id": child.Id}, &users)
+	if len(users) != 1 || users[0].Role != "admin" {
+		t.Errorf("GET users in the sub-org = %+v, expected the admin", users)
+	}
+
+	if status := devRequest(t, testServer, "POST", "/api/v1/users/register", jsonBody(t, map[string]string{"username": "new@example.com"}), nil, nil); status != http.StatusOK {
+		t.Errorf("register user = %d, expected %d", status, http.StatusOK)
+	}
+
+	devRequest(t, testServer, "GET", "/api/v1/getusers", nil, nil, &users)
+	if len(users) != 2 {
+		t.Errorf("GET users = %+v, expected the admin and the new user", users)
+	}
+}
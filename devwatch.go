@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var syncUploads bool
+var watchInterval = time.Second
+
+// devStep is one line in the status panel
+type devStep struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	Detail   []string
+}
+
+// devRun is the result of checking the app once after a change
+type devRun struct {
+	Started time.Time
+	Changed []string
+	Steps   []devStep
+	Logs    []string
+}
+
+// watchSnapshot records modification times of the files that trigger a rerun
+func watchSnapshot(folderPath string) map[string]time.Time {
+	snapshot := map[string]time.Time{}
+	for _, name := range []string{"api.yaml", appTestsFile, "requirements.txt"} {
+		if info, err := os.Stat(filepath.Join(folderPath, name)); err == nil {
+			snapshot[name] = info.ModTime()
+		}
+	}
+
+	filepath.WalkDir(filepath.Join(folderPath, "src"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if entry.IsDir() && entry.Name() == "__pycache__" {
+			return filepath.SkipDir
+		}
+
+		if entry.IsDir() || strings.HasSuffix(path, ".pyc") {
+			return nil
+		}
+
+		if info, err := entry.Info(); err == nil {
+			relativePath, _ := filepath.Rel(folderPath, path)
+			snapshot[relativePath] = info.ModTime()
+		}
+
+		return nil
+	})
+
+	return snapshot
+}
+
+// changedFiles lists what was added, changed or removed between two snapshots
+func changedFiles(before, after map[string]time.Time) []string {
+	changed := []string{}
+	for path, modTime := range after {
+		if previous, ok := before[path]; !ok || !previous.Equal(modTime) {
+			changed = append(changed, path)
+		}
+	}
+
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+
+	return uniqueStrings(changed)
+}
+
+// captureOutput sends the log and stdout to a buffer until the returned
+// function is called, which gives back what was written. The checks print
+// python output and reports to stdout, which would corrupt the panel.
+func captureOutput() func() string {
+	buffer := &lockedBuffer{}
+	log.SetOutput(buffer)
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return func() string {
+			log.SetOutput(os.Stderr)
+			return buffer.String()
+		}
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(buffer, reader)
+		close(copied)
+	}()
+
+	return func() string {
+		os.Stdout = stdout
+		writer.Close()
+		<-copied
+		reader.Close()
+		log.SetOutput(os.Stderr)
+		return buffer.String()
+	}
+}
+
+// runDevChecks verifies the app, runs its tests and optionally uploads it.
+// Output is captured so problems can be shown in the panel.
+func runDevChecks(folderPath string, changed []string) devRun {
+	run := devRun{Started: time.Now(), Changed: changed}
+	finishCapture := captureOutput()
+
+	step := func(name string, check func() (string, error)) bool {
+		start := time.Now()
+		detail, err := check()
+		result := devStep{Name: name, Status: "PASS", Duration: time.Since(start)}
+		if len(detail) > 0 {
+			result.Detail = append(result.Detail, detail)
+		}
+
+		if err != nil {
+			result.Status = "FAIL"
+			result.Detail = append(result.Detail, err.Error())
+		}
+
+		run.Steps = append(run.Steps, result)
+		return err == nil
+	}
+
+	skip := func(names ...string) {
+		for _, name := range names {
+			run.Steps = append(run.Steps, devStep{Name: name, Status: "SKIP"})
+		}
+	}
+
+	passed := step("verify", func() (string, error) {
+		errors, err := VerifyFolder(folderPath)
+		if err != nil {
+			return "", err
+		}
+
+		if len(errors) > 0 {
+			return "", fmt.Errorf("problems with %s", strings.Join(errors, ", "))
+		}
+
+		return "", nil
+	})
+
+	passed = step("actions", func() (string, error) {
+		apiData, err := parseAPIYaml(filepath.Join(folderPath, "api.yaml"))
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%d actions in api.yaml", len(apiData.Actions)), checkActionsInPython(apiData.Actions, filepath.Join(folderPath, "src", "app.py"))
+	}) && passed
+
+	if passed {
+		passed = step("tests", func() (string, error) {
+			results, err := runAppTestCases(folderPath)
+			if len(results) == 0 && err == nil {
+				return fmt.Sprintf("no %s", appTestsFile), nil
+			}
+
+			failed := 0
+			for _, result := range results {
+				if !result.Passed {
+					failed += 1
+				}
+			}
+
+			return fmt.Sprintf("%d passed, %d failed", len(results)-failed, failed), err
+		})
+	} else {
+		skip("tests")
+	}
+
+	if syncUploads && passed {
+		step("sync", func() (string, error) {
+			return fmt.Sprintf("uploaded to %s", uploadUrl), UploadAppFromRepo(folderPath)
+		})
+	} else if syncUploads {
+		skip("sync")
+	}
+
+	for _, line := range strings.Split(finishCapture(), "\n") {
+		if strings.Contains(line, "[ERROR]") || strings.Contains(line, "[WARNING]") || strings.Contains(line, "FAIL") {
+			run.Logs = append(run.Logs, line)
+		}
+	}
+
+	return run
+}
+
+// renderDevPanel shows the latest run. On a terminal the screen is redrawn.
+func renderDevPanel(folderPath string, run devRun, redraw bool) {
+	output := &strings.Builder{}
+	if redraw {
+		output.WriteString("\033[H\033[2J")
+	}
+
+	fmt.Fprintf(output, "shufflecli app dev: %s\n", folderPath)
+	fmt.Fprintf(output, "Checked at %s", run.Started.Format("15:04:05"))
+	if len(run.Changed) > 0 {
+		fmt.Fprintf(output, " after changes to %s", strings.Join(run.Changed, ", "))
+	}
+
+	output.WriteString("\n\n")
+	for _, step := range run.Steps {
+		fmt.Fprintf(output, "  %-4s  %-8s %6.2fs", step.Status, step.Name, step.Duration.Seconds())
+		if len(step.Detail) > 0 {
+			fmt.Fprintf(output, "  %s", strings.Join(step.Detail, "; "))
+		}
+
+		output.WriteString("\n")
+	}
+
+	if len(run.Logs) > 0 {
+		output.WriteString("\n")
+		maxLines := 20
+		logs := run.Logs
+		if len(logs) > maxLines {
+			logs = logs[len(logs)-maxLines:]
+		}
+
+		for _, line := range logs {
+			fmt.Fprintf(output, "  %s\n", line)
+		}
+	}
+
+	output.WriteString("\nWatching api.yaml and src/ for changes. Ctrl+C to stop.\n")
+	fmt.Print(output.String())
+}
+
+func watchApp(folderPath string) error {
+	if _, err := os.Stat(filepath.Join(folderPath, "api.yaml")); err != nil {
+		return err
+	}
+
+	if syncUploads && len(apikey) == 0 {
+		return fmt.Errorf("--sync needs SHUFFLE_APIKEY or SHUFFLE_AUTHORIZATION for the dev instance")
+	}
+
+	redraw := term.IsTerminal(int(os.Stdout.Fd()))
+	snapshot := watchSnapshot(folderPath)
+	renderDevPanel(folderPath, runDevChecks(folderPath, nil), redraw)
+
+	for {
+		time.Sleep(watchInterval)
+		current := watchSnapshot(folderPath)
+		changed := changedFiles(snapshot, current)
+		if len(changed) == 0 {
+			continue
+		}
+
+		snapshot = current
+		renderDevPanel(folderPath, runDevChecks(folderPath, changed), redraw)
+	}
+}
+
+var devApp = &cobra.Command{
+	Use:   "dev",
+	Short: "Re-validates and tests an app whenever api.yaml or src/ changes",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No directory provided. Use the absolute path to the app directory.")
+			return
+		}
+
+		if err := watchApp(args[0]); err != nil {
+			log.Printf("[ERROR] Problem watching app: %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	appCmd.AddCommand(devApp)
+
+	devApp.Flags().BoolVar(&syncUploads, "sync", false, "Upload the app to SHUFFLE_URL every time the checks pass")
+	devApp.Flags().DurationVar(&watchInterval, "interval", watchInterval, "How often to check for changes")
+	devApp.Flags().StringVar(&fixturesPath, "fixtures", "", "Folder of cassettes to replay along with tests.yaml")
+}
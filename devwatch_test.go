@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCaptureOutput(t *testing.T) {
+	stdout := os.Stdout
+	finishCapture := captureOutput()
+	fmt.Println("printed by the python output")
+	log.Printf("[ERROR] logged by a check")
+	output := finishCapture()
+
+	if os.Stdout != stdout {
+		t.Errorf("captureOutput didn't restore stdout")
+	}
+
+	if !strings.Contains(output, "printed by the python output") || !strings.Contains(output, "[ERROR] logged by a check") {
+		t.Errorf("captureOutput = %q, expected both the printed and logged lines", output)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type diffOp struct {
+	Kind byte // ' ', '-' or '+'
+	Line string
+}
+
+// diffLines is the Myers diff of two lists of lines
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	trace := [][]int{}
+
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		trace = append(trace, append([]int{}, v...))
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		previousK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			previousK = k + 1
+		}
+
+		previousX := v[offset+previousK]
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+
+		if x == previousX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
+
+// unifiedDiff formats the changes between two texts like 'diff -u', with
+// three lines of context. Returns an empty string if they are the same.
+func unifiedDiff(path, original, changed string) string {
+	if original == changed {
+		return ""
+	}
+
+	ops := diffLines(strings.Split(original, "\n"), strings.Split(changed, "\n"))
+	context := 3
+
+	output := &strings.Builder{}
+	fmt.Fprintf(output, "--- a/%s\n+++ b/%s\n", path, path)
+
+	// Line numbers in the old and new text for every op
+	oldLines := make([]int, len(ops)+1)
+	newLines := make([]int, len(ops)+1)
+	for index, op := range ops {
+		oldLines[index+1], newLines[index+1] = oldLines[index], newLines[index]
+		if op.Kind != '+' {
+			oldLines[index+1]++
+		}
+
+		if op.Kind != '-' {
+			newLines[index+1]++
+		}
+	}
+
+	for start := 0; start < len(ops); {
+		if ops[start].Kind == ' ' {
+			start++
+			continue
+		}
+
+		// Grow the hunk until there are more than 2*context unchanged lines
+		hunkStart := start - context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+
+		end := start
+		for unchanged := 0; end < len(ops) && unchanged <= 2*context; end++ {
+			if ops[end].Kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+
+		// Drop the trailing context beyond what is shown
+		for end > start && ops[end-1].Kind == ' ' {
+			end--
+		}
+
+		hunkEnd := end + context
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		oldCount := oldLines[hunkEnd] - oldLines[hunkStart]
+		newCount := newLines[hunkEnd] - newLines[hunkStart]
+		fmt.Fprintf(output, "@@ -%d,%d +%d,%d @@\n", oldLines[hunkStart]+1, oldCount, newLines[hunkStart]+1, newCount)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			fmt.Fprintf(output, "%c%s\n", op.Kind, op.Line)
+		}
+
+		start = hunkEnd
+	}
+
+	return output.String()
+}
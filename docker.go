@@ -0,0 +1,407 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Shuffle runs every app version as frikky/shuffle:<appname>_<version>
+const appImageBase = "frikky/shuffle"
+
+var useDocker bool
+
+// appImageName normalizes the app name the same way the Shuffle backend does
+func appImageName(name, version string) string {
+	parsedName := strings.ToLower(strings.TrimSpace(name))
+	parsedName = strings.Replace(parsedName, " ", "-", -1)
+	parsedName = strings.Replace(parsedName, ".", "-", -1)
+
+	return fmt.Sprintf("%s:%s_%s", appImageBase, parsedName, version)
+}
+
+// dockerClient talks to the Docker Engine API directly. DOCKER_HOST can point
+// to another unix socket (e.g. a fake one in tests) or a tcp:// address.
+func dockerClient() (*http.Client, string) {
+	dockerHost := os.Getenv("DOCKER_HOST")
+	if len(dockerHost) == 0 {
+		dockerHost = "unix:///var/run/docker.sock"
+	}
+
+	if strings.HasPrefix(dockerHost, "tcp://") {
+		return &http.Client{}, fmt.Sprintf("http://%s", strings.TrimPrefix(dockerHost, "tcp://"))
+	}
+
+	socketPath := strings.TrimPrefix(dockerHost, "unix://")
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	return client, "http://docker"
+}
+
+func dockerRequest(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	client, baseUrl := dockerClient()
+	req, err := http.NewRequestWithContext(ctx, method, baseUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Problem talking to Docker. Is it running? %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Docker %s %s: %s. Raw: %s", method, path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	return resp, nil
+}
+
+// tarFolder packs a folder as the docker build context
+func tarFolder(folderPath string) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buf)
+
+	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(folderPath, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(relativePath)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, tarWriter.Close()
+}
+
+// dockerBuildImage builds the app folder's Dockerfile and streams the build logs
+func dockerBuildImage(folderPath, tag string) error {
+	if _, err := os.Stat(filepath.Join(folderPath, "Dockerfile")); err != nil {
+		return fmt.Errorf("no Dockerfile in %s: %w", folderPath, err)
+	}
+
+	buildContext, err := tarFolder(folderPath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Building image %s from %s", tag, folderPath)
+	resp, err := dockerRequest(context.Background(), "POST", fmt.Sprintf("/build?t=%s&rm=1", url.QueryEscape(tag)), buildContext, "application/x-tar")
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		message := struct {
+			Stream string `json:"stream"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}{}
+
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return fmt.Errorf("Problem reading build output: %w", err)
+		}
+
+		if len(message.Error) > 0 {
+			return fmt.Errorf("Build failed: %s", strings.TrimSpace(message.Error))
+		}
+
+		if len(message.Stream) > 0 {
+			fmt.Print(message.Stream)
+		} else if len(message.Status) > 0 {
+			fmt.Println(message.Status)
+		}
+	}
+
+	log.Printf("[INFO] Built image %s", tag)
+	return nil
+}
+
+// dockerRunResult is the outcome of a finished or killed container
+type dockerRunResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+}
+
+// dockerRunContainer runs a container until it exits or the timeout hits, and
+// collects its logs. An empty cmd uses the image's own CMD.
+func dockerRunContainer(image string, cmd []string, env []string, timeout time.Duration) (dockerRunResult, error) {
+	result := dockerRunResult{}
+	createBody := map[string]interface{}{
+		"Image": image,
+		"Env":   env,
+	}
+
+	if len(cmd) > 0 {
+		createBody["Cmd"] = cmd
+	}
+
+	payload, err := json.Marshal(createBody)
+	if err != nil {
+		return result, err
+	}
+
+	resp, err := dockerRequest(context.Background(), "POST", "/containers/create", bytes.NewReader(payload), "application/json")
+	if err != nil {
+		return result, err
+	}
+
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil {
+		return result, err
+	}
+
+	defer func() {
+		resp, err := dockerRequest(context.Background(), "DELETE", fmt.Sprintf("/containers/%s?force=1", created.ID), nil, "")
+		if err != nil {
+			log.Printf("[WARNING] Problem removing container %s: %s", created.ID, err)
+			return
+		}
+
+		resp.Body.Close()
+	}()
+
+	resp, err = dockerRequest(context.Background(), "POST", fmt.Sprintf("/containers/%s/start", created.ID), nil, "")
+	if err != nil {
+		return result, err
+	}
+
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err = dockerRequest(ctx, "POST", fmt.Sprintf("/containers/%s/wait", created.ID), nil, "")
+	if err != nil {
+		if ctx.Err() != context.DeadlineExceeded {
+			return result, err
+		}
+
+		result.TimedOut = true
+		killResp, err := dockerRequest(context.Background(), "POST", fmt.Sprintf("/containers/%s/kill", created.ID), nil, "")
+		if err == nil {
+			killResp.Body.Close()
+		}
+	} else {
+		waited := struct {
+			StatusCode int `json:"StatusCode"`
+		}{}
+
+		err = json.NewDecoder(resp.Body).Decode(&waited)
+		resp.Body.Close()
+		if err != nil {
+			return result, err
+		}
+
+		result.ExitCode = waited.StatusCode
+	}
+
+	resp, err = dockerRequest(context.Background(), "GET", fmt.Sprintf("/containers/%s/logs?stdout=1&stderr=1", created.ID), nil, "")
+	if err != nil {
+		return result, err
+	}
+
+	defer resp.Body.Close()
+	stdout, stderr, err := demuxDockerLogs(resp.Body)
+	result.Stdout = stdout
+	result.Stderr = stderr
+	return result, err
+}
+
+// demuxDockerLogs splits the multiplexed log stream of a container without a TTY.
+// Every frame has an 8 byte header: stream type, 3 zero bytes, uint32 size.
+func demuxDockerLogs(reader io.Reader) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+
+			return stdout.String(), stderr.String(), err
+		}
+
+		size := binary.BigEndian.Uint32(header[4:])
+		target := &stdout
+		if header[0] == 2 {
+			target = &stderr
+		}
+
+		if _, err := io.CopyN(target, reader, int64(size)); err != nil {
+			return stdout.String(), stderr.String(), err
+		}
+	}
+
+	return stdout.String(), stderr.String(), nil
+}
+
+// buildAppImage parses api.yaml for the image name and builds it
+func buildAppImage(folderPath string) (string, error) {
+	folderPath = strings.TrimSuffix(folderPath, "/")
+	apiData, err := parseAPIYaml(fmt.Sprintf("%s/api.yaml", folderPath))
+	if err != nil {
+		return "", err
+	}
+
+	tag := appImageName(apiData.Name, apiData.AppVersion)
+	return tag, dockerBuildImage(folderPath, tag)
+}
+
+// validateAppInDocker builds the app image, checks that the app starts inside
+// it, and runs every action that has examples for all required parameters
+func validateAppInDocker(folderPath string) error {
+	tag, err := buildAppImage(folderPath)
+	if err != nil {
+		log.Printf("[ERROR] Problem building app image: %s", err)
+		return err
+	}
+
+	log.Printf("[DEBUG] Validating app by starting it in %s for up to %s.", tag, validationTimeout)
+	result, err := dockerRunContainer(tag, []string{"python3", "-c", appStartupScript, "app.py"}, []string{}, validationTimeout)
+	if err != nil {
+		return err
+	}
+
+	// Point errors at the local app.py rather than the one in the image
+	originalFile := filepath.Join(folderPath, "src", "app.py")
+	result.Stderr = remapTraceback(result.Stderr, "app.py", originalFile)
+	if pythonError := parsePythonTraceback(result.Stderr, originalFile); pythonError != nil {
+		logPythonOutput(result.Stdout, result.Stderr)
+		return pythonError
+	}
+
+	if result.TimedOut {
+		logPythonOutput(result.Stdout, result.Stderr)
+		return fmt.Errorf("app didn't get ready in the container within %s", validationTimeout)
+	}
+
+	if result.ExitCode != 0 || !strings.Contains(result.Stdout, appReadyMarker) {
+		logPythonOutput(result.Stdout, result.Stderr)
+		return fmt.Errorf("app didn't start in the container (exit status %d)", result.ExitCode)
+	}
+
+	apiData, err := parseAPIYaml(fmt.Sprintf("%s/api.yaml", strings.TrimSuffix(folderPath, "/")))
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, action := range apiData.Actions {
+		params, ok := exampleParameters(action)
+		if !ok {
+			log.Printf("[DEBUG] Skipping action %s in container: not all required parameters have an example", action.Name)
+			continue
+		}
+
+		actionResult, err := runAppAction(folderPath, action.Name, params, tag)
+		if err != nil {
+			log.Printf("[ERROR] Problem running action %s in container: %s", action.Name, err)
+			failed = append(failed, action.Name)
+			continue
+		}
+
+		if !actionResult.Success {
+			log.Printf("[ERROR] Action %s failed in container: %s\n%s", action.Name, actionResult.Error, actionResult.Stderr)
+			failed = append(failed, action.Name)
+			continue
+		}
+
+		log.Printf("[INFO] Action %s ran in container in %.2fs: %s", action.Name, actionResult.Duration, actionResult.Result)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Actions failed in container: %s", strings.Join(failed, ", "))
+	}
+
+	log.Printf("[INFO] App ran successfully in %s", tag)
+	return nil
+}
+
+var buildApp = &cobra.Command{
+	Use:   "build",
+	Short: "Builds the app image with the local Docker daemon",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No directory provided. Use the absolute path to the app directory.")
+			return
+		}
+
+		tag, err := buildAppImage(args[0])
+		if err != nil {
+			log.Printf("[ERROR] Problem building app image: %s", err)
+			os.Exit(1)
+		}
+
+		log.Printf("[INFO] Run it with: docker run --rm %s", tag)
+	},
+}
+
+func init() {
+	appCmd.AddCommand(buildApp)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dockerFrame builds one frame of a multiplexed container log stream
+func dockerFrame(stream byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	return append(header, data...)
+}
+
+// fakeDocker serves handler on a unix socket and points DOCKER_HOST to it
+func fakeDocker(t *testing.T, handler http.Handler) {
+	socketPath := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "unix://"+socketPath)
+}
+
+func TestDemuxDockerLogs(t *testing.T) {
+	tests := []struct {
+		name           string
+		stream         []byte
+		stdout, stderr string
+		fails          bool
+	}{
+		{"empty", nil, "", "", false},
+		{"interleaved", bytes.Join([][]byte{dockerFrame(1, "hello "), dockerFrame(2, "oops\n"), dockerFrame(1, "world\n")}, nil), "hello world\n", "oops\n", false},
+		{"empty frame", append(dockerFrame(1, ""), dockerFrame(2, "err")...), "", "err", false},
+		{"truncated header", append(dockerFrame(1, "done"), 1, 0, 0), "done", "", false},
+		{"truncated frame", dockerFrame(1, "cut short")[:12], "cut ", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr, err := demuxDockerLogs(bytes.NewReader(test.stream))
+			if (err != nil) != test.fails {
+				t.Fatalf("got error %v, expected failure: %t", err, test.fails)
+			}
+
+			if stdout != test.stdout || stderr != test.stderr {
+				t.Errorf("got stdout %q and stderr %q, expected %q and %q", stdout, stderr, test.stdout, test.stderr)
+			}
+		})
+	}
+}
+
+func TestTarFolder(t *testing.T) {
+	folder := t.TempDir()
+	files := map[string]string{
+		"Dockerfile":        "FROM python:3.10\n",
+		"api.yaml":          "name: test\n",
+		"src/app.py":        "print('hi')\n",
+		"src/lib/helper.py": "",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(folder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf, err := tarFolder(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]string{}
+	reader := tar.NewReader(buf)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			t.Errorf("%s has type %c, expected only regular files", header.Name, header.Typeflag)
+		}
+
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found[header.Name] = string(content)
+	}
+
+	if len(found) != len(files) {
+		t.Errorf("got %d files in the tar, expected %d: %v", len(found), len(files), found)
+	}
+
+	for name, content := range files {
+		if found[name] != content {
+			t.Errorf("%s: got %q, expected %q", name, found[name], content)
+		}
+	}
+}
+
+func TestDockerBuildImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		fails  string
+	}{
+		{"streams logs", `{"stream":"Step 1/2 : FROM python\n"}{"status":"Pulling"}{"stream":"Successfully built abc\n"}`, ""},
+		{"build error", `{"stream":"Step 1/2 : RUN false\n"}{"error":"The command returned a non-zero code: 1"}`, "Build failed: The command returned a non-zero code: 1"},
+		{"broken stream", `{"stream":"Step 1/2`, "Problem reading build output"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tag string
+			var context []string
+			fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/build" || r.Header.Get("Content-Type") != "application/x-tar" {
+					http.Error(w, "unexpected request", http.StatusNotFound)
+					return
+				}
+
+				tag = r.URL.Query().Get("t")
+				reader := tar.NewReader(r.Body)
+				for {
+					header, err := reader.Next()
+					if err != nil {
+						break
+					}
+
+					context = append(context, header.Name)
+				}
+
+				io.WriteString(w, test.output)
+			}))
+
+			folder := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(folder, "Dockerfile"), []byte("FROM python:3.10\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := dockerBuildImage(folder, "frikky/shuffle:test_1.0.0")
+			if len(test.fails) == 0 && err != nil {
+				t.Fatal(err)
+			}
+
+			if len(test.fails) > 0 && (err == nil || !strings.Contains(err.Error(), test.fails)) {
+				t.Fatalf("got error %v, expected %q", err, test.fails)
+			}
+
+			if tag != "frikky/shuffle:test_1.0.0" {
+				t.Errorf("built tag %q", tag)
+			}
+
+			if len(context) != 1 || context[0] != "Dockerfile" {
+				t.Errorf("got build context %v, expected only the Dockerfile", context)
+			}
+		})
+	}
+}
+
+func TestDockerBuildImageWithoutDockerfile(t *testing.T) {
+	if err := dockerBuildImage(t.TempDir(), "frikky/shuffle:test_1.0.0"); err == nil || !strings.Contains(err.Error(), "no Dockerfile") {
+		t.Fatalf("got error %v, expected a missing Dockerfile", err)
+	}
+}
+
+func TestDockerRunContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		waitFor  time.Duration
+		exitCode int
+		timedOut bool
+	}{
+		{"exits", 0, 3, false},
+		{"times out", time.Minute, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			calls := []string{}
+			var created map[string]interface{}
+			fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				calls = append(calls, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+				lock.Unlock()
+
+				switch {
+				case r.URL.Path == "/containers/create":
+					json.NewDecoder(r.Body).Decode(&created)
+					io.WriteString(w, `{"Id":"abc123"}`)
+				case strings.HasSuffix(r.URL.Path, "/wait"):
+					select {
+					case <-time.After(test.waitFor):
+						fmt.Fprintf(w, `{"StatusCode":%d}`, test.exitCode)
+					case <-r.Context().Done():
+					}
+				case strings.HasSuffix(r.URL.Path, "/logs"):
+					w.Write(append(dockerFrame(1, "ready\n"), dockerFrame(2, "warning\n")...))
+				case r.Method == "DELETE", strings.HasSuffix(r.URL.Path, "/start"), strings.HasSuffix(r.URL.Path, "/kill"):
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					http.Error(w, "unexpected request", http.StatusNotFound)
+				}
+			}))
+
+			result, err := dockerRunContainer("frikky/shuffle:test_1.0.0", []string{"python3", "app.py"}, []string{"A=b"}, 200*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.TimedOut != test.timedOut || result.ExitCode != test.exitCode {
+				t.Errorf("got exit code %d and timed out %t, expected %d and %t", result.ExitCode, result.TimedOut, test.exitCode, test.timedOut)
+			}
+
+			if result.Stdout != "ready\n" || result.Stderr != "warning\n" {
+				t.Errorf("got stdout %q and stderr %q", result.Stdout, result.Stderr)
+			}
+
+			if created["Image"] != "frikky/shuffle:test_1.0.0" {
+				t.Errorf("created container from %v", created["Image"])
+			}
+
+			expected := []string{"POST /containers/create", "POST /containers/abc123/start", "POST /containers/abc123/wait"}
+			if test.timedOut {
+				expected = append(expected, "POST /containers/abc123/kill")
+			}
+
+			expected = append(expected, "GET /containers/abc123/logs", "DELETE /containers/abc123")
+			lock.Lock()
+			defer lock.Unlock()
+			if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("got calls\n%s\nexpected\n%s", strings.Join(calls, "\n"), strings.Join(expected, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var recordCassettePath string
+var replayCassettePath string
+var fixturesPath string
+
+// Headers that are never written to a cassette, as cassettes end up in git
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "Api-Key", "X-Auth-Token"}
+
+// Query, form and JSON fields with exactly these names are redacted in
+// cassettes. Matching parts of names would hit fields like author or tokens_used.
+var secretFieldRegex = regexp.MustCompile(`(?i)^(pass(word|wd)?|secret|client[_-]?secret|token|access[_-]?token|refresh[_-]?token|api[_-]?key|authorization|private[_-]?key|cookie|session[_-]?id)$`)
+
+const redactedValue = "REDACTED"
+
+// Connection specific headers that shouldn't be forwarded or recorded
+var hopHeaders = []string{"Connection", "Proxy-Connection", "Keep-Alive", "Transfer-Encoding", "Content-Length", "Te", "Trailer", "Upgrade"}
+
+// Cassette is a recorded action run: the input, the output and every HTTP
+// interaction the action made on the way
+type Cassette struct {
+	App          string            `json:"app"`
+	Action       string            `json:"action"`
+	Parameters   map[string]string `json:"parameters"`
+	Success      bool              `json:"success"`
+	Result       string            `json:"result"`
+	RecordedAt   string            `json:"recorded_at"`
+	Interactions []Interaction     `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	Encoding string            `json:"encoding,omitempty"`
+}
+
+func loadCassette(path string) (Cassette, error) {
+	cassette := Cassette{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cassette, err
+	}
+
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return cassette, fmt.Errorf("Problem parsing cassette %s: %w", path, err)
+	}
+
+	return cassette, nil
+}
+
+func writeCassette(path string, cassette Cassette) error {
+	data, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// cassetteRedactor keeps secrets out of cassettes: fields with secret looking
+// names or the names of the app's authentication parameters, and the values
+// of those parameters wherever they show up
+type cassetteRedactor struct {
+	names  map[string]bool
+	values []string
+}
+
+// newCassetteRedactor reads the authentication parameters from the app's
+// api.yaml and picks the secret values out of params
+func newCassetteRedactor(appFolder string, params map[string]string) *cassetteRedactor {
+	redactor := &cassetteRedactor{names: map[string]bool{}}
+	if apiData, err := parseAPIYaml(filepath.Join(appFolder, "api.yaml")); err == nil {
+		for _, param := range apiData.Authentication.Parameters {
+			// The base URL is part of most authentications, but it isn't a
+			// secret, and replays need it to match requests
+			if strings.ToLower(param.Name) != "url" {
+				redactor.names[strings.ToLower(param.Name)] = true
+			}
+		}
+	}
+
+	for key, value := range params {
+		// Short values would replace too much unrelated text
+		if redactor.isSecret(key) && len(value) >= 4 && value != redactedValue {
+			redactor.values = append(redactor.values, value)
+		}
+	}
+
+	// Longest first, so a secret containing another is replaced whole
+	sort.Slice(redactor.values, func(i, j int) bool { return len(redactor.values[i]) > len(redactor.values[j]) })
+	return redactor
+}
+
+func (redactor *cassetteRedactor) isSecret(name string) bool {
+	return redactor.names[strings.ToLower(name)] || secretFieldRegex.MatchString(name)
+}
+
+// text replaces the secret parameter values
+func (redactor *cassetteRedactor) text(value string) string {
+	for _, secret := range redactor.values {
+		value = strings.Replace(value, secret, redactedValue, -1)
+		value = strings.Replace(value, url.QueryEscape(secret), redactedValue, -1)
+	}
+
+	return value
+}
+
+// query redacts the values of secret fields in a query string or form body,
+// keeping everything else as it was
+func (redactor *cassetteRedactor) query(rawQuery string) string {
+	parts := strings.Split(rawQuery, "&")
+	for index, part := range parts {
+		key, _, found := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(key); found && err == nil && redactor.isSecret(unescaped) {
+			parts[index] = key + "=" + redactedValue
+		}
+	}
+
+	return redactor.text(strings.Join(parts, "&"))
+}
+
+// url redacts the query of a URL. Replays redact incoming URLs the same way,
+// so they still match.
+func (redactor *cassetteRedactor) url(rawUrl string) string {
+	base, rawQuery, found := strings.Cut(rawUrl, "?")
+	if !found {
+		return redactor.text(rawUrl)
+	}
+
+	return redactor.text(base) + "?" + redactor.query(rawQuery)
+}
+
+// json redacts secret fields at any depth, and tells if it changed anything
+func (redactor *cassetteRedactor) json(value interface{}) bool {
+	changed := false
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range typed {
+			if _, isString := inner.(string); isString && redactor.isSecret(key) && inner != redactedValue {
+				typed[key] = redactedValue
+				changed = true
+			} else if redactor.json(inner) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, inner := range typed {
+			if redactor.json(inner) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// body redacts form and JSON bodies by field, and secret values in any text.
+// JSON is only re-encoded when a field was redacted.
+func (redactor *cassetteRedactor) body(body, contentType string) string {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return redactor.query(body)
+	}
+
+	var parsed interface{}
+	if strings.Contains(contentType, "json") && json.Unmarshal([]byte(body), &parsed) == nil && redactor.json(parsed) {
+		if data, err := json.Marshal(parsed); err == nil {
+			body = string(data)
+		}
+	}
+
+	return redactor.text(body)
+}
+
+// result redacts an action result like a JSON body. Recorded and replayed
+// results both go through it, so they compare equal.
+func (redactor *cassetteRedactor) result(result string) string {
+	return redactor.body(result, "application/json")
+}
+
+// parameters redacts the action parameters stored in a cassette
+func (redactor *cassetteRedactor) parameters(params map[string]string) map[string]string {
+	redacted := map[string]string{}
+	for key, value := range params {
+		redacted[key] = redactor.text(value)
+		if redactor.isSecret(key) {
+			redacted[key] = redactedValue
+		}
+	}
+
+	return redacted
+}
+
+func recordHeaders(header http.Header, redactor *cassetteRedactor) map[string]string {
+	headers := map[string]string{}
+	for key, values := range header {
+		key = http.CanonicalHeaderKey(key)
+		headers[key] = redactor.text(strings.Join(values, ", "))
+		for _, redacted := range redactedHeaders {
+			if key == redacted {
+				headers[key] = redactedValue
+			}
+		}
+	}
+
+	for _, hop := range hopHeaders {
+		delete(headers, hop)
+	}
+
+	return headers
+}
+
+// cassetteProxy is an HTTP(S) proxy for the python action. HTTPS is
+// intercepted with certificates from a throwaway CA that only the action trusts.
+// It either records what passes through, or replays it from the cassette.
+type cassetteProxy struct {
+	replay   bool
+	cassette *Cassette
+	redactor *cassetteRedactor
+	used     map[int]bool
+	misses   []string
+	mutex    sync.Mutex
+
+	caCert   *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	caFile   string
+	certs    map[string]*tls.Certificate
+	listener net.Listener
+	client   *http.Client
+}
+
+// startCassetteProxy listens on a random local port. Call env() for the
+// environment the action needs, and close() when done.
+func startCassetteProxy(cassette *Cassette, replay bool, redactor *cassetteRedactor) (*cassetteProxy, error) {
+	proxy := &cassetteProxy{
+		replay:   replay,
+		cassette: cassette,
+		redactor: redactor,
+		used:     map[int]bool{},
+		certs:    map[string]*tls.Certificate{},
+		client: &http.Client{
+			Transport: &http.Transport{Proxy: nil},
+			Timeout:   actionTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	if err := proxy.createCA(); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.Remove(proxy.caFile)
+		return nil, err
+	}
+
+	proxy.listener = listener
+	go http.Serve(listener, proxy)
+	return proxy, nil
+}
+
+func (proxy *cassetteProxy) close() {
+	proxy.listener.Close()
+	os.Remove(proxy.caFile)
+}
+
+// env points python's requests, urllib and httpx at the proxy and its CA
+func (proxy *cassetteProxy) env() []string {
+	proxyUrl := fmt.Sprintf("http://%s", proxy.listener.Addr().String())
+	env := []string{}
+	for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		env = append(env, fmt.Sprintf("%s=%s", key, proxyUrl))
+	}
+
+	for _, key := range []string{"REQUESTS_CA_BUNDLE", "SSL_CERT_FILE", "CURL_CA_BUNDLE"} {
+		env = append(env, fmt.Sprintf("%s=%s", key, proxy.caFile))
+	}
+
+	return append(env, "NO_PROXY=", "no_proxy=")
+}
+
+func (proxy *cassetteProxy) createCA() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "shufflecli cassette proxy"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	proxy.caCert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	proxy.caKey = key
+	caFile, err := ioutil.TempFile("", "shufflecli-ca-*.pem")
+	if err != nil {
+		return err
+	}
+
+	defer caFile.Close()
+	proxy.caFile = caFile.Name()
+	return pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// certificateFor signs a certificate for the host the action connects to
+func (proxy *cassetteProxy) certificateFor(host string) (*tls.Certificate, error) {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	if cert, ok := proxy.certs[host]; ok {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, proxy.caCert, &key.PublicKey, proxy.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	proxy.certs[host] = cert
+	return cert, nil
+}
+
+func (proxy *cassetteProxy) ServeHTTP(resp http.ResponseWriter, request *http.Request) {
+	if request.Method == http.MethodConnect {
+		proxy.handleConnect(resp, request)
+		return
+	}
+
+	if !request.URL.IsAbs() {
+		http.Error(resp, "shufflecli cassette proxy only handles proxy requests", http.StatusBadRequest)
+		return
+	}
+
+	response := proxy.roundTrip(request)
+	defer response.Body.Close()
+	for key, values := range response.Header {
+		resp.Header()[key] = values
+	}
+
+	resp.WriteHeader(response.StatusCode)
+	io.Copy(resp, response.Body)
+}
+
+// handleConnect terminates TLS for a CONNECT tunnel and handles the requests inside it
+func (proxy *cassetteProxy) handleConnect(resp http.ResponseWriter, request *http.Request) {
+	hijacker, ok := resp.(http.Hijacker)
+	if !ok {
+		http.Error(resp, "Can't intercept the connection", http.StatusInternalServerError)
+		return
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("[ERROR] Problem intercepting CONNECT to %s: %s", request.Host, err)
+		return
+	}
+
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	connectHost, port, err := net.SplitHostPort(request.Host)
+	if err != nil {
+		connectHost, port = request.Host, "443"
+	}
+
+	tlsConn := tls.Server(conn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if len(hello.ServerName) > 0 {
+				return proxy.certificateFor(hello.ServerName)
+			}
+
+			return proxy.certificateFor(connectHost)
+		},
+	})
+
+	defer tlsConn.Close()
+	reader := bufio.NewReader(tlsConn)
+	for {
+		innerRequest, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+
+		innerRequest.URL.Scheme = "https"
+		innerRequest.URL.Host = connectHost
+		if port != "443" {
+			innerRequest.URL.Host = net.JoinHostPort(connectHost, port)
+		}
+
+		response := proxy.roundTrip(innerRequest)
+		err = response.Write(tlsConn)
+		response.Body.Close()
+		if err != nil || innerRequest.Close {
+			return
+		}
+	}
+}
+
+// roundTrip answers a request from the cassette, or forwards and records it
+func (proxy *cassetteProxy) roundTrip(request *http.Request) *http.Response {
+	body, _ := ioutil.ReadAll(request.Body)
+	request.Body.Close()
+
+	recordedRequest := RecordedRequest{
+		Method:  request.Method,
+		URL:     proxy.redactor.url(request.URL.String()),
+		Headers: recordHeaders(request.Header, proxy.redactor),
+		Body:    proxy.redactor.body(string(body), request.Header.Get("Content-Type")),
+	}
+
+	if proxy.replay {
+		recorded, found := proxy.findInteraction(recordedRequest)
+		if !found {
+			log.Printf("[WARNING] No recorded response for %s %s", request.Method, recordedRequest.URL)
+			return makeResponse(request, http.StatusBadGateway, http.Header{"Content-Type": {"text/plain"}}, []byte(fmt.Sprintf("shufflecli: no recorded response for %s %s", request.Method, recordedRequest.URL)))
+		}
+
+		responseBody := []byte(recorded.Body)
+		if recorded.Encoding == "base64" {
+			responseBody, _ = base64.StdEncoding.DecodeString(recorded.Body)
+		}
+
+		header := http.Header{}
+		for key, value := range recorded.Headers {
+			header.Set(key, value)
+		}
+
+		return makeResponse(request, recorded.Status, header, responseBody)
+	}
+
+	outbound, err := http.NewRequest(request.Method, request.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return makeResponse(request, http.StatusBadGateway, http.Header{}, []byte(err.Error()))
+	}
+
+	outbound.Header = request.Header.Clone()
+	for _, hop := range hopHeaders {
+		outbound.Header.Del(hop)
+	}
+
+	// Let Go negotiate compression so recorded bodies are readable
+	outbound.Header.Del("Accept-Encoding")
+	response, err := proxy.client.Do(outbound)
+	if err != nil {
+		log.Printf("[WARNING] Problem recording %s %s: %s", request.Method, recordedRequest.URL, err)
+		return makeResponse(request, http.StatusBadGateway, http.Header{}, []byte(err.Error()))
+	}
+
+	defer response.Body.Close()
+	responseBody, _ := ioutil.ReadAll(response.Body)
+	recordedResponse := RecordedResponse{
+		Status:  response.StatusCode,
+		Headers: recordHeaders(response.Header, proxy.redactor),
+		Body:    proxy.redactor.body(string(responseBody), response.Header.Get("Content-Type")),
+	}
+
+	if !utf8.Valid(responseBody) {
+		recordedResponse.Body = base64.StdEncoding.EncodeToString(responseBody)
+		recordedResponse.Encoding = "base64"
+	}
+
+	proxy.mutex.Lock()
+	proxy.cassette.Interactions = append(proxy.cassette.Interactions, Interaction{Request: recordedRequest, Response: recordedResponse})
+	proxy.mutex.Unlock()
+
+	log.Printf("[DEBUG] Recorded %s %s: %d", request.Method, recordedRequest.URL, response.StatusCode)
+	header := response.Header.Clone()
+	for _, hop := range hopHeaders {
+		header.Del(hop)
+	}
+
+	return makeResponse(request, response.StatusCode, header, responseBody)
+}
+
+// findInteraction takes the first unused interaction with the same method and
+// URL. Once all are used, the last one is repeated.
+func (proxy *cassetteProxy) findInteraction(request RecordedRequest) (RecordedResponse, bool) {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	last := -1
+	for index, interaction := range proxy.cassette.Interactions {
+		if interaction.Request.Method != request.Method || interaction.Request.URL != request.URL {
+			continue
+		}
+
+		last = index
+		if !proxy.used[index] {
+			proxy.used[index] = true
+			return interaction.Response, true
+		}
+	}
+
+	if last >= 0 {
+		return proxy.cassette.Interactions[last].Response, true
+	}
+
+	proxy.misses = append(proxy.misses, fmt.Sprintf("%s %s", request.Method, request.URL))
+	return RecordedResponse{}, false
+}
+
+func makeResponse(request *http.Request, status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+}
+
+// sameResult compares action results, as JSON if both sides are JSON
+func sameResult(expected, actual string) bool {
+	var expectedJSON, actualJSON interface{}
+	if json.Unmarshal([]byte(expected), &expectedJSON) == nil && json.Unmarshal([]byte(actual), &actualJSON) == nil {
+		return reflect.DeepEqual(expectedJSON, actualJSON)
+	}
+
+	return strings.TrimSpace(expected) == strings.TrimSpace(actual)
+}
+
+// replayAction runs an action with its HTTP requests answered from a cassette.
+// Requests missing from the cassette are returned as problems. The result is
+// redacted the same way as the recorded one.
+func replayAction(appFolder, action string, params map[string]string, cassettePath string) (ActionRunResult, []string, error) {
+	cassette, err := loadCassette(cassettePath)
+	if err != nil {
+		return ActionRunResult{}, nil, err
+	}
+
+	redactor := newCassetteRedactor(appFolder, params)
+	proxy, err := startCassetteProxy(&cassette, true, redactor)
+	if err != nil {
+		return ActionRunResult{}, nil, err
+	}
+
+	defer proxy.close()
+	result, err := runAppAction(appFolder, action, params, "", proxy.env()...)
+	result.Result = redactor.result(result.Result)
+	problems := []string{}
+	if len(proxy.misses) > 0 {
+		problems = append(problems, fmt.Sprintf("unrecorded requests: %s", strings.Join(proxy.misses, ", ")))
+	}
+
+	return result, problems, err
+}
+
+// runFixtures replays every cassette in the fixtures folder against the app
+// and compares the results with the recorded ones
+func runFixtures(appFolder, fixturesFolder string) ([]CaseResult, error) {
+	if _, err := os.Stat(fixturesFolder); os.IsNotExist(err) && !filepath.IsAbs(fixturesFolder) {
+		fixturesFolder = filepath.Join(appFolder, fixturesFolder)
+	}
+
+	cassettePaths, err := filepath.Glob(filepath.Join(fixturesFolder, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cassettePaths) == 0 {
+		return nil, fmt.Errorf("no cassettes (*.json) found in %s", fixturesFolder)
+	}
+
+	sort.Strings(cassettePaths)
+	results := []CaseResult{}
+	for _, cassettePath := range cassettePaths {
+		caseResult := CaseResult{Name: filepath.Base(cassettePath), Source: "fixture"}
+		cassette, err := loadCassette(cassettePath)
+		if err != nil {
+			caseResult.Failures = []string{err.Error()}
+			results = append(results, caseResult)
+			continue
+		}
+
+		caseResult.Action = cassette.Action
+		result, problems, err := replayAction(appFolder, cassette.Action, cassette.Parameters, cassettePath)
+		caseResult.Duration = result.Duration
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else if result.Success != cassette.Success {
+			problems = append(problems, fmt.Sprintf("success was %t, expected %t (%s)", result.Success, cassette.Success, result.Error))
+		} else if !sameResult(cassette.Result, result.Result) {
+			problems = append(problems, fmt.Sprintf("result differs.\nExpected: %s\nActual:   %s", cassette.Result, result.Result))
+		}
+
+		caseResult.Failures = problems
+		caseResult.Passed = len(problems) == 0
+		results = append(results, caseResult)
+	}
+
+	return results, nil
+}
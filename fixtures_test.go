@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassetteRedactorIsSecret(t *testing.T) {
+	redactor := &cassetteRedactor{names: map[string]bool{"x-custom-key": true}}
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"password", true},
+		{"Passwd", true},
+		{"secret", true},
+		{"client_secret", true},
+		{"token", true},
+		{"access_token", true},
+		{"refresh-token", true},
+		{"api_key", true},
+		{"API-KEY", true},
+		{"apikey", true},
+		{"Authorization", true},
+		{"private_key", true},
+		{"cookie", true},
+		{"session_id", true},
+		{"X-Custom-Key", true},
+		{"author", false},
+		{"authenticated", false},
+		{"oauth_provider", false},
+		{"tokens_used", false},
+		{"session_count", false},
+		{"signature_version", false},
+		{"passwordless", false},
+		{"url", false},
+	}
+
+	for _, test := range tests {
+		if result := redactor.isSecret(test.name); result != test.expected {
+			t.Errorf("isSecret(%q) = %v, expected %v", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestCassetteRedactorResult(t *testing.T) {
+	redactor := newCassetteRedactor(t.TempDir(), map[string]string{"apikey": "s3cr3t-value", "query": "ip"})
+	tests := []struct {
+		result   string
+		expected string
+	}{
+		{`{"token": "abc", "author": "alice", "tokens_used": 0}`, `{"author":"alice","token":"REDACTED","tokens_used":0}`},
+		{`{"headers": {"Authorization": "Bearer s3cr3t-value"}}`, `{"headers":{"Authorization":"REDACTED"}}`},
+		{`{"echo": "key=s3cr3t-value"}`, `{"echo": "key=REDACTED"}`},
+		{`plain text with s3cr3t-value in it`, `plain text with REDACTED in it`},
+		{`{"ip": "10.0.0.1"}`, `{"ip": "10.0.0.1"}`},
+	}
+
+	for _, test := range tests {
+		if result := redactor.result(test.result); result != test.expected {
+			t.Errorf("result(%q) = %q, expected %q", test.result, result, test.expected)
+		}
+	}
+}
+
+// proxyGet sends a GET through the cassette proxy, the way python's requests
+// does with HTTP_PROXY set
+func proxyGet(t *testing.T, proxy *cassetteProxy, target string, header http.Header) string {
+	t.Helper()
+
+	proxyUrl, _ := url.Parse("http://" + proxy.listener.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	request, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header = header
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestCassetteRecordReplayRoundTrip(t *testing.T) {
+	const secret = "s3cr3t-api-value"
+
+	backend := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, request *http.Request) {
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Header().Set("Set-Cookie", "session=abc")
+		io.WriteString(resp, `{"token": "issued-token-123", "author": "alice", "tokens_used": 3, "query": "`+request.URL.Query().Get("api_key")+`"}`)
+	}))
+	defer backend.Close()
+
+	appFolder := t.TempDir()
+	apiYaml := "name: Test\napp_version: 1.0.0\nauthentication:\n  parameters:\n    - name: apikey\n    - name: url\n"
+	if err := os.WriteFile(filepath.Join(appFolder, "api.yaml"), []byte(apiYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Record
+	params := map[string]string{"apikey": secret, "url": backend.URL}
+	redactor := newCassetteRedactor(appFolder, params)
+	cassette := Cassette{Interactions: []Interaction{}}
+	proxy, err := startCassetteProxy(&cassette, false, redactor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := backend.URL + "/items?api_key=" + secret + "&page=2"
+	recordedBody := proxyGet(t, proxy, target, http.Header{"Authorization": {"Bearer " + secret}})
+	proxy.close()
+
+	if !strings.Contains(recordedBody, secret) {
+		t.Fatalf("the action should get the real response while recording, got %s", recordedBody)
+	}
+
+	actionResult := `{"status": 200, "body": ` + recordedBody + `, "apikey": "` + secret + `"}`
+	cassette.Parameters = redactor.parameters(params)
+	cassette.Result = redactor.result(actionResult)
+
+	cassettePath := filepath.Join(t.TempDir(), "cassette.json")
+	if err := writeCassette(cassettePath, cassette); err != nil {
+		t.Fatal(err)
+	}
+
+	written, _ := os.ReadFile(cassettePath)
+	if strings.Contains(string(written), secret) || strings.Contains(string(written), "issued-token-123") || strings.Contains(string(written), "session=abc") {
+		t.Errorf("cassette contains a secret:\n%s", written)
+	}
+
+	for _, kept := range []string{"alice", "tokens_used", "page=2", backend.URL} {
+		if !strings.Contains(string(written), kept) {
+			t.Errorf("cassette is missing %q, which isn't a secret:\n%s", kept, written)
+		}
+	}
+
+	// Replay with the parameters stored in the cassette, like --fixtures
+	loaded, err := loadCassette(cassettePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayRedactor := newCassetteRedactor(appFolder, loaded.Parameters)
+	replayProxy, err := startCassetteProxy(&loaded, true, replayRedactor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayTarget := loaded.Parameters["url"] + "/items?api_key=" + loaded.Parameters["apikey"] + "&page=2"
+	replayedBody := proxyGet(t, replayProxy, replayTarget, http.Header{"Authorization": {"Bearer " + loaded.Parameters["apikey"]}})
+	replayProxy.close()
+
+	if len(replayProxy.misses) > 0 {
+		t.Fatalf("replay missed requests: %v", replayProxy.misses)
+	}
+
+	replayedResult := `{"status": 200, "body": ` + replayedBody + `, "apikey": "` + loaded.Parameters["apikey"] + `"}`
+	if !sameResult(loaded.Result, replayRedactor.result(replayedResult)) {
+		t.Errorf("replayed result differs.\nRecorded: %s\nReplayed: %s", loaded.Result, replayRedactor.result(replayedResult))
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Limits for the large_image field in api.yaml. Shuffle renders app images
+// at 174x174, so anything far above that only bloats the app definition.
+const (
+	largeImageTargetSize = 174
+	largeImageMaxSize    = 512
+	largeImageMaxBytes   = 200 * 1024
+)
+
+// validateLargeImage decodes a large_image data URI and checks that it is a
+// PNG or JPEG within the size and dimension limits
+func validateLargeImage(value string) error {
+	value = strings.TrimSpace(value)
+	if len(value) > largeImageMaxBytes {
+		return fmt.Errorf("image is %d bytes, max is %d", len(value), largeImageMaxBytes)
+	}
+
+	mimetype, data, err := decodeDataURI(value)
+	if err != nil {
+		return err
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if strings.Contains(mimetype, "svg") || strings.HasPrefix(strings.TrimSpace(string(data)), "<") {
+			return fmt.Errorf("svg is not supported. Convert it with 'shufflecli app image set'")
+		}
+
+		return fmt.Errorf("can't decode image: %s", err)
+	}
+
+	if format != "png" && format != "jpeg" {
+		return fmt.Errorf("unsupported format %s. Only png and jpeg are supported", format)
+	}
+
+	if mimetype != fmt.Sprintf("image/%s", format) && !(format == "jpeg" && mimetype == "image/jpg") {
+		return fmt.Errorf("data URI says %s, but the image is %s", mimetype, format)
+	}
+
+	if config.Width > largeImageMaxSize || config.Height > largeImageMaxSize {
+		return fmt.Errorf("image is %dx%d, max is %dx%d", config.Width, config.Height, largeImageMaxSize, largeImageMaxSize)
+	}
+
+	return nil
+}
+
+// decodeDataURI splits a data URI into its mimetype and raw bytes. Payloads
+// without ";base64" are percent-encoded text, e.g. "data:image/svg+xml;utf8,<svg".
+func decodeDataURI(value string) (string, []byte, error) {
+	if !strings.HasPrefix(value, "data:") {
+		return "", nil, fmt.Errorf("not a data URI. Should start with 'data:image/png;base64,'")
+	}
+
+	header, payload, found := strings.Cut(value[len("data:"):], ",")
+	if !found {
+		return "", nil, fmt.Errorf("data URI is missing the ',' separator")
+	}
+
+	params := strings.Split(header, ";")
+	mimetype := strings.ToLower(strings.TrimSpace(params[0]))
+	if strings.EqualFold(params[len(params)-1], "base64") {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(payload))
+		if err != nil {
+			return "", nil, fmt.Errorf("bad base64 in data URI: %s", err)
+		}
+
+		return mimetype, data, nil
+	}
+
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("bad percent-encoding in data URI: %s", err)
+	}
+
+	return mimetype, []byte(data), nil
+}
+
+// imageToDataURI loads a PNG, JPEG or SVG file, scales it down to fit Shuffle's
+// image size and returns it as a PNG data URI
+func imageToDataURI(imagePath string) (string, error) {
+	var img image.Image
+	var err error
+
+	if strings.HasSuffix(strings.ToLower(imagePath), ".svg") {
+		img, err = rasterizeSvg(imagePath, largeImageTargetSize)
+	} else {
+		var file *os.File
+		file, err = os.Open(imagePath)
+		if err != nil {
+			return "", err
+		}
+
+		defer file.Close()
+		img, _, err = image.Decode(file)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("can't read image %s: %s", imagePath, err)
+	}
+
+	img = resizeImage(img, largeImageTargetSize)
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("data:image/png;base64,%s", base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// resizeImage scales an image down with box sampling so its longest side is
+// at most maxSize. Smaller images are returned untouched.
+func resizeImage(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return src
+	}
+
+	newWidth, newHeight := maxSize, maxSize
+	if width > height {
+		newHeight = max(1, height*maxSize/width)
+	} else if height > width {
+		newWidth = max(1, width*maxSize/height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		startY := bounds.Min.Y + y*height/newHeight
+		endY := max(startY+1, bounds.Min.Y+(y+1)*height/newHeight)
+
+		for x := 0; x < newWidth; x++ {
+			startX := bounds.Min.X + x*width/newWidth
+			endX := max(startX+1, bounds.Min.X+(x+1)*width/newWidth)
+
+			var r, g, b, a, count uint64
+			for sy := startY; sy < endY; sy++ {
+				for sx := startX; sx < endX; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					count++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / count),
+				G: uint16(g / count),
+				B: uint16(b / count),
+				A: uint16(a / count),
+			})
+		}
+	}
+
+	return dst
+}
+
+// rasterizeSvg converts an SVG to a PNG with whichever local tool is available.
+// Go has no SVG renderer in the standard library.
+func rasterizeSvg(svgPath string, size int) (image.Image, error) {
+	tmpDir, err := os.MkdirTemp("", "shufflecli-svg-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(tmpDir)
+	outPath := filepath.Join(tmpDir, "image.png")
+	sizeString := fmt.Sprintf("%d", size)
+	resizeString := fmt.Sprintf("%dx%d", size, size)
+
+	converters := [][]string{
+		{"rsvg-convert", "-w", sizeString, "-h", sizeString, "--keep-aspect-ratio", "-o", outPath, svgPath},
+		{"magick", "-background", "none", svgPath, "-resize", resizeString, outPath},
+		{"convert", "-background", "none", svgPath, "-resize", resizeString, outPath},
+		{"inkscape", svgPath, "--export-type=png", fmt.Sprintf("--export-filename=%s", outPath), "-w", sizeString},
+	}
+
+	for _, converter := range converters {
+		if _, err := exec.LookPath(converter[0]); err != nil {
+			continue
+		}
+
+		log.Printf("[DEBUG] Rasterizing %s with %s", svgPath, converter[0])
+		output, err := exec.Command(converter[0], converter[1:]...).CombinedOutput()
+		if err != nil {
+			log.Printf("[WARNING] %s failed to convert %s: %s. Output: %s", converter[0], svgPath, err, string(output))
+			continue
+		}
+
+		file, err := os.Open(outPath)
+		if err != nil {
+			return nil, err
+		}
+
+		defer file.Close()
+		return png.Decode(file)
+	}
+
+	return nil, fmt.Errorf("no SVG converter found. Install one of rsvg-convert, ImageMagick or Inkscape, or use a PNG/JPEG")
+}
+
+// setYamlTopLevelKey replaces a top-level scalar key in a YAML document without
+// touching the rest of the file. Multi-line values are replaced as a whole.
+func setYamlTopLevelKey(data []byte, key, value string) []byte {
+	lines := strings.Split(string(data), "\n")
+	newLine := fmt.Sprintf("%s: %s", key, value)
+
+	for index, line := range lines {
+		if !strings.HasPrefix(line, key+":") {
+			continue
+		}
+
+		// Skip continuation lines belonging to the old value
+		end := index + 1
+		for end < len(lines) && (strings.HasPrefix(lines[end], " ") || strings.HasPrefix(lines[end], "\t")) {
+			end++
+		}
+
+		newLines := append([]string{}, lines[:index]...)
+		newLines = append(newLines, newLine)
+		newLines = append(newLines, lines[end:]...)
+		return []byte(strings.Join(newLines, "\n"))
+	}
+
+	// Not found: put it after app_version to keep the header together
+	for index, line := range lines {
+		if strings.HasPrefix(line, "app_version:") {
+			newLines := append([]string{}, lines[:index+1]...)
+			newLines = append(newLines, newLine)
+			newLines = append(newLines, lines[index+1:]...)
+			return []byte(strings.Join(newLines, "\n"))
+		}
+	}
+
+	output := strings.TrimRight(string(data), "\n")
+	return []byte(fmt.Sprintf("%s\n%s\n", output, newLine))
+}
+
+var setAppImage = &cobra.Command{
+	Use:   "set",
+	Short: "Sets the large_image of an app from a PNG, JPEG or SVG file",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			log.Println("[ERROR] Usage: shufflecli app image set <app directory> <image file>")
+			return
+		}
+
+		apiFilePath := fmt.Sprintf("%s/api.yaml", strings.TrimSuffix(args[0], "/"))
+		yamlData, err := ioutil.ReadFile(apiFilePath)
+		if err != nil {
+			log.Printf("[ERROR] Problem reading %s: %s", apiFilePath, err)
+			return
+		}
+
+		dataURI, err := imageToDataURI(args[1])
+		if err != nil {
+			log.Printf("[ERROR] Problem converting image: %s", err)
+			return
+		}
+
+		if err := validateLargeImage(dataURI); err != nil {
+			log.Printf("[ERROR] Converted image is still invalid: %s", err)
+			return
+		}
+
+		yamlData = setYamlTopLevelKey(yamlData, "large_image", dataURI)
+		if err := ioutil.WriteFile(apiFilePath, yamlData, 0644); err != nil {
+			log.Printf("[ERROR] Problem writing %s: %s", apiFilePath, err)
+			return
+		}
+
+		log.Printf("[INFO] Wrote large_image (%d bytes) to %s", len(dataURI), apiFilePath)
+	},
+}
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "App image related commands",
+}
+
+func init() {
+	imageCmd.AddCommand(setAppImage)
+	appCmd.AddCommand(imageCmd)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func testPngDataURI(t *testing.T, width, height int) string {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecodeDataURI(t *testing.T) {
+	tests := []struct {
+		value    string
+		mimetype string
+		data     string
+		err      string
+	}{
+		{"data:image/png;base64,aGVsbG8=", "image/png", "hello", ""},
+		{"data:IMAGE/PNG;BASE64, aGVsbG8=\n", "image/png", "hello", ""},
+		{"data:image/svg+xml;utf8,<svg xmlns='http://www.w3.org/2000/svg'/>", "image/svg+xml", "<svg xmlns='http://www.w3.org/2000/svg'/>", ""},
+		{"data:image/svg+xml;charset=utf-8,%3Csvg%2F%3E", "image/svg+xml", "<svg/>", ""},
+		{"image/png;base64,aGVsbG8=", "", "", "not a data URI"},
+		{"data:image/png;base64", "", "", "missing the ','"},
+		{"data:image/png;base64,not base64!", "", "", "bad base64"},
+		{"data:image/svg+xml;utf8,%zz", "", "", "bad percent-encoding"},
+	}
+
+	for _, test := range tests {
+		mimetype, data, err := decodeDataURI(test.value)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("decodeDataURI(%q) error = %v, expected %q", test.value, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("decodeDataURI(%q) failed: %s", test.value, err)
+			continue
+		}
+
+		if mimetype != test.mimetype || string(data) != test.data {
+			t.Errorf("decodeDataURI(%q) = %q, %q, expected %q, %q", test.value, mimetype, data, test.mimetype, test.data)
+		}
+	}
+}
+
+func TestValidateLargeImage(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{testPngDataURI(t, 174, 174), ""},
+		{testPngDataURI(t, 600, 100), "max is 512x512"},
+		{strings.Replace(testPngDataURI(t, 10, 10), "image/png", "image/jpeg", 1), "data URI says image/jpeg"},
+		{"data:image/svg+xml;utf8,<svg xmlns='http://www.w3.org/2000/svg'/>", "svg is not supported"},
+		{"data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte("<svg/>")), "svg is not supported"},
+		{"data:image/png;base64,aGVsbG8=", "can't decode image"},
+	}
+
+	for _, test := range tests {
+		err := validateLargeImage(test.value)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("validateLargeImage(%.40q) failed: %s", test.value, err)
+			}
+
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("validateLargeImage(%.40q) error = %v, expected %q", test.value, err, test.err)
+		}
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	tests := []struct {
+		width, height                 int
+		expectedWidth, expectedHeight int
+	}{
+		{100, 50, 100, 50},
+		{174, 174, 174, 174},
+		{348, 348, 174, 174},
+		{400, 200, 174, 87},
+		{200, 400, 87, 174},
+		{2000, 5, 174, 1},
+	}
+
+	for _, test := range tests {
+		src := image.NewRGBA(image.Rect(0, 0, test.width, test.height))
+		result := resizeImage(src, largeImageTargetSize)
+
+		bounds := result.Bounds()
+		if bounds.Dx() != test.expectedWidth || bounds.Dy() != test.expectedHeight {
+			t.Errorf("resizeImage(%dx%d) = %dx%d, expected %dx%d", test.width, test.height, bounds.Dx(), bounds.Dy(), test.expectedWidth, test.expectedHeight)
+		}
+
+		if test.width <= largeImageTargetSize && test.height <= largeImageTargetSize && result != image.Image(src) {
+			t.Errorf("resizeImage(%dx%d) should return small images untouched", test.width, test.height)
+		}
+	}
+}
+
+func TestResizeImageAveragesPixels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		src.Set(x, 0, color.RGBA{R: 255, A: 255})
+		src.Set(x, 1, color.RGBA{B: 255, A: 255})
+	}
+
+	result := resizeImage(src, 2)
+	if result.Bounds().Dx() != 2 || result.Bounds().Dy() != 1 {
+		t.Fatalf("resizeImage(4x2, 2) = %v, expected 2x1", result.Bounds())
+	}
+
+	r, g, b, a := result.At(0, 0).RGBA()
+	if r>>8 != 127 || g != 0 || b>>8 != 127 || a>>8 != 255 {
+		t.Errorf("resizeImage averaged pixel = %d,%d,%d,%d, expected 127,0,127,255", r>>8, g>>8, b>>8, a>>8)
+	}
+}
+
+func TestSetYamlTopLevelKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			"replaces existing value",
+			"name: test\nlarge_image: data:old\ndescription: hi\n",
+			"name: test\nlarge_image: NEW\ndescription: hi\n",
+		},
+		{
+			"replaces continuation lines",
+			"name: test\nlarge_image: >\n  data:image/png;base64,\n  AAAA\ndescription: hi\n",
+			"name: test\nlarge_image: NEW\ndescription: hi\n",
+		},
+		{
+			"leaves nested keys alone",
+			"name: test\napp_version: 1.0.0\nactions:\n  - large_image: keep\n",
+			"name: test\napp_version: 1.0.0\nlarge_image: NEW\nactions:\n  - large_image: keep\n",
+		},
+		{
+			"appends without app_version",
+			"name: test\ndescription: hi\n\n",
+			"name: test\ndescription: hi\nlarge_image: NEW\n",
+		},
+	}
+
+	for _, test := range tests {
+		result := string(setYamlTopLevelKey([]byte(test.data), "large_image", "NEW"))
+		if result != test.expected {
+			t.Errorf("%s: setYamlTopLevelKey = %q, expected %q", test.name, result, test.expected)
+		}
+	}
+}
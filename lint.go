@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// securityRule is a line based check for app.py. Suppress a rule on a line by
+// adding "# shufflecli: ignore[SHF001]" on it or on the line above. A bare
+// "# shufflecli: ignore" suppresses every rule.
+type securityRule struct {
+	ID       string
+	Severity string
+	Message  string
+	Pattern  *regexp.Regexp
+	Exclude  *regexp.Regexp
+}
+
+var securityRules = []securityRule{
+	{
+		ID:       "SHF001",
+		Severity: severityWarning,
+		Message:  "TLS verification disabled with verify=False. Make it a parameter (e.g. ssl_verify) instead",
+		Pattern:  regexp.MustCompile(`\bverify\s*=\s*False\b`),
+	},
+	{
+		ID:       "SHF002",
+		Severity: severityError,
+		Message:  "subprocess call with shell=True can lead to command injection from parameters",
+		Pattern:  regexp.MustCompile(`\bshell\s*=\s*True\b`),
+	},
+	{
+		ID:       "SHF003",
+		Severity: severityError,
+		Message:  "os.system/os.popen runs through the shell. Use subprocess with a list of arguments",
+		Pattern:  regexp.MustCompile(`\bos\.(system|popen)\s*\(`),
+	},
+	{
+		ID:       "SHF004",
+		Severity: severityError,
+		Message:  "eval/exec on a non-literal value can run code from parameters",
+		Pattern:  regexp.MustCompile(`(^|[^\w.])(eval|exec)\s*\(\s*[^"'\s)]`),
+	},
+	{
+		ID:       "SHF005",
+		Severity: severityError,
+		Message:  "Hard-coded secret. Use an authentication parameter in api.yaml instead",
+		Pattern:  regexp.MustCompile(`(?i)\b[\w]*(api_?key|apikey|token|secret|passw(or)?d|pwd|auth(orization)?)\w*\s*[:=]\s*["'][^"'\s]{8,}["']`),
+		Exclude:  regexp.MustCompile(`(?i)[:=]\s*["'](https?://|/|\$|\{|<)`),
+	},
+	{
+		ID:       "SHF006",
+		Severity: severityWarning,
+		Message:  "Secret value may be logged. Logs are visible to everyone with workflow access",
+		Pattern:  regexp.MustCompile(`(?i)\b(print|logger\.\w+|logging\.\w+)\s*\(.*\b(api_?key|apikey|token|secret|passw(or)?d|authorization|auth_header)\b`),
+	},
+	{
+		ID:       "SHF007",
+		Severity: severityError,
+		Message:  "Hard-coded token matching a known format (AWS, GitHub, Slack, OpenAI or a JWT)",
+		Pattern:  regexp.MustCompile(`\b(AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36}|xox[abposr]-[A-Za-z0-9-]{10,}|sk-[A-Za-z0-9]{32,}|eyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]+)`),
+	},
+}
+
+var suppressionRegex = regexp.MustCompile(`#\s*shufflecli:\s*ignore(\[([\w,\s]+)\])?`)
+
+// lintPythonSecurity runs the security rules against a python file
+func lintPythonSecurity(pythonFilePath string) ([]Finding, error) {
+	data, err := ioutil.ReadFile(pythonFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	lines := strings.Split(string(data), "\n")
+	for index, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		foundRules := map[string]bool{}
+		for _, rule := range securityRules {
+			if foundRules[rule.ID] || !rule.Pattern.MatchString(line) {
+				continue
+			}
+
+			if rule.Exclude != nil && rule.Exclude.MatchString(line) {
+				continue
+			}
+
+			if isSuppressed(rule.ID, line) || (index > 0 && isSuppressed(rule.ID, lines[index-1])) {
+				continue
+			}
+
+			foundRules[rule.ID] = true
+			findings = append(findings, Finding{
+				Rule:     rule.ID,
+				Severity: rule.Severity,
+				File:     pythonFilePath,
+				Line:     index + 1,
+				Message:  rule.Message,
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// isSuppressed checks a line for a "# shufflecli: ignore" comment covering the rule
+func isSuppressed(ruleID, line string) bool {
+	match := suppressionRegex.FindStringSubmatch(line)
+	if match == nil {
+		return false
+	}
+
+	if len(match[2]) == 0 {
+		return true
+	}
+
+	for _, suppressed := range strings.Split(match[2], ",") {
+		if strings.TrimSpace(suppressed) == ruleID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var writeMigration bool
+
+// The SDK image current apps build from
+const appSdkImage = "frikky/shuffle:app_sdk"
+
+var asyncDefRegex = regexp.MustCompile(`^(\s+)async def (\w+)\(`)
+var awaitRegex = regexp.MustCompile(`\bawait\s+`)
+var awaitSelfRegex = regexp.MustCompile(`\bawait\s+self\.(\w+)\(`)
+var awaitSleepRegex = regexp.MustCompile(`\bawait\s+asyncio\.sleep\(`)
+var asyncioRunRegex = regexp.MustCompile(`asyncio\.run\(\s*([A-Za-z_]\w*)\.run\(\)\s*(?:,\s*debug\s*=\s*\w+\s*)?\)`)
+var runUntilCompleteRegex = regexp.MustCompile(`\w+\.run_until_complete\(\s*([A-Za-z_]\w*)\.run\(\)\s*\)`)
+var getEventLoopRegex = regexp.MustCompile(`^\s*(\w+)\s*=\s*asyncio\.get_event_loop\(\)\s*$`)
+var dockerFromRegex = regexp.MustCompile(`(?i)^(\s*FROM\s+)(\S+)(.*)$`)
+var oldPythonImageRegex = regexp.MustCompile(`^python:(?:2|3\.[0-7])(?:\.\d+)*(-\S+)?$`)
+
+// migratedFile is one file the migration wants to change
+type migratedFile struct {
+	Path     string
+	Original string
+	Migrated string
+	Notes    []string
+}
+
+// indentOf counts leading spaces, with tabs as 4
+func indentOf(line string) int {
+	indent := 0
+	for _, char := range line {
+		if char == ' ' {
+			indent++
+		} else if char == '\t' {
+			indent += 4
+		} else {
+			break
+		}
+	}
+
+	return indent
+}
+
+// functionEnd returns the index after the last line of the function starting at start
+func functionEnd(lines []string, start int) int {
+	indent := indentOf(lines[start])
+	end := start + 1
+	for ; end < len(lines); end++ {
+		trimmed := strings.TrimSpace(lines[end])
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if indentOf(lines[end]) <= indent && !strings.HasPrefix(trimmed, ")") {
+			break
+		}
+	}
+
+	return end
+}
+
+// migratePython moves app.py from walkoff_app_sdk to shuffle_sdk: imports,
+// the run() entrypoint and async methods that don't need to be async
+func migratePython(source string) (string, []string) {
+	notes := []string{}
+	lines := strings.Split(source, "\n")
+
+	for index, line := range lines {
+		if strings.Contains(line, "walkoff_app_sdk.app_base") {
+			lines[index] = strings.Replace(line, "from walkoff_app_sdk.app_base import", "from shuffle_sdk import", -1)
+		}
+
+		lines[index] = asyncioRunRegex.ReplaceAllString(lines[index], "${1}.run()")
+		lines[index] = runUntilCompleteRegex.ReplaceAllString(lines[index], "${1}.run()")
+	}
+
+	// Methods can be made sync if all they await is other methods that
+	// become sync, or asyncio.sleep
+	methods := map[string]int{}
+	for index, line := range lines {
+		if match := asyncDefRegex.FindStringSubmatch(line); match != nil {
+			methods[match[2]] = index
+		}
+	}
+
+	convert := map[string]bool{}
+	for name := range methods {
+		convert[name] = true
+	}
+
+	// A method also stays async if code that stays async uses it, or if it's
+	// used as a coroutine without await, e.g. in asyncio.gather()
+	usedAsCoroutine := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for name, start := range methods {
+			if !convert[name] {
+				continue
+			}
+
+			for _, line := range lines[start+1 : functionEnd(lines, start)] {
+				remaining := awaitSleepRegex.ReplaceAllString(line, "")
+				for _, match := range awaitSelfRegex.FindAllStringSubmatch(remaining, -1) {
+					if convert[match[1]] {
+						remaining = strings.Replace(remaining, match[0], "", 1)
+					}
+				}
+
+				if awaitRegex.MatchString(remaining) {
+					convert[name] = false
+					changed = true
+					break
+				}
+			}
+
+			if convert[name] && coroutineUse(lines, methods, convert, name) {
+				convert[name] = false
+				usedAsCoroutine[name] = true
+				changed = true
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range methods {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	usesSleep := false
+	for _, name := range names {
+		if usedAsCoroutine[name] {
+			notes = append(notes, fmt.Sprintf("%s is used as a coroutine by async code and stays async. Migrate it by hand.", name))
+			continue
+		}
+
+		if !convert[name] {
+			notes = append(notes, fmt.Sprintf("%s awaits other coroutines and stays async. Migrate it by hand.", name))
+			continue
+		}
+
+		lines[methods[name]] = strings.Replace(lines[methods[name]], "async def ", "def ", 1)
+	}
+
+	for index, line := range lines {
+		line = awaitSelfRegex.ReplaceAllStringFunc(line, func(match string) string {
+			if convert[awaitSelfRegex.FindStringSubmatch(match)[1]] {
+				return strings.TrimLeft(strings.TrimPrefix(match, "await"), " \t")
+			}
+
+			return match
+		})
+
+		if awaitSleepRegex.MatchString(line) {
+			// Only sleeps outside async methods can become time.sleep
+			inAsync := false
+			for name, start := range methods {
+				if !convert[name] && index > start && index < functionEnd(lines, start) {
+					inAsync = true
+				}
+			}
+
+			if !inAsync {
+				line = awaitSleepRegex.ReplaceAllString(line, "time.sleep(")
+				usesSleep = true
+			}
+		}
+
+		lines[index] = line
+	}
+
+	// Remove the event loop variable and asyncio import if nothing uses them anymore
+	source = strings.Join(lines, "\n")
+	for index, line := range lines {
+		if match := getEventLoopRegex.FindStringSubmatch(line); match != nil {
+			if len(regexp.MustCompile(`\b`+match[1]+`\b`).FindAllString(source, -1)) == 1 {
+				lines[index] = "\x00"
+			}
+		}
+	}
+
+	lines = removeMarkedLines(lines)
+	source = strings.Join(lines, "\n")
+	if strings.Count(source, "asyncio") == 1 {
+		for index, line := range lines {
+			if strings.TrimSpace(line) == "import asyncio" {
+				lines[index] = "\x00"
+			}
+		}
+
+		lines = removeMarkedLines(lines)
+	}
+
+	if usesSleep && !regexp.MustCompile(`(?m)^import time\s*$`).MatchString(strings.Join(lines, "\n")) {
+		// Before the first import, but after the docstring and __future__
+		// imports, which have to come first
+		insertAt := docstringEnd(lines)
+		for index := insertAt; index < len(lines); index++ {
+			if strings.HasPrefix(lines[index], "from __future__ ") {
+				insertAt = index + 1
+			} else if strings.HasPrefix(lines[index], "import ") || strings.HasPrefix(lines[index], "from ") {
+				insertAt = index
+				break
+			}
+		}
+
+		lines = append(lines[:insertAt], append([]string{"import time"}, lines[insertAt:]...)...)
+	}
+
+	return strings.Join(lines, "\n"), notes
+}
+
+// coroutineUse is true if the method is called from a method that stays
+// async, or anywhere without await
+func coroutineUse(lines []string, methods map[string]int, convert map[string]bool, name string) bool {
+	callRegex := regexp.MustCompile(`\bself\.` + name + `\(`)
+	awaitedRegex := regexp.MustCompile(`\bawait\s+self\.` + name + `\(`)
+	for index, line := range lines {
+		calls := len(callRegex.FindAllString(line, -1))
+		if calls == 0 {
+			continue
+		}
+
+		if calls > len(awaitedRegex.FindAllString(line, -1)) {
+			return true
+		}
+
+		for caller, start := range methods {
+			if caller != name && !convert[caller] && index > start && index < functionEnd(lines, start) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// docstringEnd returns the index after the module docstring, or after the
+// leading comments if there is none
+func docstringEnd(lines []string) int {
+	for index, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		quote := ""
+		for _, candidate := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(strings.TrimLeft(trimmed, "rRuU"), candidate) {
+				quote = candidate
+			}
+		}
+
+		if len(quote) == 0 {
+			return index
+		}
+
+		if strings.Count(trimmed, quote) >= 2 {
+			return index + 1
+		}
+
+		for end := index + 1; end < len(lines); end++ {
+			if strings.Contains(lines[end], quote) {
+				return end + 1
+			}
+		}
+
+		return len(lines)
+	}
+
+	return len(lines)
+}
+
+func removeMarkedLines(lines []string) []string {
+	kept := []string{}
+	for _, line := range lines {
+		if line != "\x00" {
+			kept = append(kept, line)
+		}
+	}
+
+	return kept
+}
+
+// migrateDockerfile moves old SDK and python base images to current ones
+func migrateDockerfile(source string) (string, []string) {
+	notes := []string{}
+	lines := strings.Split(source, "\n")
+	for index, line := range lines {
+		match := dockerFromRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		image := match[2]
+		if strings.Contains(image, "walkoff_app_sdk") || strings.Contains(image, "walkoff-app-sdk") {
+			lines[index] = match[1] + appSdkImage + match[3]
+		} else if imageMatch := oldPythonImageRegex.FindStringSubmatch(image); imageMatch != nil {
+			lines[index] = match[1] + "python:3.11" + imageMatch[1] + match[3]
+			notes = append(notes, fmt.Sprintf("%s is end of life and was moved to python:3.11%s. Check that requirements still install.", image, imageMatch[1]))
+		}
+	}
+
+	return strings.Join(lines, "\n"), notes
+}
+
+// migrateRequirements swaps the walkoff_app_sdk package for shuffle_sdk
+func migrateRequirements(source string) (string, []string) {
+	lines := strings.Split(source, "\n")
+	for index, line := range lines {
+		requirement := strings.TrimSpace(line)
+		name := normalizePackageName(regexp.MustCompile(`^[A-Za-z0-9._-]+`).FindString(requirement))
+		if name == "walkoff-app-sdk" {
+			lines[index] = "shuffle_sdk"
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// findAppFolders returns every app version folder (with an api.yaml) under root
+func findAppFolders(root string) ([]string, error) {
+	folders := []string{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() && (entry.Name() == ".git" || entry.Name() == "node_modules") {
+			return filepath.SkipDir
+		}
+
+		if !entry.IsDir() && entry.Name() == "api.yaml" {
+			folders = append(folders, filepath.Dir(path))
+		}
+
+		return nil
+	})
+
+	sort.Strings(folders)
+	return folders, err
+}
+
+// migrateAppFolder runs every migration on an app version folder
+func migrateAppFolder(folderPath string) ([]migratedFile, error) {
+	migrations := []struct {
+		path    string
+		migrate func(string) (string, []string)
+	}{
+		{filepath.Join(folderPath, "src", "app.py"), migratePython},
+		{filepath.Join(folderPath, "Dockerfile"), migrateDockerfile},
+		{filepath.Join(folderPath, "requirements.txt"), migrateRequirements},
+	}
+
+	changed := []migratedFile{}
+	for _, migration := range migrations {
+		data, err := ioutil.ReadFile(migration.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return changed, err
+		}
+
+		migrated, notes := migration.migrate(string(data))
+		if migrated == string(data) && len(notes) == 0 {
+			continue
+		}
+
+		changed = append(changed, migratedFile{Path: migration.path, Original: string(data), Migrated: migrated, Notes: notes})
+	}
+
+	return changed, nil
+}
+
+var migrateApp = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrates apps from walkoff_app_sdk to shuffle_sdk: migrate <app directory|apps repo>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No directory provided. Use an app version directory or a folder of apps.")
+			return
+		}
+
+		folders, err := findAppFolders(args[0])
+		if err != nil {
+			log.Printf("[ERROR] Problem finding apps in %s: %s", args[0], err)
+			os.Exit(1)
+		}
+
+		if len(folders) == 0 {
+			log.Printf("[ERROR] No api.yaml found in %s", args[0])
+			os.Exit(1)
+		}
+
+		changedFiles := 0
+		for _, folder := range folders {
+			files, err := migrateAppFolder(folder)
+			if err != nil {
+				log.Printf("[ERROR] Problem migrating %s: %s", folder, err)
+				continue
+			}
+
+			for _, file := range files {
+				relativePath, err := filepath.Rel(args[0], file.Path)
+				if err != nil {
+					relativePath = file.Path
+				}
+
+				for _, note := range file.Notes {
+					log.Printf("[WARNING] %s: %s", relativePath, note)
+				}
+
+				diff := unifiedDiff(filepath.ToSlash(relativePath), file.Original, file.Migrated)
+				if len(diff) == 0 {
+					continue
+				}
+
+				changedFiles += 1
+				fmt.Print(diff)
+				if writeMigration {
+					if err := ioutil.WriteFile(file.Path, []byte(file.Migrated), 0644); err != nil {
+						log.Printf("[ERROR] Problem writing %s: %s", file.Path, err)
+					}
+				}
+			}
+		}
+
+		if changedFiles == 0 {
+			log.Printf("[INFO] %d app version(s) are already migrated", len(folders))
+		} else if writeMigration {
+			log.Printf("[INFO] Migrated %d file(s) in %d app version(s)", changedFiles, len(folders))
+		} else {
+			log.Printf("[INFO] %d file(s) would change. Run again with --write to apply.", changedFiles)
+		}
+	},
+}
+
+func init() {
+	appCmd.AddCommand(migrateApp)
+
+	migrateApp.Flags().BoolVar(&writeMigration, "write", false, "Write the changes instead of only showing the diff")
+}
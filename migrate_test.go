@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigratePython(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+		notes    int
+	}{
+		{
+			name: "imports and entrypoint",
+			source: `import asyncio
+from walkoff_app_sdk.app_base import AppBase
+
+class Test(AppBase):
+    async def hello(self, name):
+        return name
+
+if __name__ == "__main__":
+    asyncio.run(Test.run(), debug=True)`,
+			expected: `from shuffle_sdk import AppBase
+
+class Test(AppBase):
+    def hello(self, name):
+        return name
+
+if __name__ == "__main__":
+    Test.run()`,
+		},
+		{
+			name: "event loop entrypoint",
+			source: `import asyncio
+from walkoff_app_sdk.app_base import AppBase
+
+if __name__ == "__main__":
+    loop = asyncio.get_event_loop()
+    loop.run_until_complete(Test.run())`,
+			expected: `from shuffle_sdk import AppBase
+
+if __name__ == "__main__":
+    Test.run()`,
+		},
+		{
+			name: "awaited methods and sleep",
+			source: `"""Test app"""
+import asyncio
+from walkoff_app_sdk.app_base import AppBase
+
+class Test(AppBase):
+    async def helper(self):
+        await asyncio.sleep(1)
+        return 1
+
+    async def action(self):
+        return await self.helper()`,
+			expected: `"""Test app"""
+import time
+from shuffle_sdk import AppBase
+
+class Test(AppBase):
+    def helper(self):
+        time.sleep(1)
+        return 1
+
+    def action(self):
+        return self.helper()`,
+		},
+		{
+			name: "time import after __future__ and docstring",
+			source: `"""
+import this docstring line isn't an import
+"""
+from __future__ import annotations
+from walkoff_app_sdk.app_base import AppBase
+import asyncio
+
+class Test(AppBase):
+    async def action(self):
+        await asyncio.sleep(1)`,
+			expected: `"""
+import this docstring line isn't an import
+"""
+from __future__ import annotations
+import time
+from shuffle_sdk import AppBase
+
+class Test(AppBase):
+    def action(self):
+        time.sleep(1)`,
+		},
+		{
+			name: "other coroutines stay async",
+			source: `from walkoff_app_sdk.app_base import AppBase
+
+class Test(AppBase):
+    async def action(self):
+        return await self.client.get()`,
+			expected: `from shuffle_sdk import AppBase
+
+class Test(AppBase):
+    async def action(self):
+        return await self.client.get()`,
+			notes: 1,
+		},
+		{
+			name: "gathered coroutines stay async",
+			source: `import asyncio
+from walkoff_app_sdk.app_base import AppBase
+
+class Test(AppBase):
+    async def fetch(self, item):
+        return item
+
+    async def action(self):
+        return await asyncio.gather(self.fetch(1), self.fetch(2))`,
+			expected: `import asyncio
+from shuffle_sdk import AppBase
+
+class Test(AppBase):
+    async def fetch(self, item):
+        return item
+
+    async def action(self):
+        return await asyncio.gather(self.fetch(1), self.fetch(2))`,
+			notes: 2,
+		},
+		{
+			name: "awaited by a method that stays async",
+			source: `from walkoff_app_sdk.app_base import AppBase
+
+class Test(AppBase):
+    async def helper(self):
+        return 1
+
+    async def action(self):
+        value = await self.helper()
+        return await self.client.get(value)`,
+			expected: `from shuffle_sdk import AppBase
+
+class Test(AppBase):
+    async def helper(self):
+        return 1
+
+    async def action(self):
+        value = await self.helper()
+        return await self.client.get(value)`,
+			notes: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrated, notes := migratePython(test.source)
+			if migrated != test.expected {
+				t.Errorf("got\n%s\n\nexpected\n%s", migrated, test.expected)
+			}
+
+			if len(notes) != test.notes {
+				t.Errorf("got notes %v, expected %d", notes, test.notes)
+			}
+		})
+	}
+}
+
+func TestMigrateDockerfile(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+		notes    int
+	}{
+		{"FROM frikky/shuffle:walkoff_app_sdk as base\nRUN pip install x", "FROM frikky/shuffle:app_sdk as base\nRUN pip install x", 0},
+		{"from python:3.7-alpine\n", "from python:3.11-alpine\n", 1},
+		{"FROM python:2.7.18\n", "FROM python:3.11\n", 1},
+		{"FROM python:3.10-slim\n", "FROM python:3.10-slim\n", 0},
+		{"FROM frikky/shuffle:app_sdk\n", "FROM frikky/shuffle:app_sdk\n", 0},
+	}
+
+	for _, test := range tests {
+		migrated, notes := migrateDockerfile(test.source)
+		if migrated != test.expected {
+			t.Errorf("migrateDockerfile(%q) = %q, expected %q", test.source, migrated, test.expected)
+		}
+
+		if len(notes) != test.notes {
+			t.Errorf("migrateDockerfile(%q) gave notes %v, expected %d", test.source, notes, test.notes)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		changed  string
+		expected string
+	}{
+		{"unchanged", "a\nb", "a\nb", ""},
+		{
+			"changed line",
+			"a\nb\nc",
+			"a\nB\nc",
+			"--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			"added line",
+			"a\nb",
+			"a\nnew\nb",
+			"--- a/f\n+++ b/f\n@@ -1,2 +1,3 @@\n a\n+new\n b\n",
+		},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve",
+			"--- a/f\n+++ b/f\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff := unifiedDiff("f", test.original, test.changed)
+			if diff != test.expected {
+				t.Errorf("got\n%s\nexpected\n%s", strings.TrimSpace(diff), strings.TrimSpace(test.expected))
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+)
+
+var inviteRole string
+
+// The roles a user can have in an org
+var orgRoles = []string{"admin", "user", "org-reader"}
+
+// orgListing is the summary of an org shown by org list
+type orgListing struct {
+	Name   string `json:"name" yaml:"name"`
+	ID     string `json:"id" yaml:"id"`
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty"`
+	Active bool   `json:"active" yaml:"active"`
+}
+
+// userListing is the summary of a user shown by org users list
+type userListing struct {
+	Username string `json:"username" yaml:"username"`
+	ID       string `json:"id" yaml:"id"`
+	Role     string `json:"role" yaml:"role"`
+}
+
+// findOrg matches an org by ID or name
+func findOrg(orgs []shuffle.OrgMini, idOrName string) (shuffle.OrgMini, error) {
+	for _, org := range orgs {
+		if org.Id == idOrName {
+			return org, nil
+		}
+	}
+
+	matches := []shuffle.OrgMini{}
+	for _, org := range orgs {
+		if strings.EqualFold(org.Name, idOrName) {
+			matches = append(matches, org)
+		}
+	}
+
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	if len(matches) > 1 {
+		return shuffle.OrgMini{}, fmt.Errorf("%d orgs are called %s. Use the ID instead", len(matches), idOrName)
+	}
+
+	return shuffle.OrgMini{}, fmt.Errorf("you are not in an org with name or ID %s", idOrName)
+}
+
+// findUser matches a user in the active org by ID or username
+func findUser(idOrUsername string) (shuffle.User, error) {
+	users, err := GetOrgUsers()
+	if err != nil {
+		return shuffle.User{}, err
+	}
+
+	for _, user := range users {
+		if user.Id == idOrUsername || strings.EqualFold(user.Username, idOrUsername) {
+			return user, nil
+		}
+	}
+
+	return shuffle.User{}, fmt.Errorf("no user %s in the org", idOrUsername)
+}
+
+func validRole(role string) error {
+	for _, orgRole := range orgRoles {
+		if role == orgRole {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown role %s. Use one of %s", role, strings.Join(orgRoles, ", "))
+}
+
+// exitOnApiError logs a failed API call and exits with its exit code
+func exitOnApiError(message string, err error) {
+	if err != nil {
+		log.Printf("[ERROR] %s: %s", message, err)
+		os.Exit(uploadExitCode(err))
+	}
+}
+
+var listOrgs = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the orgs you are in. The active one is marked with *",
+	Run: func(cmd *cobra.Command, args []string) {
+		requireApikey()
+		orgs, err := GetOrgs()
+		exitOnApiError("Problem listing orgs", err)
+
+		listings := []orgListing{}
+		rows := [][]string{}
+		for _, org := range orgs {
+			listing := orgListing{Name: org.Name, ID: org.Id, Active: hasOrgId() && org.Id == orgId}
+			if org.CreatorOrg != org.Id {
+				listing.Parent = org.CreatorOrg
+			}
+
+			listings = append(listings, listing)
+			active := ""
+			if listing.Active {
+				active = "*"
+			}
+
+			rows = append(rows, []string{active, listing.Name, listing.ID, listing.Parent})
+		}
+
+		if err := printFormatted(outputFormat, listings, []string{"", "NAME", "ID", "PARENT"}, rows); err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var switchOrg = &cobra.Command{
+	Use:   "switch",
+	Short: "Makes an org the active one for every command: switch <id|name>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No org provided. Use the org ID or name from 'org list'.")
+			return
+		}
+
+		requireApikey()
+		orgs, err := GetOrgs()
+		exitOnApiError("Problem listing orgs", err)
+
+		org, err := findOrg(orgs, args[0])
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+
+		profile, err := saveProfileOrg(org.Id)
+		if err != nil {
+			log.Printf("[ERROR] Problem saving profile to %s: %s", configPath(), err)
+			os.Exit(1)
+		}
+
+		log.Printf("[INFO] Switched profile %s to org %s (%s)", profile, org.Name, org.Id)
+		if len(os.Getenv("SHUFFLE_ORGID")) > 0 && os.Getenv("SHUFFLE_ORGID") != org.Id {
+			log.Printf("[WARNING] SHUFFLE_ORGID is set to %s and overrides the profile", os.Getenv("SHUFFLE_ORGID"))
+		}
+	},
+}
+
+var createSubOrg = &cobra.Command{
+	Use:   "create-suborg",
+	Short: "Creates a sub-organization of the active org: create-suborg <name>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No name provided.")
+			return
+		}
+
+		requireApikey()
+		if !hasOrgId() {
+			log.Println("[ERROR] No active org. Use 'org switch <id>' or SHUFFLE_ORGID to pick the parent org.")
+			os.Exit(1)
+		}
+
+		name := strings.Join(args, " ")
+		if len(name) < 3 {
+			log.Println("[ERROR] The name needs at least 3 characters.")
+			os.Exit(1)
+		}
+
+		exitOnApiError("Problem creating sub-org", CreateSubOrg(orgId, name))
+		log.Printf("[INFO] Created sub-org %s under %s", name, orgId)
+	},
+}
+
+var orgUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Manage users in the active org",
+}
+
+var listOrgUsers = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the users in the active org",
+	Run: func(cmd *cobra.Command, args []string) {
+		requireApikey()
+		users, err := GetOrgUsers()
+		exitOnApiError("Problem listing users", err)
+
+		listings := []userListing{}
+		rows := [][]string{}
+		for _, user := range users {
+			listings = append(listings, userListing{Username: user.Username, ID: user.Id, Role: user.Role})
+			rows = append(rows, []string{user.Username, user.Id, user.Role})
+		}
+
+		if err := printFormatted(outputFormat, listings, []string{"USERNAME", "ID", "ROLE"}, rows); err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var inviteOrgUser = &cobra.Command{
+	Use:   "invite",
+	Short: "Invites a user to the active org: invite <email>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No email provided.")
+			return
+		}
+
+		if err := validRole(inviteRole); err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+
+		requireApikey()
+		exitOnApiError("Problem inviting user", InviteUser(args[0]))
+		if inviteRole != "user" {
+			user, err := findUser(args[0])
+			exitOnApiError("User invited, but there was a problem finding them to set the role", err)
+			exitOnApiError("User invited, but there was a problem setting the role", SetUserRole(user.Id, inviteRole))
+		}
+
+		log.Printf("[INFO] Invited %s as %s", args[0], inviteRole)
+	},
+}
+
+var removeOrgUser = &cobra.Command{
+	Use:   "remove",
+	Short: "Removes a user from the active org: remove <id|username>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No user provided.")
+			return
+		}
+
+		requireApikey()
+		user, err := findUser(args[0])
+		exitOnApiError("Problem finding user", err)
+
+		if !assumeYes && !isInteractive() {
+			log.Println("[ERROR] Use --yes to remove a user without a terminal.")
+			os.Exit(1)
+		}
+
+		if !confirm(fmt.Sprintf("Remove %s from the org?", user.Username), false) {
+			log.Println("[INFO] Not removing.")
+			return
+		}
+
+		exitOnApiError("Problem removing user", RemoveUser(user.Id))
+		log.Printf("[INFO] Removed %s", user.Username)
+	},
+}
+
+var setOrgUserRole = &cobra.Command{
+	Use:   "set-role",
+	Short: fmt.Sprintf("Changes a user's role: set-role <id|username> <%s>", strings.Join(orgRoles, "|")),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			log.Println("[ERROR] Use set-role <id|username> <role>.")
+			return
+		}
+
+		if err := validRole(args[1]); err != nil {
+			log.Printf("[ERROR] %s", err)
+			os.Exit(1)
+		}
+
+		requireApikey()
+		user, err := findUser(args[0])
+		exitOnApiError("Problem finding user", err)
+		exitOnApiError("Problem setting role", SetUserRole(user.Id, args[1]))
+		log.Printf("[INFO] %s is now %s", user.Username, args[1])
+	},
+}
+
+func init() {
+	orgCmd.AddCommand(listOrgs)
+	orgCmd.AddCommand(switchOrg)
+	orgCmd.AddCommand(createSubOrg)
+	orgCmd.AddCommand(orgUsersCmd)
+
+	orgUsersCmd.AddCommand(listOrgUsers)
+	orgUsersCmd.AddCommand(inviteOrgUser)
+	orgUsersCmd.AddCommand(removeOrgUser)
+	orgUsersCmd.AddCommand(setOrgUserRole)
+
+	for _, cmd := range []*cobra.Command{listOrgs, listOrgUsers} {
+		cmd.Flags().StringVar(&outputFormat, "format", "table", "Output format: table, json or yaml")
+	}
+
+	inviteOrgUser.Flags().StringVar(&inviteRole, "role", "user", fmt.Sprintf("Role for the new user: %s", strings.Join(orgRoles, ", ")))
+	removeOrgUser.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Don't ask for confirmation")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile holds settings for one Shuffle instance or org
+type Profile struct {
+	OrgId string `yaml:"org_id,omitempty"`
+}
+
+// cliConfig is the config file with every profile
+type cliConfig struct {
+	ActiveProfile string             `yaml:"active_profile,omitempty"`
+	Profiles      map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// configPath is where profiles are kept. SHUFFLE_CONFIG overrides it.
+func configPath() string {
+	if len(os.Getenv("SHUFFLE_CONFIG")) > 0 {
+		return os.Getenv("SHUFFLE_CONFIG")
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "."
+	}
+
+	return filepath.Join(configDir, "shufflecli", "config.yaml")
+}
+
+func loadConfig() (cliConfig, error) {
+	config := cliConfig{Profiles: map[string]Profile{}}
+	data, err := ioutil.ReadFile(configPath())
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+
+	if err != nil {
+		return config, err
+	}
+
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+
+	if config.Profiles == nil {
+		config.Profiles = map[string]Profile{}
+	}
+
+	return config, nil
+}
+
+func saveConfig(config cliConfig) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configPath()), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(configPath(), data, 0600)
+}
+
+// activeProfileName is SHUFFLE_PROFILE, the profile picked in the config
+// file, or "default"
+func activeProfileName(config cliConfig) string {
+	if len(os.Getenv("SHUFFLE_PROFILE")) > 0 {
+		return os.Getenv("SHUFFLE_PROFILE")
+	}
+
+	if len(config.ActiveProfile) > 0 {
+		return config.ActiveProfile
+	}
+
+	return "default"
+}
+
+// applyProfile loads the active profile's settings. Environment variables
+// are applied after this, so they still win.
+func applyProfile() error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	profile := config.Profiles[activeProfileName(config)]
+	if len(profile.OrgId) > 0 {
+		orgId = profile.OrgId
+	}
+
+	return nil
+}
+
+// saveProfileOrg sets the org for the active profile
+func saveProfileOrg(newOrgId string) (string, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+
+	name := activeProfileName(config)
+	profile := config.Profiles[name]
+	profile.OrgId = newOrgId
+	config.Profiles[name] = profile
+	return name, saveConfig(config)
+}
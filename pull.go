@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/frikky/kin-openapi/openapi3"
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var pullVersion string
+var pullOutputPath string
+var pullForce bool
+
+// The Dockerfile every app built on the SDK image uses
+const appDockerfile = `FROM ` + appSdkImage + ` as base
+
+FROM base as builder
+RUN apk --no-cache add --update alpine-sdk libffi libffi-dev musl-dev openssl-dev
+RUN mkdir /install
+WORKDIR /install
+COPY requirements.txt /requirements.txt
+RUN pip install --prefix="/install" -r /requirements.txt
+
+FROM base
+COPY --from=builder /install /usr/local
+COPY src /app
+WORKDIR /app
+CMD ["python", "app.py", "--log-level", "DEBUG"]
+`
+
+// pulledStubMarker is written next to a pulled app whose app.py only has
+// method stubs, so the stubs aren't uploaded over the real app by mistake
+const pulledStubMarker = ".shuffle-pulled-stub"
+
+const pulledStubNote = `src/app.py in this folder was generated by "shufflecli app pull" and only
+has a method stub for each action. Uploading it would replace the real app.
+
+Put the real source in src/app.py and delete this file to upload it.
+`
+
+// checkPulledStub refuses folders that still have the stub marker
+func checkPulledStub(folderPath string) error {
+	if _, err := os.Stat(filepath.Join(folderPath, pulledStubMarker)); err == nil {
+		return fmt.Errorf("%w: %s only has method stubs from app pull. Put the real source in src/app.py and delete %s to upload it", errValidationFailed, folderPath, pulledStubMarker)
+	}
+
+	return nil
+}
+
+var pythonIdentifierRegex = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// findApp picks an app by ID, or by name and version. Without a version the
+// newest one is used.
+func findApp(apps []shuffle.WorkflowApp, nameOrId, version string) (shuffle.WorkflowApp, error) {
+	var found *shuffle.WorkflowApp
+	for index, app := range apps {
+		if app.ID == nameOrId {
+			return app, nil
+		}
+
+		if normalizeLabel(app.Name) != normalizeLabel(nameOrId) {
+			continue
+		}
+
+		if len(version) > 0 && app.AppVersion != version {
+			continue
+		}
+
+		if found == nil || comparePythonVersions(app.AppVersion, found.AppVersion) > 0 {
+			found = &apps[index]
+		}
+	}
+
+	if found == nil {
+		if len(version) > 0 {
+			return shuffle.WorkflowApp{}, fmt.Errorf("no app %s with version %s", nameOrId, version)
+		}
+
+		return shuffle.WorkflowApp{}, fmt.Errorf("no app with name or ID %s", nameOrId)
+	}
+
+	return *found, nil
+}
+
+// pythonClassName turns an app name into the class name app.py uses
+func pythonClassName(name string) string {
+	className := ""
+	for _, word := range strings.FieldsFunc(name, func(char rune) bool { return char == ' ' || char == '-' || char == '_' }) {
+		word = pythonIdentifierRegex.ReplaceAllString(word, "")
+		if len(word) > 0 {
+			className += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+
+	if len(className) == 0 || (className[0] >= '0' && className[0] <= '9') {
+		className = "App" + className
+	}
+
+	return className
+}
+
+// generatedAppPython rebuilds app.py for an app from the app creator, the
+// same way the backend does from the OpenAPI spec
+func generatedAppPython(app shuffle.WorkflowApp, openapi shuffle.ParsedOpenApi) (string, error) {
+	swaggerLoader := openapi3.NewSwaggerLoader()
+	swaggerLoader.IsExternalRefsAllowed = true
+	swagger, err := swaggerLoader.LoadSwaggerFromData([]byte(openapi.Body))
+	if err != nil {
+		return "", fmt.Errorf("bad OpenAPI spec: %s", err)
+	}
+
+	hasher := md5.New()
+	hasher.Write([]byte(openapi.Body))
+	_, _, pythonFunctions, err := shuffle.GenerateYaml(swagger, hex.EncodeToString(hasher.Sum(nil)))
+	if err != nil {
+		return "", err
+	}
+
+	className := pythonClassName(app.Name)
+	source := fmt.Sprintf(shuffle.GetBasePython(), className, app.AppVersion, app.Name, strings.Join(pythonFunctions, "\n"), className)
+	source, _ = migratePython(source)
+	return source, nil
+}
+
+// skeletonAppPython is an app.py with one method per action, for apps where
+// the backend doesn't keep the source
+func skeletonAppPython(app shuffle.WorkflowApp) string {
+	className := pythonClassName(app.Name)
+	source := &strings.Builder{}
+	fmt.Fprintf(source, "from shuffle_sdk import AppBase\n\n")
+	fmt.Fprintf(source, "class %s(AppBase):\n", className)
+	fmt.Fprintf(source, "    __version__ = \"%s\"\n", app.AppVersion)
+	fmt.Fprintf(source, "    app_name = \"%s\"\n\n", app.Name)
+	fmt.Fprintf(source, "    def __init__(self, redis, logger, console_logger=None):\n")
+	fmt.Fprintf(source, "        super().__init__(redis, logger, console_logger)\n")
+
+	for _, action := range app.Actions {
+		params := []string{"self"}
+		for _, param := range action.Parameters {
+			name := pythonIdentifierRegex.ReplaceAllString(param.Name, "_")
+			if param.Required {
+				params = append(params, name)
+			} else {
+				params = append(params, name+"=\"\"")
+			}
+		}
+
+		fmt.Fprintf(source, "\n    def %s(%s):\n", action.Name, strings.Join(params, ", "))
+		if len(action.Description) > 0 {
+			fmt.Fprintf(source, "        \"\"\"%s\"\"\"\n", strings.ReplaceAll(action.Description, "\"\"\"", "'''"))
+		}
+
+		fmt.Fprintf(source, "        raise NotImplementedError(\"%s was pulled without its source\")\n", action.Name)
+	}
+
+	fmt.Fprintf(source, "\nif __name__ == \"__main__\":\n    %s.run()\n", className)
+	return source.String()
+}
+
+// pruneYaml removes empty values so api.yaml only has what is set
+func pruneYaml(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value == "" || node.Tag == "!!null" || (node.Tag == "!!int" && node.Value == "0") || (node.Tag == "!!bool" && node.Value == "false")
+	case yaml.SequenceNode:
+		kept := []*yaml.Node{}
+		for _, child := range node.Content {
+			if !pruneYaml(child) || child.Kind == yaml.ScalarNode {
+				kept = append(kept, child)
+			}
+		}
+
+		node.Content = kept
+		return len(kept) == 0
+	case yaml.MappingNode:
+		kept := []*yaml.Node{}
+		for index := 0; index+1 < len(node.Content); index += 2 {
+			if !pruneYaml(node.Content[index+1]) {
+				kept = append(kept, node.Content[index], node.Content[index+1])
+			}
+		}
+
+		node.Content = kept
+		return len(kept) == 0
+	}
+
+	return false
+}
+
+// appApiYaml writes the app definition without server side fields
+func appApiYaml(app shuffle.WorkflowApp) ([]byte, error) {
+	app.Owner = ""
+	app.PrivateID = ""
+	app.Created = 0
+	app.Edited = 0
+	app.LastRuntime = 0
+	app.Versions = nil
+	app.LoopVersions = nil
+	app.ChildIds = nil
+	app.Hash = ""
+	app.RevisionId = ""
+	app.Activated = false
+	app.Downloaded = false
+	app.Tested = false
+	app.SmallImage = ""
+
+	node := &yaml.Node{}
+	if err := node.Encode(app); err != nil {
+		return nil, err
+	}
+
+	pruneYaml(node)
+	return yaml.Marshal(node)
+}
+
+// checkFolderName rejects app names and versions from the backend that would
+// put files outside the output folder
+func checkFolderName(kind, name string) error {
+	if len(name) == 0 || name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%s '%s' can't be used as a folder name", kind, name)
+	}
+
+	return nil
+}
+
+// downloadApp writes an app from the backend to <output>/<app>/<version>/.
+// An existing folder is only overwritten with force.
+func downloadApp(nameOrId, version, outputPath string, force bool) (string, error) {
+	apps, err := GetApps()
+	if err != nil {
+		return "", err
+	}
+
+	found, err := findApp(apps, nameOrId, version)
+	if err != nil {
+		return "", err
+	}
+
+	app, openapi, err := GetAppConfig(found.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(app.Name) == 0 {
+		app = found
+	}
+
+	if err := checkFolderName("app name", normalizeLabel(app.Name)); err != nil {
+		return "", err
+	}
+
+	if err := checkFolderName("app version", app.AppVersion); err != nil {
+		return "", err
+	}
+
+	folderPath := filepath.Join(outputPath, normalizeLabel(app.Name), app.AppVersion)
+	if _, err := os.Stat(folderPath); err == nil && !force {
+		return "", fmt.Errorf("%s already exists. Use --force to overwrite it", folderPath)
+	}
+
+	pythonSource := ""
+	requirements := ""
+	if app.Generated && len(openapi.Body) > 0 {
+		pythonSource, err = generatedAppPython(app, openapi)
+		if err != nil {
+			return "", err
+		}
+
+		requirements = shuffle.GetAppRequirements()
+	} else {
+		log.Printf("[WARNING] The backend doesn't keep the source of Python apps. %s/src/app.py only has a method stub for each action.", app.Name)
+		if len(app.ReferenceInfo.GithubUrl) > 0 {
+			log.Printf("[WARNING] The source may be at %s", app.ReferenceInfo.GithubUrl)
+		}
+
+		pythonSource = skeletonAppPython(app)
+		requirements = "shuffle_sdk\n"
+	}
+
+	apiYaml, err := appApiYaml(app)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Join(folderPath, "src"), 0755); err != nil {
+		return "", err
+	}
+
+	files := map[string]string{
+		"api.yaml":         string(apiYaml),
+		"src/app.py":       pythonSource,
+		"requirements.txt": requirements,
+		"Dockerfile":       appDockerfile,
+	}
+
+	markerPath := filepath.Join(folderPath, pulledStubMarker)
+	if app.Generated && len(openapi.Body) > 0 {
+		os.Remove(markerPath)
+	} else {
+		files[pulledStubMarker] = pulledStubNote
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(folderPath, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			return "", err
+		}
+	}
+
+	return folderPath, validateAppFilepath(folderPath)
+}
+
+var pullApp = &cobra.Command{
+	Use:   "pull",
+	Short: "Downloads an app from Shuffle into <app>/<version>/: pull <name|id>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No app provided. Use the app name or ID.")
+			return
+		}
+
+		if len(apikey) <= 0 {
+			fmt.Println("Please set the SHUFFLE_APIKEY or SHUFFLE_AUTHORIZATION environment variables to help with upload/download.")
+			os.Exit(exitAuthFailed)
+		}
+
+		folderPath, err := downloadApp(args[0], pullVersion, pullOutputPath, pullForce)
+		if err != nil {
+			log.Printf("[ERROR] Problem pulling app %s: %s", args[0], err)
+			os.Exit(uploadExitCode(err))
+		}
+
+		log.Printf("[INFO] Pulled app to %s", folderPath)
+	},
+}
+
+func init() {
+	appCmd.AddCommand(pullApp)
+
+	pullApp.Flags().StringVar(&pullVersion, "version", "", "App version to pull. Defaults to the newest")
+	pullApp.Flags().StringVarP(&pullOutputPath, "output", "o", ".", "Folder to put the <app>/<version>/ folder in")
+	pullApp.Flags().BoolVar(&pullForce, "force", false, "Overwrite the <app>/<version>/ folder if it already exists")
+}
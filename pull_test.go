@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+func TestCheckFolderName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"my_app", true},
+		{"1.0.0", true},
+		{"app.v2", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../evil", false},
+		{"evil/..", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"1.0..0", false},
+	}
+
+	for _, test := range tests {
+		if err := checkFolderName("app name", test.name); (err == nil) != test.valid {
+			t.Errorf("checkFolderName(%q) = %v, expected valid=%v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestDownloadApp(t *testing.T) {
+	server, testServer := newTestDevServer(t, "")
+	appId := uploadTestDevApp(t, testServer)
+
+	previousUrl, previousApikey := uploadUrl, apikey
+	uploadUrl, apikey = testServer.URL, "test"
+	defer func() { uploadUrl, apikey = previousUrl, previousApikey }()
+
+	outputPath := t.TempDir()
+	folderPath, err := downloadApp(appId, "", outputPath, false)
+	if folderPath != filepath.Join(outputPath, "dev_test", "1.0.0") {
+		t.Fatalf("downloadApp = %s, %v, expected dev_test/1.0.0", folderPath, err)
+	}
+
+	for _, name := range []string{"api.yaml", "src/app.py", "requirements.txt", "Dockerfile", pulledStubMarker} {
+		if _, err := os.Stat(filepath.Join(folderPath, name)); err != nil {
+			t.Errorf("pulled app is missing %s", name)
+		}
+	}
+
+	// A second pull would overwrite local changes
+	appPath := filepath.Join(folderPath, "src", "app.py")
+	os.WriteFile(appPath, []byte("# local changes\n"), 0644)
+	if _, err := downloadApp(appId, "", outputPath, false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second downloadApp = %v, expected an already exists error", err)
+	}
+
+	if data, _ := os.ReadFile(appPath); string(data) != "# local changes\n" {
+		t.Errorf("second downloadApp overwrote app.py")
+	}
+
+	if _, err := downloadApp(appId, "", outputPath, true); err != nil && strings.Contains(err.Error(), "already exists") {
+		t.Errorf("downloadApp with force = %v, expected it to overwrite", err)
+	}
+
+	if data, _ := os.ReadFile(appPath); string(data) == "# local changes\n" {
+		t.Errorf("downloadApp with force didn't overwrite app.py")
+	}
+
+	// Names from the backend can't escape the output folder
+	for _, app := range []shuffle.WorkflowApp{
+		{ID: "evil-name", Name: "../../evil", AppVersion: "1.0.0"},
+		{ID: "evil-version", Name: "evil", AppVersion: "../1.0.0"},
+	} {
+		server.writeJSON(server.path("apps", app.ID+".json"), app)
+		if _, err := downloadApp(app.ID, "", outputPath, true); err == nil || !strings.Contains(err.Error(), "can't be used as a folder name") {
+			t.Errorf("downloadApp(%s) = %v, expected a folder name error", app.ID, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(outputPath), "evil")); err == nil {
+		t.Errorf("downloadApp wrote outside the output folder")
+	}
+}
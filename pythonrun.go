@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// appStartupScript runs app.py as __main__, but replaces AppBase.run so the
+// app is only instantiated. If that works it prints appReadyMarker and exits
+// instead of waiting for executions.
+const appStartupScript = `
+import importlib, logging, os, runpy, sys
+
+app_path = sys.argv[1]
+sys.argv = [app_path]
+sys.path.insert(0, os.path.dirname(os.path.abspath(app_path)))
+
+def ready(cls, *args, **kwargs):
+    logger = logging.getLogger("shuffle_app")
+    try:
+        cls(None, logger)
+    except TypeError:
+        cls()
+
+    print("SHUFFLE_APP_READY", flush=True)
+    sys.exit(0)
+
+# Older app images only have the walkoff_app_sdk name
+sdk_found = False
+for sdk_module in ("shuffle_sdk", "walkoff_app_sdk.app_base"):
+    try:
+        importlib.import_module(sdk_module).AppBase.run = classmethod(ready)
+        sdk_found = True
+    except ImportError:
+        pass
+
+if not sdk_found:
+    raise ModuleNotFoundError("No module named 'shuffle_sdk'. Install it with: pip install shuffle_sdk")
+
+runpy.run_path(app_path, run_name="__main__")
+`
+
+const appReadyMarker = "SHUFFLE_APP_READY"
+
+var validationTimeout = 30 * time.Second
+var keepTempFiles bool
+
+var tracebackFrameRegex = regexp.MustCompile(`^\s*File "(.+)", line (\d+)(?:, in (.+))?$`)
+var exceptionLineRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.]*)(?::\s?(.*))?$`)
+
+// PythonError is an exception parsed from a python traceback
+type PythonError struct {
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Traceback string `json:"traceback"`
+}
+
+func (pythonError *PythonError) Error() string {
+	if len(pythonError.File) == 0 {
+		return fmt.Sprintf("%s: %s", pythonError.Type, pythonError.Message)
+	}
+
+	return fmt.Sprintf("%s: %s (%s:%d)", pythonError.Type, pythonError.Message, pythonError.File, pythonError.Line)
+}
+
+// parsePythonTraceback finds the last traceback in the output. The location is
+// the deepest frame in appFile, or the last frame if the error is elsewhere.
+// Returns nil if there is no traceback.
+func parsePythonTraceback(output, appFile string) *PythonError {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	start := -1
+	for index, line := range lines {
+		if strings.HasPrefix(line, "Traceback (most recent call last):") {
+			start = index
+		}
+	}
+
+	if start < 0 {
+		return nil
+	}
+
+	pythonError := &PythonError{}
+	tracebackLines := []string{}
+	for _, line := range lines[start:] {
+		tracebackLines = append(tracebackLines, line)
+		if match := tracebackFrameRegex.FindStringSubmatch(line); match != nil {
+			lineNumber, _ := strconv.Atoi(match[2])
+			if match[1] == appFile || pythonError.File != appFile {
+				pythonError.File = match[1]
+				pythonError.Line = lineNumber
+			}
+
+			continue
+		}
+
+		// The exception is the first line that isn't indented after the frames
+		if len(line) > 0 && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "Traceback") {
+			if match := exceptionLineRegex.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+				pythonError.Type = match[1]
+				pythonError.Message = match[2]
+				break
+			}
+		}
+	}
+
+	if len(pythonError.Type) == 0 {
+		return nil
+	}
+
+	pythonError.Traceback = strings.TrimSpace(strings.Join(tracebackLines, "\n"))
+	return pythonError
+}
+
+// rewriteSdkImports points walkoff_app_sdk imports to shuffle_sdk. It only
+// changes text within lines, so line numbers stay the same as in the original.
+func rewriteSdkImports(source []byte) []byte {
+	lines := strings.Split(string(source), "\n")
+	for index, line := range lines {
+		lines[index] = strings.Replace(line, "from walkoff_app_sdk.app_base", "from shuffle_sdk", -1)
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// remapTraceback makes traceback frames in the copy point to the original file.
+// As rewriteSdkImports keeps lines as they are, line numbers need no change.
+func remapTraceback(output, copyFile, originalFile string) string {
+	return strings.ReplaceAll(output, fmt.Sprintf("File \"%s\"", copyFile), fmt.Sprintf("File \"%s\"", originalFile))
+}
+
+// logPythonOutput prints what the app wrote, without the SDK's own log lines
+func logPythonOutput(stdout, stderr string) {
+	if len(strings.TrimSpace(stdout)) > 0 {
+		log.Printf("\n\n===== Python run (stdout) ===== \n")
+		for _, line := range strings.Split(stdout, "\n") {
+			if strings.Contains(line, appReadyMarker) || strings.Contains(strings.ToLower(line), "already satisfied") {
+				continue
+			}
+
+			fmt.Println(line)
+		}
+	}
+
+	if len(strings.TrimSpace(stderr)) > 0 {
+		log.Printf("\n\n===== Python run (stderr) ===== \n")
+		for _, line := range strings.Split(stderr, "\n") {
+			if strings.Contains(line, "[DEBUG]") || strings.Contains(line, "[INFO]") {
+				continue
+			}
+
+			fmt.Println(line)
+		}
+	}
+}
+
+// runAppStartup starts the app the way the SDK would and waits for it to be
+// ready. appFile is the copy python runs, and errors are reported against
+// originalFile. The original src folder is used for local imports and files.
+func runAppStartup(appFile, originalFile string, timeout time.Duration) error {
+	log.Printf("[DEBUG] Validating python file by starting %s for up to %s.", appFile, timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	srcFolder := filepath.Dir(originalFile)
+	pythonPath := srcFolder
+	if len(os.Getenv("PYTHONPATH")) > 0 {
+		pythonPath = fmt.Sprintf("%s%c%s", srcFolder, os.PathListSeparator, os.Getenv("PYTHONPATH"))
+	}
+
+	var stdoutBuffer, stderrBuffer bytes.Buffer
+	cmd := exec.CommandContext(ctx, "python3", "-c", appStartupScript, appFile)
+	cmd.Dir = srcFolder
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PYTHONPATH=%s", pythonPath))
+	cmd.Stdout = &stdoutBuffer
+	cmd.Stderr = &stderrBuffer
+	err := cmd.Run()
+
+	stdout, stderr := stdoutBuffer.String(), remapTraceback(stderrBuffer.String(), appFile, originalFile)
+	if pythonError := parsePythonTraceback(stderr, originalFile); pythonError != nil {
+		logPythonOutput(stdout, stderr)
+		log.Printf("[ERROR] Python run error: %s", pythonError)
+		return pythonError
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		logPythonOutput(stdout, stderr)
+		return fmt.Errorf("app didn't get ready within %s. Does app.py block before calling run()? Raise the limit with --timeout", timeout)
+	}
+
+	if err != nil {
+		logPythonOutput(stdout, stderr)
+		return fmt.Errorf("Local run of python file failed: %w", err)
+	}
+
+	if !strings.Contains(stdout, appReadyMarker) {
+		logPythonOutput(stdout, stderr)
+		return fmt.Errorf("app.py exited without starting the app. It should end with: if __name__ == \"__main__\": <YourApp>.run()")
+	}
+
+	return nil
+}
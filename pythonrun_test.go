@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePythonTraceback(t *testing.T) {
+	const appFile = "/apps/hello/src/app.py"
+	tests := []struct {
+		name    string
+		output  string
+		errType string
+		message string
+		file    string
+		line    int
+	}{
+		{
+			name: "error in the app",
+			output: `[INFO] Starting app
+Traceback (most recent call last):
+  File "<string>", line 48, in <module>
+  File "/apps/hello/src/app.py", line 12, in hello
+    return 1 / 0
+ZeroDivisionError: division by zero`,
+			errType: "ZeroDivisionError",
+			message: "division by zero",
+			file:    appFile,
+			line:    12,
+		},
+		{
+			name: "chained traceback uses the last one",
+			output: `Traceback (most recent call last):
+  File "/apps/hello/src/app.py", line 20, in hello
+    value = data["missing"]
+KeyError: 'missing'
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "/apps/hello/src/app.py", line 22, in hello
+    raise ValueError("bad data")
+ValueError: bad data`,
+			errType: "ValueError",
+			message: "bad data",
+			file:    appFile,
+			line:    22,
+		},
+		{
+			name: "syntax error frame without a function",
+			output: `Traceback (most recent call last):
+  File "<string>", line 48, in <module>
+  File "/usr/lib/python3.10/runpy.py", line 288, in run_path
+    code, fname = _get_code_from_file(run_name, path_name)
+  File "/apps/hello/src/app.py", line 7
+    def hello(self:
+                  ^
+SyntaxError: '(' was never closed`,
+			errType: "SyntaxError",
+			message: "'(' was never closed",
+			file:    appFile,
+			line:    7,
+		},
+		{
+			name: "library error keeps the deepest app frame",
+			output: `Traceback (most recent call last):
+  File "/apps/hello/src/app.py", line 30, in get_url
+    return requests.get(url).text
+  File "/usr/lib/python3/site-packages/requests/api.py", line 73, in get
+    return request("get", url, params=params, **kwargs)
+requests.exceptions.MissingSchema: Invalid URL 'example': No scheme supplied.`,
+			errType: "requests.exceptions.MissingSchema",
+			message: "Invalid URL 'example': No scheme supplied.",
+			file:    appFile,
+			line:    30,
+		},
+		{
+			name: "library error without app frames falls back to the last frame",
+			output: `Traceback (most recent call last):
+  File "<string>", line 40, in <module>
+  File "/usr/lib/python3/site-packages/shuffle_sdk/__init__.py", line 12, in <module>
+    import missing_module
+ModuleNotFoundError: No module named 'missing_module'`,
+			errType: "ModuleNotFoundError",
+			message: "No module named 'missing_module'",
+			file:    "/usr/lib/python3/site-packages/shuffle_sdk/__init__.py",
+			line:    12,
+		},
+		{
+			name:    "exception without a message and CRLF line endings",
+			output:  "Traceback (most recent call last):\r\n  File \"/apps/hello/src/app.py\", line 3, in <module>\r\n    wait()\r\nKeyboardInterrupt\r\n",
+			errType: "KeyboardInterrupt",
+			file:    appFile,
+			line:    3,
+		},
+	}
+
+	for _, test := range tests {
+		pythonError := parsePythonTraceback(test.output, appFile)
+		if pythonError == nil {
+			t.Errorf("%s: parsePythonTraceback returned nil", test.name)
+			continue
+		}
+
+		if pythonError.Type != test.errType || pythonError.Message != test.message || pythonError.File != test.file || pythonError.Line != test.line {
+			t.Errorf("%s: parsePythonTraceback = %s %q %s:%d, expected %s %q %s:%d", test.name, pythonError.Type, pythonError.Message, pythonError.File, pythonError.Line, test.errType, test.message, test.file, test.line)
+		}
+
+		if !strings.HasPrefix(pythonError.Traceback, "Traceback") || strings.Contains(pythonError.Traceback, "During handling") {
+			t.Errorf("%s: traceback should only be the last one, got:\n%s", test.name, pythonError.Traceback)
+		}
+	}
+}
+
+func TestParsePythonTracebackWithoutTraceback(t *testing.T) {
+	for _, output := range []string{"", "[INFO] App ready\nSHUFFLE_APP_READY", "Traceback (most recent call last):\n  File \"app.py\", line 1"} {
+		if pythonError := parsePythonTraceback(output, "app.py"); pythonError != nil {
+			t.Errorf("parsePythonTraceback(%q) = %s, expected nil", output, pythonError)
+		}
+	}
+}
+
+func TestRemapTraceback(t *testing.T) {
+	const tempFile = "/tmp/shufflecli-validate-123/app.py"
+	const originalFile = "/apps/hello/src/app.py"
+
+	output := `Traceback (most recent call last):
+  File "/usr/lib/python3.10/runpy.py", line 288, in run_path
+  File "/tmp/shufflecli-validate-123/app.py", line 15, in hello
+    print(open("/tmp/shufflecli-validate-123/app.py"))
+NameError: name 'x' is not defined`
+
+	remapped := remapTraceback(output, tempFile, originalFile)
+	if !strings.Contains(remapped, `File "/apps/hello/src/app.py", line 15, in hello`) {
+		t.Errorf("remapTraceback didn't point the frame at the original file:\n%s", remapped)
+	}
+
+	if !strings.Contains(remapped, `File "/usr/lib/python3.10/runpy.py"`) || !strings.Contains(remapped, `open("/tmp/shufflecli-validate-123/app.py")`) {
+		t.Errorf("remapTraceback should only change frames of the copy:\n%s", remapped)
+	}
+
+	pythonError := parsePythonTraceback(remapped, originalFile)
+	if pythonError == nil || pythonError.File != originalFile || pythonError.Line != 15 {
+		t.Errorf("parsePythonTraceback(remapped) = %v, expected %s:15", pythonError, originalFile)
+	}
+}
+
+func TestRewriteSdkImports(t *testing.T) {
+	source := "import os\nfrom walkoff_app_sdk.app_base import AppBase\n\nclass App(AppBase):\n    pass\n"
+	result := string(rewriteSdkImports([]byte(source)))
+
+	if !strings.Contains(result, "from shuffle_sdk import AppBase") || strings.Contains(result, "walkoff_app_sdk") {
+		t.Errorf("rewriteSdkImports = %q", result)
+	}
+
+	if strings.Count(result, "\n") != strings.Count(source, "\n") {
+		t.Errorf("rewriteSdkImports changed the number of lines")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Finding is a single issue found while validating an app or workflow
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+func (finding Finding) String() string {
+	location := finding.File
+	if finding.Line > 0 {
+		location = fmt.Sprintf("%s:%d", finding.File, finding.Line)
+	}
+
+	if len(location) == 0 {
+		return fmt.Sprintf("%s: %s", finding.Rule, finding.Message)
+	}
+
+	return fmt.Sprintf("%s %s: %s", finding.Rule, location, finding.Message)
+}
+
+// logFindings prints findings in the same format as the rest of the validation
+func logFindings(findings []Finding) {
+	for _, finding := range findings {
+		if finding.Severity == severityError {
+			log.Printf("[ERROR] %s", finding)
+		} else {
+			log.Printf("[WARNING] %s", finding)
+		}
+	}
+}
+
+// hasErrorFindings is true if any finding should fail validation
+func hasErrorFindings(findings []Finding) bool {
+	for _, finding := range findings {
+		if finding.Severity == severityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CaseResult is the outcome of one test case, from tests.yaml or a fixture
+type CaseResult struct {
+	Name     string   `json:"name"`
+	Source   string   `json:"source"`
+	Action   string   `json:"action"`
+	Passed   bool     `json:"passed"`
+	Duration float64  `json:"duration"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// TestReport is the machine-readable result of 'app test --report'
+type TestReport struct {
+	App    string       `json:"app"`
+	Passed bool         `json:"passed"`
+	Errors []string     `json:"errors"`
+	Cases  []CaseResult `json:"cases"`
+}
+
+// LintReport is the machine-readable result of 'workflow lint --report'
+type LintReport struct {
+	Workflow string    `json:"workflow"`
+	Passed   bool      `json:"passed"`
+	Findings []Finding `json:"findings"`
+}
+
+// writeReport writes a TestReport or LintReport as JSON
+func writeReport(path string, report interface{}) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("[ERROR] Problem marshalling report: %s", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Printf("[ERROR] Problem writing report %s: %s", path, err)
+		return
+	}
+
+	log.Printf("[INFO] Wrote report to %s", path)
+}
+
+// printFormatted writes value as JSON or YAML, or rows as an aligned table
+func printFormatted(format string, value interface{}, header []string, rows [][]string) error {
+	switch strings.ToLower(format) {
+	case "json":
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+	case "yaml":
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(string(data))
+	case "table", "":
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+
+		return writer.Flush()
+	default:
+		return fmt.Errorf("unknown output format %s. Use table, json or yaml", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Requirement is a single parsed line from requirements.txt
+type Requirement struct {
+	Name      string
+	Extras    string
+	Specifier string
+	Line      int
+	Raw       string
+}
+
+// shuffleSdkRequirements are the pins from the shuffle_sdk package itself.
+// Apps pinning these to other versions break the SDK at runtime.
+var shuffleSdkRequirements = map[string]string{
+	"urllib3":         "2.3.0",
+	"requests":        "2.32.3",
+	"markupsafe":      "3.0.2",
+	"liquidpy":        "0.8.2",
+	"flask":           "3.1.0",
+	"waitress":        "3.0.2",
+	"python-dateutil": "2.9.0.post0",
+	"pyjwt":           "2.10.1",
+}
+
+// importPackageNames maps import names to the package providing them when
+// they differ. Keys are lowercase.
+var importPackageNames = map[string]string{
+	"yaml":          "pyyaml",
+	"bs4":           "beautifulsoup4",
+	"dateutil":      "python-dateutil",
+	"jwt":           "pyjwt",
+	"pil":           "pillow",
+	"cv2":           "opencv-python",
+	"sklearn":       "scikit-learn",
+	"crypto":        "pycryptodome",
+	"openssl":       "pyopenssl",
+	"magic":         "python-magic",
+	"dns":           "dnspython",
+	"docx":          "python-docx",
+	"git":           "gitpython",
+	"attr":          "attrs",
+	"jose":          "python-jose",
+	"nacl":          "pynacl",
+	"zmq":           "pyzmq",
+	"serial":        "pyserial",
+	"usb":           "pyusb",
+	"kafka":         "kafka-python",
+	"dotenv":        "python-dotenv",
+	"slugify":       "python-slugify",
+	"socks":         "pysocks",
+	"liquid":        "liquidpy",
+	"ruamel":        "ruamel-yaml",
+	"levenshtein":   "python-levenshtein",
+	"win32api":      "pywin32",
+	"ntlm":          "python-ntlm",
+	"whois":         "python-whois",
+	"pkg_resources": "setuptools",
+}
+
+// Modules that come with the shuffle_sdk and need no requirement
+var sdkProvidedImports = []string{"shuffle_sdk", "walkoff_app_sdk", "shufflepy"}
+
+var pythonStdlibModules = strings.Fields(`abc aifc argparse array ast asynchat asyncio asyncore atexit
+audioop base64 bdb binascii bisect builtins bz2 cProfile calendar cgi cgitb chunk cmath cmd code codecs
+codeop collections colorsys compileall concurrent configparser contextlib contextvars copy copyreg crypt
+csv ctypes curses dataclasses datetime dbm decimal difflib dis distutils doctest email encodings ensurepip
+enum errno faulthandler fcntl filecmp fileinput fnmatch fractions ftplib functools gc genericpath getopt
+getpass gettext glob graphlib grp gzip hashlib heapq hmac html http imaplib imghdr imp importlib inspect
+io ipaddress itertools json keyword lib2to3 linecache locale logging lzma mailbox mailcap marshal math
+mimetypes mmap modulefinder multiprocessing netrc nis nntplib ntpath numbers opcode operator optparse os
+ossaudiodev pathlib pdb pickle pickletools pipes pkgutil platform plistlib poplib posix posixpath pprint
+profile pstats pty pwd py_compile pyclbr pydoc queue quopri random re readline reprlib resource
+rlcompleter runpy sched secrets select selectors shelve shlex shutil signal site smtpd smtplib sndhdr
+socket socketserver spwd sqlite3 ssl stat statistics string stringprep struct subprocess sunau symtable
+sys sysconfig syslog tabnanny tarfile telnetlib tempfile termios textwrap threading time timeit tkinter
+token tokenize tomllib trace traceback tracemalloc tty turtle types typing unicodedata unittest urllib uu
+uuid venv warnings wave weakref webbrowser winreg wsgiref xdrlib xml xmlrpc zipapp zipfile zipimport zlib
+zoneinfo __future__`)
+
+var requirementRegex = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)\s*(\[[^\]]*\])?\s*(.*)$`)
+var importRegex = regexp.MustCompile(`^\s*import\s+([\w.]+(?:\s+as\s+\w+)?(?:\s*,\s*[\w.]+(?:\s+as\s+\w+)?)*)`)
+var fromImportRegex = regexp.MustCompile(`^\s*from\s+([\w.]+)\s+import\s`)
+var packageSeparatorRegex = regexp.MustCompile(`[-_.]+`)
+
+// normalizePackageName follows PEP 503 so "Python_Dateutil" == "python-dateutil"
+func normalizePackageName(name string) string {
+	return packageSeparatorRegex.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+// parseRequirementsFile reads requirements.txt into a list of requirements.
+// Options like --index-url and includes with -r are skipped.
+func parseRequirementsFile(requirementsPath string) ([]Requirement, error) {
+	data, err := ioutil.ReadFile(requirementsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRequirements(string(data), requirementsPath), nil
+}
+
+// parseRequirements parses the content of a requirements file. Lines ending
+// with a backslash continue on the next line, like the --hash lines in
+// requirements.lock.
+func parseRequirements(data, requirementsPath string) []Requirement {
+	requirements := []Requirement{}
+	lines := strings.Split(data, "\n")
+	for index := 0; index < len(lines); index++ {
+		lineNumber := index + 1
+		raw := strings.TrimSpace(lines[index])
+		for strings.HasSuffix(raw, "\\") && index+1 < len(lines) {
+			index++
+			raw = strings.TrimSpace(strings.TrimSuffix(raw, "\\")) + " " + strings.TrimSpace(lines[index])
+		}
+
+		raw = strings.TrimSpace(strings.TrimSuffix(raw, "\\"))
+		if commentIndex := strings.Index(raw, " #"); commentIndex >= 0 {
+			raw = strings.TrimSpace(raw[:commentIndex])
+		}
+
+		if len(raw) == 0 || strings.HasPrefix(raw, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(raw, "-") {
+			log.Printf("[DEBUG] Skipping requirements option on line %d: %s", lineNumber, raw)
+			continue
+		}
+
+		// Per requirement options like --hash don't matter for the checks
+		if optionIndex := strings.Index(raw, " --"); optionIndex >= 0 {
+			raw = strings.TrimSpace(raw[:optionIndex])
+		}
+
+		// Environment markers don't matter either
+		specifier := raw
+		if markerIndex := strings.Index(specifier, ";"); markerIndex >= 0 {
+			specifier = strings.TrimSpace(specifier[:markerIndex])
+		}
+
+		match := requirementRegex.FindStringSubmatch(specifier)
+		if match == nil {
+			log.Printf("[WARNING] Can't parse line %d in %s: %s", lineNumber, requirementsPath, raw)
+			continue
+		}
+
+		requirements = append(requirements, Requirement{
+			Name:      normalizePackageName(match[1]),
+			Extras:    match[2],
+			Specifier: strings.ReplaceAll(match[3], " ", ""),
+			Line:      lineNumber,
+			Raw:       raw,
+		})
+	}
+
+	return requirements
+}
+
+// isPinned is true for exact pins and direct URL references
+func (requirement Requirement) isPinned() bool {
+	if strings.HasPrefix(requirement.Specifier, "@") || strings.HasPrefix(requirement.Specifier, "===") {
+		return true
+	}
+
+	return strings.HasPrefix(requirement.Specifier, "==") && !strings.Contains(requirement.Specifier, "*") && !strings.Contains(requirement.Specifier, ",")
+}
+
+// pinnedVersion returns the version of an exact pin, or an empty string
+func (requirement Requirement) pinnedVersion() string {
+	if !requirement.isPinned() || strings.HasPrefix(requirement.Specifier, "@") {
+		return ""
+	}
+
+	return strings.TrimLeft(requirement.Specifier, "=")
+}
+
+// findPythonImports returns the top-level module names imported in a python file
+func findPythonImports(pythonFilePath string) ([]string, error) {
+	data, err := ioutil.ReadFile(pythonFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	imports := []string{}
+	addImport := func(module string) {
+		module = strings.Split(strings.TrimSpace(module), ".")[0]
+		if len(module) == 0 || found[module] {
+			return
+		}
+
+		found[module] = true
+		imports = append(imports, module)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if match := fromImportRegex.FindStringSubmatch(line); match != nil {
+			addImport(match[1])
+			continue
+		}
+
+		if match := importRegex.FindStringSubmatch(line); match != nil {
+			for _, module := range strings.Split(match[1], ",") {
+				addImport(strings.Fields(module)[0])
+			}
+		}
+	}
+
+	return imports, nil
+}
+
+// checkRequirements parses requirements.txt in an app folder and reports
+// unpinned, duplicate and SDK conflicting packages, and imports in src/app.py
+// that no requirement covers. Returns the error categories found.
+func checkRequirements(folderPath string) ([]string, error) {
+	requirementsPath := fmt.Sprintf("%s/requirements.txt", folderPath)
+	requirements, err := parseRequirementsFile(requirementsPath)
+	if err != nil {
+		return []string{}, err
+	}
+
+	errors := []string{}
+	seen := map[string]Requirement{}
+	for _, requirement := range requirements {
+		if previous, ok := seen[requirement.Name]; ok {
+			log.Printf("[ERROR] Duplicate requirement '%s' in %s on line %d and %d", requirement.Name, requirementsPath, previous.Line, requirement.Line)
+			errors = append(errors, "requirements duplicate")
+			continue
+		}
+
+		seen[requirement.Name] = requirement
+
+		if !requirement.isPinned() {
+			log.Printf("[WARNING] Unpinned requirement '%s' in %s on line %d. Pin it with '==<version>' to get reproducible builds", requirement.Raw, requirementsPath, requirement.Line)
+		}
+
+		sdkVersion, ok := shuffleSdkRequirements[requirement.Name]
+		if ok && len(requirement.pinnedVersion()) > 0 && requirement.pinnedVersion() != sdkVersion {
+			log.Printf("[ERROR] Requirement '%s' on line %d conflicts with shuffle_sdk, which needs %s==%s", requirement.Raw, requirement.Line, requirement.Name, sdkVersion)
+			errors = append(errors, "requirements conflict")
+		}
+	}
+
+	pythonFilePath := fmt.Sprintf("%s/src/app.py", folderPath)
+	imports, err := findPythonImports(pythonFilePath)
+	if err != nil {
+		return errors, err
+	}
+
+	for _, module := range imports {
+		if isProvidedImport(module, folderPath, seen) {
+			continue
+		}
+
+		log.Printf("[WARNING] '%s' is imported in %s but not in %s", module, pythonFilePath, requirementsPath)
+	}
+
+	return uniqueStrings(errors), nil
+}
+
+// isProvidedImport checks if a module comes from the stdlib, the SDK, the
+// app's own source folder or one of its requirements
+func isProvidedImport(module, folderPath string, requirements map[string]Requirement) bool {
+	for _, stdlibModule := range pythonStdlibModules {
+		if module == stdlibModule {
+			return true
+		}
+	}
+
+	for _, sdkModule := range sdkProvidedImports {
+		if module == sdkModule {
+			return true
+		}
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%s/src/%s.py", folderPath, module)); err == nil {
+		return true
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%s/src/%s", folderPath, module)); err == nil {
+		return true
+	}
+
+	packageName := normalizePackageName(module)
+	if mappedName, ok := importPackageNames[strings.ToLower(module)]; ok {
+		packageName = mappedName
+	}
+
+	if _, ok := requirements[packageName]; ok {
+		return true
+	}
+
+	if _, ok := shuffleSdkRequirements[packageName]; ok {
+		return true
+	}
+
+	// Namespace packages like google-cloud-storage -> google
+	for name := range requirements {
+		if strings.HasPrefix(name, packageName+"-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func uniqueStrings(items []string) []string {
+	found := map[string]bool{}
+	unique := []string{}
+	for _, item := range items {
+		if found[item] {
+			continue
+		}
+
+		found[item] = true
+		unique = append(unique, item)
+	}
+
+	return unique
+}
+
+// lockedPackage is one pinned package in requirements.lock
+type lockedPackage struct {
+	name    string
+	version string
+	hashes  []string
+}
+
+// formatRequirementsLock writes the packages sorted by name, each with its
+// hashes on continuation lines
+func formatRequirementsLock(packages map[string]*lockedPackage) string {
+	names := []string{}
+	for name := range packages {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	lockData := "# Generated by shufflecli from requirements.txt. Install with: pip install --require-hashes -r requirements.lock\n"
+	for _, name := range names {
+		lockedPackage := packages[name]
+		lockData += fmt.Sprintf("%s==%s", lockedPackage.name, lockedPackage.version)
+		for _, hash := range lockedPackage.hashes {
+			lockData += fmt.Sprintf(" \\\n    --hash=sha256:%s", hash)
+		}
+
+		lockData += "\n"
+	}
+
+	return lockData
+}
+
+// lockRequirements resolves requirements.txt with pip and writes a fully pinned
+// requirements.lock with sha256 hashes for every downloaded distribution.
+// A wheelhouse folder or index URL can be given to work without internet.
+// The hashes are for the distributions matching this machine's platform.
+func lockRequirements(folderPath, indexUrl, wheelhouse string) error {
+	requirementsPath := fmt.Sprintf("%s/requirements.txt", folderPath)
+	lockPath := fmt.Sprintf("%s/requirements.lock", folderPath)
+
+	downloadDir, err := os.MkdirTemp("", "shufflecli-lock-")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(downloadDir)
+
+	pipArgs := []string{"-m", "pip", "download", "-r", requirementsPath, "-d", downloadDir, "--disable-pip-version-check"}
+	if len(wheelhouse) > 0 {
+		pipArgs = append(pipArgs, "--no-index", "--find-links", wheelhouse)
+	} else if len(indexUrl) > 0 {
+		pipArgs = append(pipArgs, "--index-url", indexUrl)
+	}
+
+	log.Printf("[DEBUG] Resolving requirements for lock file with: python3 %s", strings.Join(pipArgs, " "))
+	output, err := exec.Command("python3", pipArgs...).CombinedOutput()
+	if err != nil {
+		log.Printf("[ERROR] Problem resolving requirements: %s\n\n%s", err, string(output))
+		return err
+	}
+
+	files, err := ioutil.ReadDir(downloadDir)
+	if err != nil {
+		return err
+	}
+
+	packages := map[string]*lockedPackage{}
+	for _, file := range files {
+		name, version := parseDistributionFilename(file.Name())
+		if len(name) == 0 {
+			log.Printf("[WARNING] Can't find package version from downloaded file %s", file.Name())
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(downloadDir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(data)
+		key := normalizePackageName(name)
+		if _, ok := packages[key]; !ok {
+			packages[key] = &lockedPackage{name: key, version: version}
+		}
+
+		packages[key].hashes = append(packages[key].hashes, hex.EncodeToString(hash[:]))
+	}
+
+	lockData := formatRequirementsLock(packages)
+	if err := ioutil.WriteFile(lockPath, []byte(lockData), 0644); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Wrote %d pinned packages to %s", len(packages), lockPath)
+	return nil
+}
+
+// parseDistributionFilename gets the name and version from a wheel or sdist filename
+func parseDistributionFilename(filename string) (string, string) {
+	if strings.HasSuffix(filename, ".whl") {
+		parts := strings.Split(filename, "-")
+		if len(parts) < 3 {
+			return "", ""
+		}
+
+		return parts[0], parts[1]
+	}
+
+	for _, extension := range []string{".tar.gz", ".tar.bz2", ".zip", ".tgz"} {
+		if !strings.HasSuffix(filename, extension) {
+			continue
+		}
+
+		base := strings.TrimSuffix(filename, extension)
+		splitIndex := strings.LastIndex(base, "-")
+		if splitIndex <= 0 {
+			return "", ""
+		}
+
+		return base[:splitIndex], base[splitIndex+1:]
+	}
+
+	return "", ""
+}
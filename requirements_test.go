@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRequirementsReadsLockFile(t *testing.T) {
+	lockData := formatRequirementsLock(map[string]*lockedPackage{
+		"requests": {name: "requests", version: "2.31.0", hashes: []string{"aaa", "bbb"}},
+		"urllib3":  {name: "urllib3", version: "2.0.7", hashes: []string{"ccc"}},
+		"idna":     {name: "idna", version: "3.4"},
+	})
+
+	folder := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(folder, "requirements.lock"), []byte(lockData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dependencies, err := resolveAppDependencies(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"requests": "2.31.0", "urllib3": "2.0.7", "idna": "3.4"}
+	if len(dependencies) != len(expected) {
+		t.Fatalf("got %d dependencies, expected %d: %#v", len(dependencies), len(expected), dependencies)
+	}
+
+	for name, version := range expected {
+		if dependencies[name] != version {
+			t.Errorf("%s: got version %q, expected %q", name, dependencies[name], version)
+		}
+
+		if comparePythonVersions(dependencies[name], version) != 0 {
+			t.Errorf("%s: %q doesn't compare equal to %q", name, dependencies[name], version)
+		}
+	}
+}
+
+func TestParseRequirements(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		expected  map[string]string
+		firstLine int
+	}{
+		{"plain pins", "requests==2.31.0\nurllib3==2.0.7\n", map[string]string{"requests": "==2.31.0", "urllib3": "==2.0.7"}, 1},
+		{"continuation with hashes", "# header\nrequests==2.31.0 \\\n    --hash=sha256:aaa \\\n    --hash=sha256:bbb\nidna==3.4\n", map[string]string{"requests": "==2.31.0", "idna": "==3.4"}, 2},
+		{"comments and markers", "requests>=2.0 # http\npywin32==306; sys_platform == 'win32'\n", map[string]string{"requests": ">=2.0", "pywin32": "==306"}, 1},
+		{"options are skipped", "--index-url https://example.com\n-r other.txt\nrequests==2.31.0\n", map[string]string{"requests": "==2.31.0"}, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requirements := parseRequirements(test.data, "requirements.txt")
+			if len(requirements) != len(test.expected) {
+				t.Fatalf("got %d requirements, expected %d: %#v", len(requirements), len(test.expected), requirements)
+			}
+
+			for _, requirement := range requirements {
+				if requirement.Specifier != test.expected[requirement.Name] {
+					t.Errorf("%s: got specifier %q, expected %q", requirement.Name, requirement.Specifier, test.expected[requirement.Name])
+				}
+			}
+
+			if requirements[0].Line != test.firstLine {
+				t.Errorf("got line %d for the first requirement, expected %d", requirements[0].Line, test.firstLine)
+			}
+		})
+	}
+}
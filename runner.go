@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+)
+
+// actionRunnerScript loads app.py without starting the app server, finds the
+// AppBase subclass and calls a single action. The result is printed on a line
+// starting with actionResultMarker so app output can't be mistaken for it.
+const actionRunnerScript = `
+import asyncio, inspect, json, logging, os, sys, time, traceback, types
+
+app_path, action_name, params = sys.argv[1], sys.argv[2], json.loads(sys.argv[3])
+marker = "SHUFFLE_ACTION_RESULT:"
+
+def finish(output):
+    sys.stdout.flush()
+    print(marker + json.dumps(output), flush=True)
+    sys.exit(0)
+
+start = time.time()
+try:
+    sys.path.insert(0, os.path.dirname(os.path.abspath(app_path)))
+    source = open(app_path).read().replace("from walkoff_app_sdk.app_base", "from shuffle_sdk")
+    module = types.ModuleType("shuffle_app")
+    module.__file__ = app_path
+    exec(compile(source, app_path, "exec"), module.__dict__)
+
+    app_class = None
+    for value in module.__dict__.values():
+        if inspect.isclass(value) and value.__module__ == "shuffle_app" and any(base.__name__ == "AppBase" for base in value.__mro__[1:]):
+            app_class = value
+
+    if app_class is None:
+        finish({"success": False, "error": "No AppBase subclass found in %s" % app_path})
+
+    logger = logging.getLogger("shuffle_app")
+    try:
+        app = app_class(None, logger)
+    except TypeError:
+        app = app_class()
+
+    # Point the SDK at SHUFFLE_URL, e.g. the local 'shufflecli dev server'
+    org_id = os.getenv("SHUFFLE_ORGID", "orgId")
+    app.url = os.getenv("CALLBACK_URL", getattr(app, "url", ""))
+    app.base_url = os.getenv("BASE_URL", getattr(app, "base_url", ""))
+    app.authorization = os.getenv("AUTHORIZATION", "")
+    app.current_execution_id = os.getenv("EXECUTIONID", "")
+    app.full_execution = {"execution_id": app.current_execution_id, "authorization": app.authorization, "workflow": {"id": "", "execution_org": {"id": org_id}}}
+
+    func = getattr(app, action_name, None)
+    if func is None:
+        finish({"success": False, "error": "Action %s not found in %s" % (action_name, app_class.__name__)})
+
+    result = func(**params)
+    if inspect.iscoroutine(result):
+        result = asyncio.run(result)
+
+    if not isinstance(result, str):
+        result = json.dumps(result, default=str)
+
+    finish({"success": True, "result": result, "duration": time.time() - start})
+except SystemExit:
+    raise
+except BaseException as e:
+    traceback.print_exc()
+    finish({"success": False, "error": "%s: %s" % (type(e).__name__, e), "duration": time.time() - start})
+`
+
+const actionResultMarker = "SHUFFLE_ACTION_RESULT:"
+
+var actionTimeout = 60 * time.Second
+
+// ActionRunResult is the output of a single action run
+type ActionRunResult struct {
+	Success  bool    `json:"success"`
+	Result   string  `json:"result"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"`
+	Stdout   string  `json:"-"`
+	Stderr   string  `json:"-"`
+}
+
+// runAppAction runs one action of an app with the given parameters. With an
+// image it runs inside that container, otherwise with the local python3.
+// extraEnv is added to the action's environment, e.g. a recording proxy.
+func runAppAction(folderPath, actionName string, params map[string]string, image string, extraEnv ...string) (ActionRunResult, error) {
+	result := ActionRunResult{}
+	paramData, err := json.Marshal(params)
+	if err != nil {
+		return result, err
+	}
+
+	env := append(actionRunnerEnv(), extraEnv...)
+	var stdout, stderr string
+	if len(image) > 0 {
+		runResult, err := dockerRunContainer(image, []string{"python3", "-c", actionRunnerScript, "app.py", actionName, string(paramData)}, env, actionTimeout)
+		if err != nil {
+			return result, err
+		}
+
+		if runResult.TimedOut {
+			return result, fmt.Errorf("action %s timed out after %s", actionName, actionTimeout)
+		}
+
+		stdout, stderr = runResult.Stdout, runResult.Stderr
+	} else {
+		appPath, err := filepath.Abs(filepath.Join(folderPath, "src", "app.py"))
+		if err != nil {
+			return result, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+		defer cancel()
+
+		var stdoutBuffer, stderrBuffer bytes.Buffer
+		cmd := exec.CommandContext(ctx, "python3", "-c", actionRunnerScript, appPath, actionName, string(paramData))
+		cmd.Dir = filepath.Dir(appPath)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout = &stdoutBuffer
+		cmd.Stderr = &stderrBuffer
+		err = cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			return result, fmt.Errorf("action %s timed out after %s", actionName, actionTimeout)
+		}
+
+		if err != nil {
+			log.Printf("[DEBUG] Action runner stderr: %s", stderrBuffer.String())
+			return result, fmt.Errorf("action runner failed: %w", err)
+		}
+
+		stdout, stderr = stdoutBuffer.String(), stderrBuffer.String()
+	}
+
+	return parseActionOutput(stdout, stderr)
+}
+
+// actionRunnerEnv gives the SDK the same backend settings as the CLI itself
+func actionRunnerEnv() []string {
+	return []string{
+		fmt.Sprintf("CALLBACK_URL=%s", uploadUrl),
+		fmt.Sprintf("BASE_URL=%s", uploadUrl),
+		fmt.Sprintf("AUTHORIZATION=%s", strings.TrimPrefix(apikey, "Bearer ")),
+		fmt.Sprintf("SHUFFLE_ORGID=%s", orgId),
+		fmt.Sprintf("EXECUTIONID=%s", newUuid()),
+	}
+}
+
+// parseActionOutput finds the result line printed by the runner script
+func parseActionOutput(stdout, stderr string) (ActionRunResult, error) {
+	result := ActionRunResult{}
+	appOutput := []string{}
+	found := false
+
+	for _, line := range strings.Split(stdout, "\n") {
+		if !strings.HasPrefix(line, actionResultMarker) {
+			appOutput = append(appOutput, line)
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, actionResultMarker)), &result); err != nil {
+			return result, fmt.Errorf("bad result from action runner: %w", err)
+		}
+
+		found = true
+	}
+
+	result.Stdout = strings.TrimSpace(strings.Join(appOutput, "\n"))
+	result.Stderr = stderr
+	if !found {
+		return result, fmt.Errorf("action runner gave no result. Stderr: %s", stderr)
+	}
+
+	return result, nil
+}
+
+// exampleParameters builds parameters from the api.yaml examples. The bool is
+// false if a required parameter has no example.
+func exampleParameters(action shuffle.WorkflowAppAction) (map[string]string, bool) {
+	params := map[string]string{}
+	for _, param := range action.Parameters {
+		if len(param.Example) > 0 {
+			params[param.Name] = param.Example
+		} else if len(param.Value) > 0 {
+			params[param.Name] = param.Value
+		} else if param.Required {
+			return params, false
+		}
+	}
+
+	return params, true
+}
+
+// parseKeyValueArgs turns ["key=value", ...] into a map
+func parseKeyValueArgs(args []string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found || len(key) == 0 {
+			return params, fmt.Errorf("bad parameter '%s'. Use key=value", arg)
+		}
+
+		params[key] = value
+	}
+
+	return params, nil
+}
+
+var execApp = &cobra.Command{
+	Use:   "exec",
+	Short: "Runs a single action of an app: exec <directory> <action> [param=value ...]",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			log.Println("[ERROR] Usage: shufflecli app exec <app directory> <action> [param=value ...]")
+			return
+		}
+
+		params, err := parseKeyValueArgs(args[2:])
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+			return
+		}
+
+		if useDocker && (len(recordCassettePath) > 0 || len(replayCassettePath) > 0) {
+			log.Println("[ERROR] --record and --replay only work for local runs, not with --docker")
+			os.Exit(1)
+		}
+
+		var proxy *cassetteProxy
+		cassette := Cassette{Interactions: []Interaction{}}
+		if len(replayCassettePath) > 0 {
+			cassette, err = loadCassette(replayCassettePath)
+			if err != nil {
+				log.Printf("[ERROR] Problem loading cassette: %s", err)
+				os.Exit(1)
+			}
+
+			if len(params) == 0 {
+				params = cassette.Parameters
+			}
+		}
+
+		// Parameters given on the command line win over the stored ones.
+		// The stored ones are kept out of recorded cassettes.
+		recordedParams := map[string]string{}
+		for key, value := range params {
+			recordedParams[key] = value
+		}
+
+		if len(execAuth) > 0 {
+			authParams, err := authParameters(execAuth)
+			if err != nil {
+				log.Printf("[ERROR] Problem getting authentication %s: %s", execAuth, err)
+				os.Exit(1)
+			}
+
+			for key, value := range authParams {
+				if _, found := params[key]; !found {
+					params[key] = value
+				}
+			}
+		}
+
+		// Secrets in the parameters, including the stored ones, are redacted
+		// wherever they show up in the recorded traffic
+		redactor := newCassetteRedactor(args[0], params)
+		if len(replayCassettePath) > 0 {
+			proxy, err = startCassetteProxy(&cassette, true, redactor)
+		} else if len(recordCassettePath) > 0 {
+			proxy, err = startCassetteProxy(&cassette, false, redactor)
+		}
+
+		if err != nil {
+			log.Printf("[ERROR] Problem starting the cassette proxy: %s", err)
+			os.Exit(1)
+		}
+
+		extraEnv := []string{}
+		if proxy != nil {
+			extraEnv = proxy.env()
+		}
+
+		image := ""
+		if useDocker {
+			image, err = buildAppImage(args[0])
+			if err != nil {
+				log.Printf("[ERROR] Problem building app image: %s", err)
+				os.Exit(1)
+			}
+		}
+
+		result, err := runAppAction(args[0], args[1], params, image, extraEnv...)
+		if proxy != nil {
+			proxy.close()
+		}
+
+		if err == nil && len(recordCassettePath) > 0 {
+			apiData, _ := parseAPIYaml(filepath.Join(args[0], "api.yaml"))
+			cassette.App = apiData.Name
+			cassette.Action = args[1]
+			cassette.Parameters = redactor.parameters(recordedParams)
+			cassette.Success = result.Success
+			cassette.Result = redactor.result(result.Result)
+			cassette.RecordedAt = time.Now().UTC().Format(time.RFC3339)
+			if err := writeCassette(recordCassettePath, cassette); err != nil {
+				log.Printf("[ERROR] Problem writing cassette: %s", err)
+				os.Exit(1)
+			}
+
+			log.Printf("[INFO] Recorded %d HTTP interactions to %s", len(cassette.Interactions), recordCassettePath)
+		}
+
+		if proxy != nil && proxy.replay && len(proxy.misses) > 0 {
+			log.Printf("[WARNING] Requests missing from the cassette: %s", strings.Join(proxy.misses, ", "))
+		}
+
+		if err != nil {
+			log.Printf("[ERROR] Problem running action %s: %s", args[1], err)
+			os.Exit(1)
+		}
+
+		if len(result.Stdout) > 0 {
+			log.Printf("\n\n===== Action output ===== \n%s\n", result.Stdout)
+		}
+
+		if !result.Success {
+			log.Printf("[ERROR] Action %s failed: %s\n%s", args[1], result.Error, result.Stderr)
+			os.Exit(1)
+		}
+
+		log.Printf("[INFO] Action %s finished in %.2fs", args[1], result.Duration)
+		fmt.Println(result.Result)
+	},
+}
+
+func init() {
+	appCmd.AddCommand(execApp)
+
+	execApp.Flags().BoolVar(&useDocker, "docker", false, "Build the app image and run the action inside it")
+	execApp.Flags().DurationVar(&actionTimeout, "timeout", actionTimeout, "Max time for the action to run")
+	execApp.Flags().StringVar(&recordCassettePath, "record", "", "Record the action's HTTP traffic and result to a cassette file")
+	execApp.Flags().StringVar(&replayCassettePath, "replay", "", "Answer the action's HTTP requests from a cassette file")
+	execApp.Flags().StringVar(&execAuth, "auth", "", "Run with the fields of a stored authentication, by label or ID. Only the local dev server returns the values")
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secretsAllowlistFile lists files (globs relative to the app folder) that may
+// contain secrets, optionally limited to a rule: "tests/fixtures/*:SEC005"
+const secretsAllowlistFile = ".secrets-allowlist"
+
+var allowSecrets bool
+
+type secretDetector struct {
+	ID      string
+	Message string
+	Pattern *regexp.Regexp
+}
+
+var secretDetectors = []secretDetector{
+	{
+		ID:      "SEC001",
+		Message: "AWS access key",
+		Pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+	},
+	{
+		ID:      "SEC001",
+		Message: "AWS secret access key",
+		Pattern: regexp.MustCompile(`(?i)aws.{0,20}secret.{0,20}[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`),
+	},
+	{
+		ID:      "SEC002",
+		Message: "JSON Web Token",
+		Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`),
+	},
+	{
+		ID:      "SEC003",
+		Message: "PEM private key block",
+		Pattern: regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY( BLOCK)?-----`),
+	},
+	{
+		ID:      "SEC004",
+		Message: "Shuffle API key",
+		Pattern: regexp.MustCompile(`(?i)(shuffle|apikey|api_key|authorization|bearer).{0,30}\b[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\b`),
+	},
+	{
+		ID:      "SEC006",
+		Message: "Token with a known prefix (GitHub, Slack, OpenAI, Google)",
+		Pattern: regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36}|xox[abposr]-[A-Za-z0-9-]{10,}|sk-[A-Za-z0-9_-]{32,}|AIza[0-9A-Za-z_-]{35})\b`),
+	},
+}
+
+// Candidates for the entropy check: quoted strings and KEY=value assignments
+var entropyCandidateRegex = regexp.MustCompile(`["']([A-Za-z0-9+/=_\-]{20,200})["']|^\s*[A-Za-z_][A-Za-z0-9_]*\s*=\s*([A-Za-z0-9+/=_\-]{20,200})\s*$`)
+var hexStringRegex = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// Hex strings are only an error next to a name like this. Elsewhere they are
+// usually digests, dashless UUIDs or git SHAs.
+var secretNameRegex = regexp.MustCompile(`(?i)(secret|token|api_?key|passw(or)?d|private_?key|auth|credential)`)
+
+// Files that should never be shipped in an app
+var sensitiveFilenames = []string{".env", ".env.*", "id_rsa", "id_dsa", "id_ecdsa", "id_ed25519", "*.pem", "*.key", "*.p12", "*.pfx", ".netrc", ".pypirc"}
+
+// A candidate is flagged when its entropy reaches this fraction of the most a
+// string of its length and alphabet can have. A fixed threshold can't be
+// reached by short strings or hex keys at all.
+const secretEntropyRatio = 0.85
+
+// Shorter hex strings are mostly IDs and short hashes
+const minHexSecretLength = 32
+
+// entropyThreshold is the bits/char above which a candidate looks random
+func entropyThreshold(value string) float64 {
+	alphabet := 64.0
+	if hexStringRegex.MatchString(value) {
+		alphabet = 16
+	}
+
+	return secretEntropyRatio * math.Log2(math.Min(alphabet, float64(len(value))))
+}
+
+// shannonEntropy returns bits per character
+func shannonEntropy(value string) float64 {
+	if len(value) == 0 {
+		return 0
+	}
+
+	counts := map[rune]int{}
+	for _, character := range value {
+		counts[character] += 1
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		probability := float64(count) / float64(len(value))
+		entropy -= probability * math.Log2(probability)
+	}
+
+	return entropy
+}
+
+// scanFileForSecrets runs the pattern and entropy detectors on a single file.
+// Binary files are skipped.
+func scanFileForSecrets(path, relativePath string) ([]Finding, error) {
+	findings := []Finding{}
+	for _, pattern := range sensitiveFilenames {
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			findings = append(findings, Finding{
+				Rule:     "SEC000",
+				Severity: severityError,
+				File:     relativePath,
+				Message:  "Sensitive file should not be part of the app",
+			})
+			break
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return findings, err
+	}
+
+	header := data
+	if len(header) > 8000 {
+		header = header[:8000]
+	}
+
+	if bytes.IndexByte(header, 0) >= 0 {
+		return findings, nil
+	}
+
+	for index, line := range strings.Split(string(data), "\n") {
+		found := false
+		for _, detector := range secretDetectors {
+			if !detector.Pattern.MatchString(line) {
+				continue
+			}
+
+			found = true
+			findings = append(findings, Finding{
+				Rule:     detector.ID,
+				Severity: severityError,
+				File:     relativePath,
+				Line:     index + 1,
+				Message:  detector.Message,
+			})
+			break
+		}
+
+		if found {
+			continue
+		}
+
+		for _, match := range entropyCandidateRegex.FindAllStringSubmatch(line, -1) {
+			candidate := match[1]
+			if len(candidate) == 0 {
+				candidate = match[2]
+			}
+
+			isHex := hexStringRegex.MatchString(candidate)
+			if isHex && len(candidate) < minHexSecretLength {
+				continue
+			}
+
+			if shannonEntropy(candidate) < entropyThreshold(candidate) {
+				continue
+			}
+
+			severity := severityError
+			message := fmt.Sprintf("High entropy string (%.1f bits/char) that looks like a secret", shannonEntropy(candidate))
+			if isHex && !secretNameRegex.MatchString(line) {
+				severity = severityWarning
+				message = fmt.Sprintf("High entropy hex string (%.1f bits/char). Fine if it's a hash or ID", shannonEntropy(candidate))
+			}
+
+			findings = append(findings, Finding{
+				Rule:     "SEC005",
+				Severity: severity,
+				File:     relativePath,
+				Line:     index + 1,
+				Message:  message,
+			})
+			break
+		}
+	}
+
+	return findings, nil
+}
+
+// loadSecretsAllowlist reads the allowlist from the app folder if it exists
+func loadSecretsAllowlist(folderpath string) []string {
+	data, err := ioutil.ReadFile(filepath.Join(folderpath, secretsAllowlistFile))
+	if err != nil {
+		return []string{}
+	}
+
+	allowlist := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		allowlist = append(allowlist, line)
+	}
+
+	return allowlist
+}
+
+// isAllowlisted checks a finding against "glob" or "glob:RULE" entries
+func isAllowlisted(finding Finding, allowlist []string) bool {
+	for _, entry := range allowlist {
+		pattern, rule, _ := strings.Cut(entry, ":")
+		if len(rule) > 0 && rule != finding.Rule {
+			continue
+		}
+
+		if matched, _ := filepath.Match(pattern, finding.File); matched {
+			return true
+		}
+
+		// Allow a folder by its prefix, e.g. "tests/"
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(finding.File, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scanFilesForSecrets scans every file that goes into the upload archive
+func scanFilesForSecrets(folderpath string, files []string) ([]Finding, error) {
+	allowlist := loadSecretsAllowlist(folderpath)
+	findings := []Finding{}
+	for _, path := range files {
+		relativePath, err := filepath.Rel(folderpath, path)
+		if err != nil {
+			relativePath = path
+		}
+
+		if relativePath == secretsAllowlistFile {
+			continue
+		}
+
+		fileFindings, err := scanFileForSecrets(path, relativePath)
+		if err != nil {
+			return findings, err
+		}
+
+		for _, finding := range fileFindings {
+			if isAllowlisted(finding, allowlist) {
+				log.Printf("[DEBUG] Allowlisted secret finding: %s", finding)
+				continue
+			}
+
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings, nil
+}
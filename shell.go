@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// shellWorkerScript keeps the app loaded and answers one JSON request per
+// line on stdin. Replies go to the real stdout prefixed with shellReplyMarker,
+// while anything the app prints is captured and returned with the reply.
+const shellWorkerScript = `
+import asyncio, contextlib, inspect, io, json, logging, os, sys, time, traceback, types
+
+app_path = sys.argv[1]
+proto = sys.__stdout__
+sys.path.insert(0, os.path.dirname(os.path.abspath(app_path)))
+
+class CurrentStderr:
+    def write(self, data):
+        return sys.stderr.write(data)
+    def flush(self):
+        sys.stderr.flush()
+
+logging.basicConfig(stream=CurrentStderr(), level=logging.INFO)
+logger = logging.getLogger("shuffle_app")
+app = None
+
+def reply(output):
+    proto.write("SHUFFLE_SHELL:" + json.dumps(output, default=str) + "\n")
+    proto.flush()
+
+def load():
+    global app
+    source = open(app_path).read().replace("from walkoff_app_sdk.app_base", "from shuffle_sdk")
+    module = types.ModuleType("shuffle_app")
+    module.__file__ = app_path
+    exec(compile(source, app_path, "exec"), module.__dict__)
+
+    app_class = None
+    for value in module.__dict__.values():
+        if inspect.isclass(value) and value.__module__ == "shuffle_app" and any(base.__name__ == "AppBase" for base in value.__mro__[1:]):
+            app_class = value
+
+    if app_class is None:
+        raise Exception("No AppBase subclass found in %s" % app_path)
+
+    try:
+        new_app = app_class(None, logger)
+    except TypeError:
+        new_app = app_class()
+
+    org_id = os.getenv("SHUFFLE_ORGID", "orgId")
+    new_app.url = os.getenv("CALLBACK_URL", getattr(new_app, "url", ""))
+    new_app.base_url = os.getenv("BASE_URL", getattr(new_app, "base_url", ""))
+    new_app.authorization = os.getenv("AUTHORIZATION", "")
+    new_app.current_execution_id = os.getenv("EXECUTIONID", "")
+    new_app.full_execution = {"execution_id": new_app.current_execution_id, "authorization": new_app.authorization, "workflow": {"id": "", "execution_org": {"id": org_id}}}
+    app = new_app
+
+def run(request):
+    output = io.StringIO()
+    start = time.time()
+    response = {"success": True}
+    with contextlib.redirect_stdout(output), contextlib.redirect_stderr(output):
+        try:
+            if request["cmd"] == "load":
+                load()
+            elif app is None:
+                raise Exception("The app isn't loaded. Fix app.py and reload.")
+            else:
+                func = getattr(app, request["action"], None)
+                if func is None:
+                    raise Exception("Action %s not found in the app" % request["action"])
+
+                result = func(**request.get("params", {}))
+                if inspect.iscoroutine(result):
+                    result = asyncio.run(result)
+
+                response["result"] = result if isinstance(result, str) else json.dumps(result, default=str)
+        except BaseException as e:
+            response = {"success": False, "error": "%s: %s" % (type(e).__name__, e), "traceback": traceback.format_exc()}
+
+    response["id"] = request.get("id")
+    response["output"] = output.getvalue()
+    response["duration"] = time.time() - start
+    return response
+
+for line in sys.stdin:
+    if line.strip():
+        reply(run(json.loads(line)))
+`
+
+const shellReplyMarker = "SHUFFLE_SHELL:"
+
+// shellReply is the worker's answer to a load or call
+type shellReply struct {
+	ID        int     `json:"id"`
+	Success   bool    `json:"success"`
+	Result    string  `json:"result"`
+	Error     string  `json:"error"`
+	Traceback string  `json:"traceback"`
+	Output    string  `json:"output"`
+	Duration  float64 `json:"duration"`
+}
+
+// lockedBuffer is a buffer the worker's stderr can be written to while the
+// shell reads it
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer strings.Builder
+}
+
+func (buffer *lockedBuffer) Write(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(data)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.String()
+}
+
+// shellWorker is the long-lived python process the shell talks to. Every
+// request has an ID, so a late reply to a request that timed out is dropped.
+type shellWorker struct {
+	appPath   string
+	modTime   time.Time
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	replies   chan string
+	done      chan struct{}
+	exitErr   error
+	closed    chan struct{}
+	stderr    *lockedBuffer
+	requestId int
+}
+
+func startShellWorker(appPath string) (*shellWorker, error) {
+	worker := &shellWorker{appPath: appPath, replies: make(chan string), done: make(chan struct{}), closed: make(chan struct{}), stderr: &lockedBuffer{}}
+	worker.cmd = exec.Command("python3", "-c", shellWorkerScript, appPath)
+	worker.cmd.Dir = filepath.Dir(appPath)
+	worker.cmd.Env = append(os.Environ(), actionRunnerEnv()...)
+	worker.cmd.Stderr = worker.stderr
+
+	var err error
+	worker.stdin, err = worker.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := worker.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := worker.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+		for scanner.Scan() {
+			if !strings.HasPrefix(scanner.Text(), shellReplyMarker) {
+				continue
+			}
+
+			// Nobody reads replies after close(), so they are dropped
+			select {
+			case worker.replies <- strings.TrimPrefix(scanner.Text(), shellReplyMarker):
+			case <-worker.closed:
+			}
+		}
+
+		// done is closed once, so request and close can both wait for it
+		worker.exitErr = worker.cmd.Wait()
+		close(worker.done)
+	}()
+
+	return worker, nil
+}
+
+// request sends one request and waits for its reply or the action timeout.
+// After a timeout the worker is still busy and has to be restarted.
+func (worker *shellWorker) request(request map[string]interface{}) (shellReply, error) {
+	worker.requestId += 1
+	request["id"] = worker.requestId
+	data, err := json.Marshal(request)
+	if err != nil {
+		return shellReply{}, err
+	}
+
+	if _, err := worker.stdin.Write(append(data, '\n')); err != nil {
+		return shellReply{}, fmt.Errorf("the python worker stopped: %s", worker.stderr.String())
+	}
+
+	timeout := time.After(actionTimeout)
+	for {
+		select {
+		case line := <-worker.replies:
+			reply := shellReply{}
+			if err := json.Unmarshal([]byte(line), &reply); err != nil {
+				return reply, err
+			}
+
+			if reply.ID != worker.requestId {
+				log.Printf("[DEBUG] Dropping a late reply to request %d", reply.ID)
+				continue
+			}
+
+			return reply, nil
+		case <-worker.done:
+			return shellReply{}, fmt.Errorf("the python worker stopped (%v): %s", worker.exitErr, worker.stderr.String())
+		case <-timeout:
+			return shellReply{}, fmt.Errorf("no reply within %s", actionTimeout)
+		}
+	}
+}
+
+// load (re)loads app.py in the worker and remembers when it was changed
+func (worker *shellWorker) load() (shellReply, error) {
+	if info, err := os.Stat(worker.appPath); err == nil {
+		worker.modTime = info.ModTime()
+	}
+
+	return worker.request(map[string]interface{}{"cmd": "load"})
+}
+
+// changed is true if app.py was saved since the last load
+func (worker *shellWorker) changed() bool {
+	info, err := os.Stat(worker.appPath)
+	return err == nil && info.ModTime() != worker.modTime
+}
+
+// close stops the worker, killing it if it's stuck in an action
+func (worker *shellWorker) close() {
+	select {
+	case <-worker.closed:
+		return
+	default:
+	}
+
+	close(worker.closed)
+	worker.stdin.Close()
+	select {
+	case <-worker.done:
+	case <-time.After(2 * time.Second):
+		worker.cmd.Process.Kill()
+		<-worker.done
+	}
+}
+
+// splitShellArgs splits a line on spaces, keeping quoted parts together
+func splitShellArgs(line string) ([]string, error) {
+	args := []string{}
+	current := &strings.Builder{}
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, char := range line {
+		switch {
+		case escaped:
+			current.WriteRune(char)
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case char == '"' || char == '\'':
+			quote = char
+			inArg = true
+		case char == ' ' || char == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(char)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return args, fmt.Errorf("missing closing quote")
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// appShell is the prompt around a worker
+type appShell struct {
+	apiData  *shuffle.WorkflowApp
+	worker   *shellWorker
+	out      io.Writer
+	terminal *term.Terminal
+}
+
+var shellCommands = []string{"actions", "help", "reload", "exit"}
+
+func (shell *appShell) findAction(name string) (shuffle.WorkflowAppAction, bool) {
+	for _, action := range shell.apiData.Actions {
+		if action.Name == name {
+			return action, true
+		}
+	}
+
+	return shuffle.WorkflowAppAction{}, false
+}
+
+// complete handles tab: action names first, then name= for the action's parameters
+func (shell *appShell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	prefix := line[:pos]
+	words := strings.Split(prefix, " ")
+	current := words[len(words)-1]
+
+	candidates := []string{}
+	if len(words) == 1 {
+		for _, action := range shell.apiData.Actions {
+			candidates = append(candidates, action.Name+" ")
+		}
+
+		for _, command := range shellCommands {
+			candidates = append(candidates, command+" ")
+		}
+	} else if action, ok := shell.findAction(words[0]); ok {
+		for _, param := range action.Parameters {
+			if !strings.Contains(prefix, " "+param.Name+"=") {
+				candidates = append(candidates, param.Name+"=")
+			}
+		}
+	}
+
+	matches := []string{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	sort.Strings(matches)
+	completion := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+
+	if len(matches) > 1 && completion == current {
+		fmt.Fprintf(shell.out, "%s\n", strings.Join(matches, "  "))
+		return "", 0, false
+	}
+
+	newPrefix := prefix[:len(prefix)-len(current)] + completion
+	return newPrefix + line[pos:], len(newPrefix), true
+}
+
+func (shell *appShell) printActions() {
+	for _, action := range shell.apiData.Actions {
+		params := []string{}
+		for _, param := range action.Parameters {
+			if param.Required {
+				params = append(params, param.Name+"*")
+			} else {
+				params = append(params, param.Name)
+			}
+		}
+
+		fmt.Fprintf(shell.out, "  %-30s %s\n", action.Name, strings.Join(params, " "))
+	}
+
+	fmt.Fprintf(shell.out, "(* = required)\n")
+}
+
+func (shell *appShell) printReply(reply shellReply) {
+	if len(strings.TrimSpace(reply.Output)) > 0 {
+		fmt.Fprintf(shell.out, "%s\n", strings.TrimRight(reply.Output, "\n"))
+	}
+
+	if !reply.Success {
+		fmt.Fprintf(shell.out, "%s\n%s\n", strings.TrimRight(reply.Traceback, "\n"), reply.Error)
+		return
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(reply.Result), &parsed); err == nil {
+		if pretty, err := json.MarshalIndent(parsed, "", "  "); err == nil {
+			reply.Result = string(pretty)
+		}
+	}
+
+	if len(reply.Result) > 0 {
+		fmt.Fprintf(shell.out, "%s\n", reply.Result)
+	}
+
+	fmt.Fprintf(shell.out, "(%.2fs)\n", reply.Duration)
+}
+
+// restart replaces a worker that stopped or is stuck
+func (shell *appShell) restart() bool {
+	fmt.Fprintf(shell.out, "Restarting the worker.\n")
+	shell.worker.close()
+	worker, err := startShellWorker(shell.worker.appPath)
+	if err != nil {
+		fmt.Fprintf(shell.out, "Problem restarting the worker: %s\n", err)
+		return false
+	}
+
+	shell.worker = worker
+	return true
+}
+
+func (shell *appShell) reload() {
+	reply, err := shell.worker.load()
+	if err != nil {
+		fmt.Fprintf(shell.out, "Problem reloading: %s\n", err)
+		shell.restart()
+		return
+	}
+
+	if !reply.Success {
+		shell.printReply(reply)
+		return
+	}
+
+	fmt.Fprintf(shell.out, "Loaded %s\n", shell.worker.appPath)
+}
+
+// handle runs one line. Returns false to exit.
+func (shell *appShell) handle(line string) bool {
+	args, err := splitShellArgs(line)
+	if err != nil {
+		fmt.Fprintf(shell.out, "%s\n", err)
+		return true
+	}
+
+	if len(args) == 0 {
+		return true
+	}
+
+	switch args[0] {
+	case "exit", "quit":
+		return false
+	case "help":
+		fmt.Fprintf(shell.out, "  <action> param=value ...  Run an action\n  actions                   List actions and parameters\n  reload                    Reload app.py (done automatically when it changes)\n  exit                      Leave the shell\n")
+		return true
+	case "actions":
+		shell.printActions()
+		return true
+	case "reload":
+		shell.reload()
+		return true
+	}
+
+	if _, ok := shell.findAction(args[0]); !ok {
+		fmt.Fprintf(shell.out, "Unknown action '%s'. Type 'actions' to list them.\n", args[0])
+		return true
+	}
+
+	params, err := parseKeyValueArgs(args[1:])
+	if err != nil {
+		fmt.Fprintf(shell.out, "%s\n", err)
+		return true
+	}
+
+	if shell.worker.changed() {
+		fmt.Fprintf(shell.out, "app.py changed. Reloading.\n")
+		shell.reload()
+	}
+
+	reply, err := shell.worker.request(map[string]interface{}{"cmd": "call", "action": args[0], "params": params})
+	if err != nil {
+		fmt.Fprintf(shell.out, "%s\n", err)
+		if !shell.restart() {
+			return false
+		}
+
+		shell.reload()
+		return true
+	}
+
+	shell.printReply(reply)
+	return true
+}
+
+func runAppShell(folderPath string) error {
+	apiData, err := parseAPIYaml(filepath.Join(folderPath, "api.yaml"))
+	if err != nil {
+		return err
+	}
+
+	appPath, err := filepath.Abs(filepath.Join(folderPath, "src", "app.py"))
+	if err != nil {
+		return err
+	}
+
+	worker, err := startShellWorker(appPath)
+	if err != nil {
+		return err
+	}
+
+	shell := &appShell{apiData: apiData, worker: worker, out: os.Stdout}
+	defer func() {
+		shell.worker.close()
+	}()
+
+	prompt := fmt.Sprintf("%s> ", strings.ReplaceAll(strings.ToLower(apiData.Name), " ", "_"))
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// Piped input: no line editing, one command per line
+		shell.reload()
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if !shell.handle(scanner.Text()) {
+				break
+			}
+		}
+
+		return scanner.Err()
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+
+	defer term.Restore(fd, state)
+	shell.terminal = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, prompt)
+	shell.terminal.AutoCompleteCallback = shell.complete
+	shell.out = shell.terminal
+
+	fmt.Fprintf(shell.out, "%s %s. Type 'actions' to list actions, tab to complete and 'exit' to leave.\n", apiData.Name, apiData.AppVersion)
+	shell.reload()
+	for {
+		line, err := shell.terminal.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if !shell.handle(line) {
+			return nil
+		}
+	}
+}
+
+var shellApp = &cobra.Command{
+	Use:   "shell",
+	Short: "Loads an app once and runs its actions from a prompt",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No directory provided. Use the absolute path to the app directory.")
+			return
+		}
+
+		if err := runAppShell(args[0]); err != nil {
+			log.Printf("[ERROR] Problem running the app shell: %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	appCmd.AddCommand(shellApp)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testShellApp defines its own AppBase, so the worker can load it without
+// shuffle_sdk installed
+const testShellApp = `import os
+
+class AppBase:
+    def __init__(self, redis=None, logger=None, console_logger=None):
+        pass
+
+class TestApp(AppBase):
+    def hello(self, name):
+        print("saying hi")
+        return "hi " + name
+
+    def die(self):
+        os._exit(3)
+`
+
+func startTestShellWorker(t *testing.T) *shellWorker {
+	t.Helper()
+
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+
+	appPath := filepath.Join(t.TempDir(), "app.py")
+	if err := os.WriteFile(appPath, []byte(testShellApp), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	worker, err := startShellWorker(appPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := worker.load()
+	if err != nil || !reply.Success {
+		worker.close()
+		t.Fatalf("load = %+v, %v, expected success", reply, err)
+	}
+
+	return worker
+}
+
+// within fails the test if run doesn't return in time, instead of hanging
+func within(t *testing.T, timeout time.Duration, name string, run func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("%s didn't return within %s", name, timeout)
+	}
+}
+
+func TestShellWorkerCall(t *testing.T) {
+	worker := startTestShellWorker(t)
+	defer worker.close()
+
+	reply, err := worker.request(map[string]interface{}{"cmd": "call", "action": "hello", "params": map[string]string{"name": "shuffle"}})
+	if err != nil || !reply.Success || reply.Result != "hi shuffle" || !strings.Contains(reply.Output, "saying hi") {
+		t.Errorf("hello = %+v, %v, expected 'hi shuffle' with the printed output", reply, err)
+	}
+
+	reply, err = worker.request(map[string]interface{}{"cmd": "call", "action": "missing"})
+	if err != nil || reply.Success || !strings.Contains(reply.Error, "not found") {
+		t.Errorf("missing action = %+v, %v, expected a not found error", reply, err)
+	}
+}
+
+func TestShellRestartAfterWorkerDies(t *testing.T) {
+	worker := startTestShellWorker(t)
+	shell := &appShell{worker: worker, out: &bytes.Buffer{}}
+
+	within(t, 10*time.Second, "request to a dying worker", func() {
+		_, err := worker.request(map[string]interface{}{"cmd": "call", "action": "die"})
+		if err == nil || !strings.Contains(err.Error(), "worker stopped") {
+			t.Errorf("die = %v, expected the worker to stop", err)
+		}
+	})
+
+	// The exit was already seen by request, so close must not wait for it again
+	restarted := false
+	within(t, 10*time.Second, "restart", func() {
+		restarted = shell.restart()
+	})
+
+	if !restarted || shell.worker == worker {
+		t.Fatalf("restart = %v, expected a new worker", restarted)
+	}
+
+	defer shell.worker.close()
+	reply, err := shell.worker.load()
+	if err != nil || !reply.Success {
+		t.Fatalf("load after restart = %+v, %v", reply, err)
+	}
+
+	reply, err = shell.worker.request(map[string]interface{}{"cmd": "call", "action": "hello", "params": map[string]string{"name": "again"}})
+	if err != nil || reply.Result != "hi again" {
+		t.Errorf("hello after restart = %+v, %v", reply, err)
+	}
+}
+
+func TestShellWorkerCloseAfterKill(t *testing.T) {
+	worker := startTestShellWorker(t)
+	worker.cmd.Process.Kill()
+
+	within(t, 10*time.Second, "close of a killed worker", worker.close)
+	within(t, time.Second, "second close", worker.close)
+}
+
+func TestSplitShellArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+		err      bool
+	}{
+		{`hello name=x`, []string{"hello", "name=x"}, false},
+		{`  hello   name="a b"  `, []string{"hello", "name=a b"}, false},
+		{`run body='{"a": "b"}'`, []string{"run", `body={"a": "b"}`}, false},
+		{`run value=a\ b`, []string{"run", "value=a b"}, false},
+		{`run empty=""`, []string{"run", "empty="}, false},
+		{`run name="unclosed`, nil, true},
+	}
+
+	for _, test := range tests {
+		args, err := splitShellArgs(test.line)
+		if test.err {
+			if err == nil {
+				t.Errorf("splitShellArgs(%q) should fail", test.line)
+			}
+
+			continue
+		}
+
+		if err != nil || strings.Join(args, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("splitShellArgs(%q) = %q, %v, expected %q", test.line, args, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+	"golang.org/x/term"
+)
+
+var assumeYes bool
+var forceUpload bool
+var skipTests bool
+var uploadStatePath string
+var uploadAll bool
+var uploadConcurrency = 4
+var changedSince string
+
+// uploadSlots bounds how many uploads run at once with --all
+var uploadSlots chan struct{}
+
+// pythonEnvLock serializes installing requirements into the shared python
+// environment and running apps in it, so with --all only the static checks
+// run in parallel
+var pythonEnvLock sync.Mutex
+
+// Exit codes for upload, so CI can tell failures apart
+const (
+	exitValidationFailed = 2
+	exitAuthFailed       = 3
+	exitServerFailed     = 4
+)
+
+var errValidationFailed = errors.New("validation failed")
+var errAuthFailed = errors.New("authentication failed")
+var errServerFailed = errors.New("server failed")
+
+// uploadExitCode maps an upload error to the exit code for it
+func uploadExitCode(err error) int {
+	switch {
+	case errors.Is(err, errValidationFailed):
+		return exitValidationFailed
+	case errors.Is(err, errAuthFailed):
+		return exitAuthFailed
+	case errors.Is(err, errServerFailed):
+		return exitServerFailed
+	}
+
+	return 1
+}
+
+// statusError wraps a bad HTTP response as an auth or server failure
+func statusError(statusCode int, status string, body []byte) error {
+	if statusCode == 401 || statusCode == 403 {
+		return fmt.Errorf("%w: %s. Check SHUFFLE_APIKEY. Raw: %s", errAuthFailed, status, strings.TrimSpace(string(body)))
+	}
+
+	return fmt.Errorf("%w: bad status %s. Raw: %s", errServerFailed, status, strings.TrimSpace(string(body)))
+}
+
+// isInteractive is true if stdin is a terminal we can prompt on
+func isInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// confirm asks a question on the terminal. With --yes it is always true, and
+// without a terminal it returns the default without prompting.
+func confirm(question string, defaultAnswer bool) bool {
+	if assumeYes {
+		return true
+	}
+
+	if !isInteractive() {
+		return defaultAnswer
+	}
+
+	options := "[y/N]"
+	if defaultAnswer {
+		options = "[Y/n]"
+	}
+
+	fmt.Printf("\n%s %s: ", question, options)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if len(answer) == 0 {
+		return defaultAnswer
+	}
+
+	return answer == "y" || answer == "yes"
+}
+
+// uploadRecord is what was last uploaded for an app version
+type uploadRecord struct {
+	Hash       string `json:"hash"`
+	UploadedAt int64  `json:"uploaded_at"`
+}
+
+// uploadResult is what happened to one app version in an upload run
+type uploadResult struct {
+	Folder   string
+	Status   string
+	Duration time.Duration
+	Err      error
+}
+
+// uploadSummary collects the results of an upload run
+type uploadSummary struct {
+	sync.Mutex
+	Results []uploadResult
+}
+
+func (summary *uploadSummary) add(folderPath string, status string, duration time.Duration, err error) {
+	summary.Lock()
+	defer summary.Unlock()
+
+	summary.Results = append(summary.Results, uploadResult{Folder: folderPath, Status: status, Duration: duration, Err: err})
+}
+
+// counts returns how many were uploaded, skipped and failed
+func (summary *uploadSummary) counts() (int, int, int) {
+	uploaded, skipped, failed := 0, 0, 0
+	for _, result := range summary.Results {
+		switch result.Status {
+		case "uploaded":
+			uploaded += 1
+		case "skipped":
+			skipped += 1
+		default:
+			failed += 1
+		}
+	}
+
+	return uploaded, skipped, failed
+}
+
+// firstError is the error of the first failed app, in folder order
+func (summary *uploadSummary) firstError() error {
+	for _, result := range summary.Results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+
+	return nil
+}
+
+func (summary *uploadSummary) log() {
+	uploaded, skipped, failed := summary.counts()
+	log.Printf("[INFO] Upload summary: %d uploaded, %d skipped, %d failed", uploaded, skipped, failed)
+	for _, result := range summary.Results {
+		if result.Err != nil {
+			log.Printf("[ERROR] Failed: %s", result.Folder)
+		}
+	}
+}
+
+// printTable shows one line per app version, sorted by folder
+func (summary *uploadSummary) printTable(root string) {
+	sort.Slice(summary.Results, func(i, j int) bool {
+		return summary.Results[i].Folder < summary.Results[j].Folder
+	})
+
+	fmt.Printf("\n%-40s %-10s %8s  %s\n", "APP", "STATUS", "TIME", "DETAILS")
+	for _, result := range summary.Results {
+		name, err := filepath.Rel(root, result.Folder)
+		if err != nil {
+			name = result.Folder
+		}
+
+		details := ""
+		if result.Err != nil {
+			details = result.Err.Error()
+		}
+
+		fmt.Printf("%-40s %-10s %7.1fs  %s\n", filepath.ToSlash(name), result.Status, result.Duration.Seconds(), details)
+	}
+
+	uploaded, skipped, failed := summary.counts()
+	fmt.Printf("\n%d uploaded, %d skipped, %d failed\n", uploaded, skipped, failed)
+}
+
+var uploadStateLock sync.Mutex
+
+// hasOrgId is true if an org was set with SHUFFLE_ORGID or a profile,
+// rather than the placeholder
+func hasOrgId() bool {
+	return len(orgId) > 0 && orgId != "orgId"
+}
+
+// defaultUploadStatePath is where hashes of uploaded apps are kept between runs
+func defaultUploadStatePath() string {
+	if len(os.Getenv("SHUFFLE_UPLOAD_STATE")) > 0 {
+		return os.Getenv("SHUFFLE_UPLOAD_STATE")
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "."
+	}
+
+	return filepath.Join(configDir, "shufflecli", "uploads.json")
+}
+
+// skipUploadFile is true for files that are never part of the uploaded archive
+func skipUploadFile(relativePath string, entry fs.DirEntry) bool {
+	name := entry.Name()
+	if entry.IsDir() {
+		return name == ".git" || name == "__pycache__" || name == "node_modules"
+	}
+
+	return relativePath == "upload.zip" || strings.HasSuffix(name, ".pyc") || name == ".DS_Store"
+}
+
+// appContentHash hashes the files that get uploaded for an app version.
+// Paths are sorted and line endings normalized, so the hash only changes
+// when the content does, not with timestamps or checkouts on other systems.
+func appContentHash(folderPath string) (string, error) {
+	files := []string{}
+	err := filepath.WalkDir(folderPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, _ := filepath.Rel(folderPath, path)
+		if skipUploadFile(filepath.ToSlash(relativePath), entry) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !entry.IsDir() {
+			files = append(files, filepath.ToSlash(relativePath))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(files)
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(folderPath, filepath.FromSlash(file)))
+		if err != nil {
+			return "", err
+		}
+
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+		hash.Write(data)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// appHashKey names an app version in the state file and org cache
+func appHashKey(folderPath string) (string, error) {
+	apiData, err := parseAPIYaml(filepath.Join(folderPath, "api.yaml"))
+	if err != nil {
+		return "", err
+	}
+
+	if len(apiData.Name) == 0 || len(apiData.AppVersion) == 0 {
+		return "", fmt.Errorf("api.yaml needs a name and app_version")
+	}
+
+	return fmt.Sprintf("shufflecli_app_hash_%s_%s", strings.ToLower(strings.ReplaceAll(apiData.Name, " ", "_")), apiData.AppVersion), nil
+}
+
+func loadUploadState(statePath string) (map[string]map[string]uploadRecord, error) {
+	state := map[string]map[string]uploadRecord{}
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+
+	if err != nil {
+		return state, err
+	}
+
+	return state, json.Unmarshal(data, &state)
+}
+
+// orgCacheRequest calls the org cache API on the Shuffle instance
+func orgCacheRequest(action string, cacheData shuffle.CacheKeyData) (shuffle.CacheKeyData, error) {
+	cacheData.OrgId = orgId
+	requestBody, err := json.Marshal(cacheData)
+	if err != nil {
+		return cacheData, err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/orgs/%s/%s", uploadUrl, orgId, action), bytes.NewReader(requestBody))
+	if err != nil {
+		return cacheData, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apikey))
+	req.Header.Set("Content-Type", "application/json")
+	setOrgHeader(req, "")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return cacheData, err
+	}
+
+	defer resp.Body.Close()
+	outputBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return cacheData, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return cacheData, fmt.Errorf("bad status %s. Raw: %s", resp.Status, strings.TrimSpace(string(outputBody)))
+	}
+
+	result := shuffle.CacheKeyData{}
+	return result, json.Unmarshal(outputBody, &result)
+}
+
+// lastUploadedHash finds the hash the app version was last uploaded with.
+// The org cache on the server is used when SHUFFLE_ORGID is set, so
+// separate CI runners agree. Otherwise the local state file is used.
+func lastUploadedHash(key string) string {
+	if hasOrgId() {
+		cacheData, err := orgCacheRequest("get_cache", shuffle.CacheKeyData{Key: key})
+		if err == nil {
+			return cacheData.Value
+		}
+
+		log.Printf("[DEBUG] No upload hash for %s in the org cache: %s", key, err)
+	}
+
+	uploadStateLock.Lock()
+	defer uploadStateLock.Unlock()
+
+	state, err := loadUploadState(uploadStatePath)
+	if err != nil {
+		log.Printf("[WARNING] Problem reading upload state from %s: %s", uploadStatePath, err)
+		return ""
+	}
+
+	return state[uploadUrl][key].Hash
+}
+
+// saveUploadedHash records the hash after a successful upload
+func saveUploadedHash(key, hash string) error {
+	if hasOrgId() {
+		if _, err := orgCacheRequest("set_cache", shuffle.CacheKeyData{Key: key, Value: hash}); err != nil {
+			log.Printf("[WARNING] Problem saving the upload hash in the org cache: %s", err)
+		}
+	}
+
+	uploadStateLock.Lock()
+	defer uploadStateLock.Unlock()
+
+	state, err := loadUploadState(uploadStatePath)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := state[uploadUrl]; !ok {
+		state[uploadUrl] = map[string]uploadRecord{}
+	}
+
+	state[uploadUrl][key] = uploadRecord{Hash: hash, UploadedAt: time.Now().Unix()}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(uploadStatePath), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(uploadStatePath, data, 0600)
+}
+
+// appUnchanged is true if the app version was already uploaded with the
+// same content. Returns the key and hash to save after uploading.
+func appUnchanged(folderPath string) (bool, string, string) {
+	key, err := appHashKey(folderPath)
+	if err != nil {
+		log.Printf("[WARNING] Can't tell if %s changed: %s", folderPath, err)
+		return false, "", ""
+	}
+
+	hash, err := appContentHash(folderPath)
+	if err != nil {
+		log.Printf("[WARNING] Problem hashing %s: %s", folderPath, err)
+		return false, "", ""
+	}
+
+	return lastUploadedHash(key) == hash, key, hash
+}
+
+// changedAppFolders keeps the app folders with files changed since ref,
+// including uncommitted and untracked files
+func changedAppFolders(root string, folders []string, ref string) ([]string, error) {
+	output, err := exec.Command("git", "-C", root, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s is not in a git repository: %s", root, err)
+	}
+
+	topLevel := strings.TrimSpace(string(output))
+	changed := []string{}
+	for _, command := range [][]string{
+		{"diff", "--name-only", ref, "--"},
+		{"ls-files", "--others", "--exclude-standard", "--full-name"},
+	} {
+		output, err := exec.Command("git", append([]string{"-C", root}, command...)...).Output()
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return nil, fmt.Errorf("git %s failed: %s", strings.Join(command, " "), strings.TrimSpace(string(exitErr.Stderr)))
+			}
+
+			return nil, err
+		}
+
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			if len(line) > 0 {
+				changed = append(changed, filepath.Join(topLevel, filepath.FromSlash(line)))
+			}
+		}
+	}
+
+	touched := []string{}
+	for _, folder := range folders {
+		absolutePath, err := filepath.Abs(folder)
+		if err != nil {
+			return nil, err
+		}
+
+		// git reports resolved paths, e.g. /private/tmp on macOS
+		if resolved, err := filepath.EvalSymlinks(absolutePath); err == nil {
+			absolutePath = resolved
+		}
+
+		for _, file := range changed {
+			if strings.HasPrefix(file, absolutePath+string(filepath.Separator)) {
+				touched = append(touched, folder)
+				break
+			}
+		}
+	}
+
+	return touched, nil
+}
+
+// uploadAllApps validates and uploads every app version under root. The
+// static checks run on all CPUs, installing and running the apps one at a
+// time, and uploads are bounded by --concurrency.
+func uploadAllApps(root string) (*uploadSummary, error) {
+	folders, err := findAppFolders(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(changedSince) > 0 {
+		total := len(folders)
+		folders, err = changedAppFolders(root, folders, changedSince)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("[INFO] %d of %d app versions changed since %s", len(folders), total, changedSince)
+	}
+
+	summary := &uploadSummary{}
+	if len(folders) == 0 {
+		return summary, nil
+	}
+
+	// Ask once up front instead of for every app
+	question := fmt.Sprintf("Upload %d app versions from %s?", len(folders), root)
+	defaultAnswer := true
+	if forceUpload {
+		question = fmt.Sprintf("Upload %d app versions from %s, including ones that fail validation?", len(folders), root)
+		defaultAnswer = false
+	}
+
+	if forceUpload && !assumeYes && !isInteractive() {
+		return summary, fmt.Errorf("--force without a terminal also needs --yes to confirm uploading apps that fail validation")
+	}
+
+	if !confirm(question, defaultAnswer) {
+		return summary, fmt.Errorf("upload aborted")
+	}
+
+	if uploadConcurrency < 1 {
+		uploadConcurrency = 1
+	}
+
+	uploadSlots = make(chan struct{}, uploadConcurrency)
+	limit := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for _, folder := range folders {
+		wg.Add(1)
+		go func(folder string) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			start := time.Now()
+			status, err := uploadAppFolder(folder, true)
+			summary.add(folder, status, time.Since(start), err)
+		}(folder)
+	}
+
+	wg.Wait()
+	return summary, nil
+}
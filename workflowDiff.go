@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+)
+
+var diffAllFields bool
+
+// Fields the UI and backend change on their own. Skipped unless --all is set.
+var volatileWorkflowFields = map[string]bool{
+	"position":            true,
+	"created":             true,
+	"edited":              true,
+	"updated":             true,
+	"last_runtime":        true,
+	"is_valid":            true,
+	"errors":              true,
+	"previous_parameters": true,
+	"large_image":         true,
+	"small_image":         true,
+	"image":               true,
+}
+
+// listItemKey names a list item by its id, name or label, so adding or
+// removing an item doesn't show every item after it as changed
+func listItemKey(item interface{}) string {
+	fields, ok := item.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	for _, key := range []string{"id", "name", "label"} {
+		if value, ok := fields[key].(string); ok && len(value) > 0 {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// flattenFields turns a struct into "path.to.field" => value, leaving out
+// the top level fields in skip. List items are "list[key]" when they have a
+// key and "list.index" otherwise.
+func flattenFields(value interface{}, skip ...string) map[string]string {
+	fields := map[string]string{}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return fields
+	}
+
+	for _, key := range skip {
+		delete(parsed, key)
+	}
+
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			for key, child := range typed {
+				walk(strings.TrimPrefix(prefix+"."+key, "."), child)
+			}
+		case []interface{}:
+			seen := map[string]bool{}
+			for index, child := range typed {
+				key := listItemKey(child)
+				if len(key) == 0 || seen[key] {
+					walk(fmt.Sprintf("%s.%d", prefix, index), child)
+					continue
+				}
+
+				seen[key] = true
+				walk(fmt.Sprintf("%s[%s]", prefix, key), child)
+			}
+		default:
+			// false and 0 are kept, so turning an option off shows up
+			if formatted := jsonValueToString(value); len(formatted) > 0 {
+				fields[prefix] = formatted
+			}
+		}
+	}
+
+	walk("", parsed)
+	return fields
+}
+
+func isVolatileField(path string) bool {
+	for _, part := range strings.Split(path, ".") {
+		if volatileWorkflowFields[part] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fieldChanges lists the fields that differ, sorted by path
+func fieldChanges(before, after map[string]string) []string {
+	paths := map[string]bool{}
+	for path := range before {
+		paths[path] = true
+	}
+
+	for path := range after {
+		paths[path] = true
+	}
+
+	changes := []string{}
+	for path := range paths {
+		if before[path] == after[path] || (!diffAllFields && isVolatileField(path)) {
+			continue
+		}
+
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", path, shortValue(before[path]), shortValue(after[path])))
+	}
+
+	sort.Strings(changes)
+	return changes
+}
+
+// shortValue quotes a value for a single line of the diff
+func shortValue(value string) string {
+	if len(value) > 60 {
+		value = value[:57] + "..."
+	}
+
+	return fmt.Sprintf("%q", value)
+}
+
+// parameterChanges compares parameters by name. Code and other long values
+// get a line diff.
+func parameterChanges(owner string, before, after []shuffle.WorkflowAppActionParameter) []string {
+	changes := []string{}
+	afterByName := map[string]shuffle.WorkflowAppActionParameter{}
+	for _, param := range after {
+		afterByName[param.Name] = param
+	}
+
+	beforeNames := map[string]bool{}
+	for _, param := range before {
+		beforeNames[param.Name] = true
+		changed, found := afterByName[param.Name]
+		if !found {
+			changes = append(changes, fmt.Sprintf("parameter %s removed", param.Name))
+			continue
+		}
+
+		if param.Value != changed.Value {
+			isCode := param.Multiline || changed.Multiline || strings.Contains(param.Value+changed.Value, "\n") || len(param.Value) > 60 || len(changed.Value) > 60
+			if isCode {
+				codeDiff := strings.TrimRight(unifiedDiff(fmt.Sprintf("%s/%s", owner, param.Name), param.Value, changed.Value), "\n")
+				changes = append(changes, fmt.Sprintf("parameter %s:\n  %s", param.Name, strings.ReplaceAll(codeDiff, "\n", "\n  ")))
+			} else {
+				changes = append(changes, fmt.Sprintf("parameter %s: %s -> %s", param.Name, shortValue(param.Value), shortValue(changed.Value)))
+			}
+		}
+
+		if diffAllFields {
+			for _, change := range fieldChanges(flattenFields(param, "value"), flattenFields(changed, "value")) {
+				changes = append(changes, fmt.Sprintf("parameter %s %s", param.Name, change))
+			}
+		}
+	}
+
+	for _, param := range after {
+		if !beforeNames[param.Name] {
+			changes = append(changes, fmt.Sprintf("parameter %s added: %s", param.Name, shortValue(param.Value)))
+		}
+	}
+
+	return changes
+}
+
+// matchByIdOrLabel pairs up items from two versions, first by ID and then
+// by label for the ones left. Returns the index pairs, and the indexes only
+// in before or after.
+func matchByIdOrLabel(beforeIds, afterIds, beforeLabels, afterLabels []string) (pairs [][2]int, removed []int, added []int) {
+	matchedBefore := map[int]bool{}
+	matchedAfter := map[int]bool{}
+	for _, keys := range [][2][]string{{beforeIds, afterIds}, {beforeLabels, afterLabels}} {
+		for beforeIndex, beforeKey := range keys[0] {
+			if matchedBefore[beforeIndex] || len(beforeKey) == 0 {
+				continue
+			}
+
+			for afterIndex, afterKey := range keys[1] {
+				if !matchedAfter[afterIndex] && beforeKey == afterKey {
+					pairs = append(pairs, [2]int{beforeIndex, afterIndex})
+					matchedBefore[beforeIndex] = true
+					matchedAfter[afterIndex] = true
+					break
+				}
+			}
+		}
+	}
+
+	for index := range beforeIds {
+		if !matchedBefore[index] {
+			removed = append(removed, index)
+		}
+	}
+
+	for index := range afterIds {
+		if !matchedAfter[index] {
+			added = append(added, index)
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][1] < pairs[j][1] })
+	return pairs, removed, added
+}
+
+// nodeLabels maps action and trigger IDs to their labels
+func nodeLabels(workflow shuffle.Workflow) map[string]string {
+	labels := map[string]string{}
+	for _, action := range workflow.Actions {
+		labels[action.ID] = actionName(action)
+	}
+
+	for _, trigger := range workflow.Triggers {
+		labels[trigger.ID] = trigger.Label
+		if len(trigger.Label) == 0 {
+			labels[trigger.ID] = trigger.ID
+		}
+	}
+
+	return labels
+}
+
+func branchName(branch shuffle.Branch, labels map[string]string) string {
+	source, destination := labels[branch.SourceID], labels[branch.DestinationID]
+	if len(source) == 0 {
+		source = branch.SourceID
+	}
+
+	if len(destination) == 0 {
+		destination = branch.DestinationID
+	}
+
+	return fmt.Sprintf("%s -> %s", source, destination)
+}
+
+func conditionString(condition shuffle.Condition) string {
+	operator := condition.Condition.Value
+	if condition.Condition.Configuration {
+		operator = "not " + operator
+	}
+
+	return fmt.Sprintf("%s %s %s", condition.Source.Value, operator, condition.Destination.Value)
+}
+
+// workflowDiffSection is one part of the diff, like the actions
+type workflowDiffSection struct {
+	Title string
+	Lines []string
+}
+
+// change adds a changed item with its details indented below it
+func (section *workflowDiffSection) change(kind, name string, details []string) {
+	section.Lines = append(section.Lines, fmt.Sprintf("%s %s", kind, name))
+	for _, detail := range details {
+		for _, line := range strings.Split(detail, "\n") {
+			section.Lines = append(section.Lines, "    "+line)
+		}
+	}
+}
+
+// diffWorkflows describes what changed between two versions of a workflow
+func diffWorkflows(before, after shuffle.Workflow) []workflowDiffSection {
+	beforeLabels, afterLabels := nodeLabels(before), nodeLabels(after)
+
+	general := workflowDiffSection{Title: "Workflow"}
+	details := fieldChanges(
+		flattenFields(before, "actions", "branches", "visual_branches", "triggers", "start"),
+		flattenFields(after, "actions", "branches", "visual_branches", "triggers", "start"),
+	)
+
+	beforeStart, afterStart := beforeLabels[workflowStart(before)], afterLabels[workflowStart(after)]
+	if beforeStart != afterStart {
+		details = append([]string{fmt.Sprintf("start: %s -> %s", shortValue(beforeStart), shortValue(afterStart))}, details...)
+	}
+
+	if len(details) > 0 {
+		general.change("~", after.Name, details)
+	}
+
+	actions := workflowDiffSection{Title: "Actions"}
+	actionSummary := func(action shuffle.Action) string {
+		return fmt.Sprintf("%s (%s %s %s)", actionName(action), action.AppName, action.AppVersion, action.Name)
+	}
+
+	actionKeys := func(workflowActions []shuffle.Action) ([]string, []string) {
+		ids, labels := []string{}, []string{}
+		for _, action := range workflowActions {
+			ids = append(ids, action.ID)
+			labels = append(labels, normalizeLabel(action.Label))
+		}
+
+		return ids, labels
+	}
+
+	beforeIds, beforeKeys := actionKeys(before.Actions)
+	afterIds, afterKeys := actionKeys(after.Actions)
+	pairs, removed, added := matchByIdOrLabel(beforeIds, afterIds, beforeKeys, afterKeys)
+
+	for _, index := range removed {
+		actions.change("-", actionSummary(before.Actions[index]), nil)
+	}
+
+	for _, index := range added {
+		actions.change("+", actionSummary(after.Actions[index]), nil)
+	}
+
+	for _, pair := range pairs {
+		beforeAction, afterAction := before.Actions[pair[0]], after.Actions[pair[1]]
+		details := fieldChanges(flattenFields(beforeAction, "parameters"), flattenFields(afterAction, "parameters"))
+		details = append(details, parameterChanges(actionName(afterAction), beforeAction.Parameters, afterAction.Parameters)...)
+		if len(details) > 0 {
+			actions.change("~", actionName(afterAction), details)
+		}
+	}
+
+	branches := workflowDiffSection{Title: "Branches"}
+	branchKeys := func(workflowBranches []shuffle.Branch, labels map[string]string) ([]string, []string) {
+		ids, names := []string{}, []string{}
+		for _, branch := range workflowBranches {
+			ids = append(ids, branch.ID)
+			names = append(names, branchName(branch, labels))
+		}
+
+		return ids, names
+	}
+
+	beforeIds, beforeKeys = branchKeys(before.Branches, beforeLabels)
+	afterIds, afterKeys = branchKeys(after.Branches, afterLabels)
+	pairs, removed, added = matchByIdOrLabel(beforeIds, afterIds, beforeKeys, afterKeys)
+
+	for _, index := range removed {
+		branches.change("-", branchName(before.Branches[index], beforeLabels), nil)
+	}
+
+	for _, index := range added {
+		branch := after.Branches[index]
+		conditions := []string{}
+		for _, condition := range branch.Conditions {
+			conditions = append(conditions, "if "+conditionString(condition))
+		}
+
+		branches.change("+", branchName(branch, afterLabels), conditions)
+	}
+
+	for _, pair := range pairs {
+		beforeBranch, afterBranch := before.Branches[pair[0]], after.Branches[pair[1]]
+		details := []string{}
+		if beforeName := branchName(beforeBranch, beforeLabels); beforeName != branchName(afterBranch, afterLabels) {
+			details = append(details, fmt.Sprintf("was %s", beforeName))
+		}
+
+		beforeConditions, afterConditions := []string{}, []string{}
+		for _, condition := range beforeBranch.Conditions {
+			beforeConditions = append(beforeConditions, conditionString(condition))
+		}
+
+		for _, condition := range afterBranch.Conditions {
+			afterConditions = append(afterConditions, conditionString(condition))
+		}
+
+		for _, op := range diffLines(beforeConditions, afterConditions) {
+			if op.Kind != ' ' && len(op.Line) > 0 {
+				details = append(details, fmt.Sprintf("%c if %s", op.Kind, op.Line))
+			}
+		}
+
+		details = append(details, fieldChanges(
+			flattenFields(beforeBranch, "conditions", "id", "source_id", "destination_id"),
+			flattenFields(afterBranch, "conditions", "id", "source_id", "destination_id"),
+		)...)
+
+		if len(details) > 0 {
+			branches.change("~", branchName(afterBranch, afterLabels), details)
+		}
+	}
+
+	triggers := workflowDiffSection{Title: "Triggers"}
+	triggerSummary := func(trigger shuffle.Trigger) string {
+		return fmt.Sprintf("%s (%s, %s)", trigger.Label, trigger.TriggerType, trigger.Status)
+	}
+
+	triggerKeys := func(workflowTriggers []shuffle.Trigger) ([]string, []string) {
+		ids, labels := []string{}, []string{}
+		for _, trigger := range workflowTriggers {
+			ids = append(ids, trigger.ID)
+			labels = append(labels, normalizeLabel(trigger.Label))
+		}
+
+		return ids, labels
+	}
+
+	beforeIds, beforeKeys = triggerKeys(before.Triggers)
+	afterIds, afterKeys = triggerKeys(after.Triggers)
+	pairs, removed, added = matchByIdOrLabel(beforeIds, afterIds, beforeKeys, afterKeys)
+
+	for _, index := range removed {
+		triggers.change("-", triggerSummary(before.Triggers[index]), nil)
+	}
+
+	for _, index := range added {
+		triggers.change("+", triggerSummary(after.Triggers[index]), nil)
+	}
+
+	for _, pair := range pairs {
+		beforeTrigger, afterTrigger := before.Triggers[pair[0]], after.Triggers[pair[1]]
+		details := fieldChanges(flattenFields(beforeTrigger, "parameters"), flattenFields(afterTrigger, "parameters"))
+		details = append(details, parameterChanges(afterTrigger.Label, beforeTrigger.Parameters, afterTrigger.Parameters)...)
+		if len(details) > 0 {
+			triggers.change("~", afterTrigger.Label, details)
+		}
+	}
+
+	sections := []workflowDiffSection{}
+	for _, section := range []workflowDiffSection{general, actions, branches, triggers} {
+		if len(section.Lines) > 0 {
+			sections = append(sections, section)
+		}
+	}
+
+	return sections
+}
+
+var diffWorkflowCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Shows what changed between two workflows: diff <file|id> <file|id>. With one file, compares the workflow in Shuffle to it",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] Use diff <file|id> <file|id>, or diff <file> to compare with the workflow in Shuffle.")
+			return
+		}
+
+		after, err := loadWorkflow(args[len(args)-1])
+		if err != nil {
+			log.Printf("[ERROR] Problem loading workflow %s: %s", args[len(args)-1], err)
+			os.Exit(1)
+		}
+
+		var before shuffle.Workflow
+		if len(args) >= 2 {
+			before, err = loadWorkflow(args[0])
+		} else if len(after.ID) == 0 {
+			err = fmt.Errorf("%s has no workflow ID to get from Shuffle", args[0])
+		} else {
+			before, err = GetWorkflow(after.ID)
+		}
+
+		if err != nil {
+			log.Printf("[ERROR] Problem loading workflow to compare with: %s", err)
+			os.Exit(1)
+		}
+
+		sections := diffWorkflows(before, after)
+		if len(sections) == 0 {
+			log.Println("[INFO] No differences")
+			return
+		}
+
+		for _, section := range sections {
+			fmt.Printf("%s:\n", section.Title)
+			for _, line := range section.Lines {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+	},
+}
+
+func init() {
+	workflowCmd.AddCommand(diffWorkflowCmd)
+
+	diffWorkflowCmd.Flags().BoolVar(&diffAllFields, "all", false, "Also show changes to positions, timestamps, validation state and images")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+func TestFlattenFields(t *testing.T) {
+	value := map[string]interface{}{
+		"name":    "test",
+		"enabled": false,
+		"retries": 0,
+		"missing": nil,
+		"tags":    []string{"a", "b"},
+		"variables": []map[string]interface{}{
+			{"id": "v1", "value": "x"},
+			{"name": "api_url", "value": "y"},
+			{"label": "Only label", "value": "z"},
+			{"value": "no key"},
+			{"id": "v1", "value": "duplicate"},
+		},
+		"nested": map[string]interface{}{"position": map[string]int{"x": 1}},
+	}
+
+	expected := map[string]string{
+		"name":                        "test",
+		"enabled":                     "false",
+		"retries":                     "0",
+		"tags.0":                      "a",
+		"tags.1":                      "b",
+		"variables[v1].id":            "v1",
+		"variables[v1].value":         "x",
+		"variables[api_url].name":     "api_url",
+		"variables[api_url].value":    "y",
+		"variables[Only label].label": "Only label",
+		"variables[Only label].value": "z",
+		"variables.3.value":           "no key",
+		"variables.4.id":              "v1",
+		"variables.4.value":           "duplicate",
+		"nested.position.x":           "1",
+	}
+
+	fields := flattenFields(value, "skipped")
+	for path, want := range expected {
+		if fields[path] != want {
+			t.Errorf("flattenFields[%q] = %q, expected %q", path, fields[path], want)
+		}
+	}
+
+	if len(fields) != len(expected) {
+		t.Errorf("flattenFields = %v, expected %d fields", fields, len(expected))
+	}
+
+	if _, found := flattenFields(value, "name")["name"]; found {
+		t.Errorf("flattenFields should leave out skipped top level fields")
+	}
+}
+
+func TestFieldChangesKeepsFalsyValues(t *testing.T) {
+	before := flattenFields(shuffle.Trigger{ID: "t1", Label: "Webhook", Status: "running"})
+	after := flattenFields(shuffle.Trigger{ID: "t1", Label: "Webhook", Status: ""})
+	changes := fieldChanges(before, after)
+	if len(changes) != 1 || changes[0] != `status: "running" -> ""` {
+		t.Errorf("fieldChanges = %v", changes)
+	}
+
+	before = flattenFields(shuffle.Branch{ID: "b1", Decorator: true})
+	after = flattenFields(shuffle.Branch{ID: "b1", Decorator: false})
+	changes = fieldChanges(before, after)
+	if len(changes) != 1 || changes[0] != `decorator: "true" -> "false"` {
+		t.Errorf("fieldChanges = %v, expected true -> false", changes)
+	}
+}
+
+func diffTestWorkflow() shuffle.Workflow {
+	return shuffle.Workflow{
+		ID:    "wf1",
+		Name:  "Alerts",
+		Start: "a1",
+		Actions: []shuffle.Action{
+			{ID: "a1", Label: "Get Alerts", AppName: "Http", AppVersion: "1.4.0", Name: "GET", Parameters: []shuffle.WorkflowAppActionParameter{
+				{Name: "url", Value: "https://example.com/alerts"},
+				{Name: "verify", Value: "true"},
+			}},
+			{ID: "a2", Label: "Notify", AppName: "Email", AppVersion: "1.3.0", Name: "send_email"},
+			{ID: "a3", Label: "Old Step", AppName: "Tools", AppVersion: "1.2.0", Name: "repeat_back_to_me"},
+		},
+		Branches: []shuffle.Branch{
+			{ID: "b1", SourceID: "a1", DestinationID: "a2", Conditions: []shuffle.Condition{{
+				Source:      shuffle.WorkflowAppActionParameter{Value: "$get_alerts.status"},
+				Condition:   shuffle.WorkflowAppActionParameter{Value: "equals"},
+				Destination: shuffle.WorkflowAppActionParameter{Value: "200"},
+			}}},
+			{ID: "b2", SourceID: "a1", DestinationID: "a3"},
+		},
+		Triggers: []shuffle.Trigger{
+			{ID: "t1", Label: "Webhook", TriggerType: "WEBHOOK", Status: "running"},
+			{ID: "t2", Label: "Daily", TriggerType: "SCHEDULE", Status: "running"},
+		},
+	}
+}
+
+func diffLinesBySection(sections []workflowDiffSection) map[string]string {
+	lines := map[string]string{}
+	for _, section := range sections {
+		lines[section.Title] = strings.Join(section.Lines, "\n")
+	}
+
+	return lines
+}
+
+func TestDiffWorkflows(t *testing.T) {
+	before := diffTestWorkflow()
+	after := diffTestWorkflow()
+
+	// Actions: change one, remove one, add one
+	after.Actions[0].AppVersion = "1.5.0"
+	after.Actions[0].Position = shuffle.Position{X: 100, Y: 200}
+	after.Actions[0].Parameters = []shuffle.WorkflowAppActionParameter{
+		{Name: "url", Value: "https://example.com/alerts?limit=10"},
+		{Name: "verify", Value: "true"},
+		{Name: "timeout", Value: "30"},
+	}
+	after.Actions = []shuffle.Action{after.Actions[0], after.Actions[1], {ID: "a4", Label: "Close Ticket", AppName: "Jira", AppVersion: "1.0.0", Name: "close"}}
+
+	// Branches: change a condition, remove one, add one
+	after.Branches = []shuffle.Branch{
+		{ID: "b1", SourceID: "a1", DestinationID: "a2", Conditions: []shuffle.Condition{{
+			Source:      shuffle.WorkflowAppActionParameter{Value: "$get_alerts.status"},
+			Condition:   shuffle.WorkflowAppActionParameter{Value: "equals", Configuration: true},
+			Destination: shuffle.WorkflowAppActionParameter{Value: "200"},
+		}}},
+		{ID: "b3", SourceID: "a2", DestinationID: "a4"},
+	}
+
+	// Triggers: stop one, remove one, add one
+	after.Triggers = []shuffle.Trigger{
+		{ID: "t1", Label: "Webhook", TriggerType: "WEBHOOK", Status: "stopped"},
+		{ID: "t3", Label: "Form", TriggerType: "USERINPUT", Status: "running"},
+	}
+
+	sections := diffWorkflows(before, after)
+	lines := diffLinesBySection(sections)
+
+	expected := map[string][]string{
+		"Actions": {
+			"- Old Step (Tools 1.2.0 repeat_back_to_me)",
+			"+ Close Ticket (Jira 1.0.0 close)",
+			"~ Get Alerts",
+			`    app_version: "1.4.0" -> "1.5.0"`,
+			`    parameter url: "https://example.com/alerts" -> "https://example.com/alerts?limit=10"`,
+			`    parameter timeout added: "30"`,
+		},
+		"Branches": {
+			"- Get Alerts -> Old Step",
+			"+ Notify -> Close Ticket",
+			"~ Get Alerts -> Notify",
+			"    - if $get_alerts.status equals 200",
+			"    + if $get_alerts.status not equals 200",
+		},
+		"Triggers": {
+			"- Daily (SCHEDULE, running)",
+			"+ Form (USERINPUT, running)",
+			"~ Webhook",
+			`    status: "running" -> "stopped"`,
+		},
+	}
+
+	for title, wanted := range expected {
+		for _, line := range wanted {
+			if !strings.Contains(lines[title]+"\n", line+"\n") {
+				t.Errorf("%s section is missing %q:\n%s", title, line, lines[title])
+			}
+		}
+	}
+
+	if strings.Contains(lines["Actions"], "position") || strings.Contains(lines["Actions"], "Notify") || strings.Contains(lines["Actions"], "verify") {
+		t.Errorf("Actions section has unchanged or volatile fields:\n%s", lines["Actions"])
+	}
+
+	if _, found := lines["Workflow"]; found {
+		t.Errorf("Workflow section should be empty:\n%s", lines["Workflow"])
+	}
+
+	if sections := diffWorkflows(before, diffTestWorkflow()); len(sections) != 0 {
+		t.Errorf("diffWorkflows of the same workflow = %v, expected no sections", sections)
+	}
+}
+
+func TestDiffWorkflowsMatchesActionsByLabel(t *testing.T) {
+	before := diffTestWorkflow()
+	after := diffTestWorkflow()
+
+	// A re-imported workflow gets new IDs, but keeps its labels
+	for index := range after.Actions {
+		after.Actions[index].ID = "new-" + after.Actions[index].ID
+	}
+
+	after.Start = "new-a1"
+	for index := range after.Branches {
+		after.Branches[index].ID = "new-" + after.Branches[index].ID
+		after.Branches[index].SourceID = "new-" + after.Branches[index].SourceID
+		after.Branches[index].DestinationID = "new-" + after.Branches[index].DestinationID
+	}
+
+	lines := diffLinesBySection(diffWorkflows(before, after))
+	if strings.Contains(lines["Actions"], "+ ") || strings.Contains(lines["Actions"], "- ") {
+		t.Errorf("actions should be matched by label:\n%s", lines["Actions"])
+	}
+
+	if strings.Contains(lines["Branches"], "+ ") || strings.Contains(lines["Branches"], "- ") {
+		t.Errorf("branches should be matched by their labels:\n%s", lines["Branches"])
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+)
+
+var lintReportPath string
+
+// Only references that start with a letter are checked, so e.g. "$5" isn't one
+var lintReferenceRegex = regexp.MustCompile(`\$[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z0-9_#\-]+)*`)
+
+// References that are always available in a workflow
+var builtinReferences = []string{"exec", "shuffle_cache"}
+
+// Parameters holding scripts, where $ is usually the script's own syntax
+var codeParameterNames = map[string]bool{"code": true, "script": true}
+
+// actionName is how findings refer to an action
+func actionName(action shuffle.Action) string {
+	if len(action.Label) > 0 {
+		return action.Label
+	}
+
+	return action.ID
+}
+
+// workflowStart is the start node ID, from the workflow or the action marked
+// as start node
+func workflowStart(workflow shuffle.Workflow) string {
+	if len(workflow.Start) > 0 {
+		return workflow.Start
+	}
+
+	for _, action := range workflow.Actions {
+		if action.IsStartNode {
+			return action.ID
+		}
+	}
+
+	return ""
+}
+
+// lintStructure checks the start node, branches, reachability and cycles
+func lintStructure(workflow shuffle.Workflow, source string) []Finding {
+	findings := []Finding{}
+	actions := map[string]shuffle.Action{}
+	nodes := map[string]bool{}
+	for _, action := range workflow.Actions {
+		actions[action.ID] = action
+		nodes[action.ID] = true
+	}
+
+	for _, trigger := range workflow.Triggers {
+		nodes[trigger.ID] = true
+	}
+
+	start := workflowStart(workflow)
+	if len(start) == 0 {
+		findings = append(findings, Finding{Rule: "WF001", Severity: severityError, File: source, Message: "no start node"})
+	} else if _, ok := actions[start]; !ok {
+		findings = append(findings, Finding{Rule: "WF001", Severity: severityError, File: source, Message: fmt.Sprintf("start node %s is not an action in the workflow", start)})
+	}
+
+	for _, branch := range workflow.Branches {
+		for _, end := range []struct{ side, id string }{{"source", branch.SourceID}, {"destination", branch.DestinationID}} {
+			if !nodes[end.id] {
+				findings = append(findings, Finding{Rule: "WF002", Severity: severityError, File: source, Message: fmt.Sprintf("branch %s has %s %s, which is not in the workflow", branch.ID, end.side, end.id)})
+			}
+		}
+	}
+
+	// Everything has to be reachable from the start node or a trigger
+	reachable := map[string]bool{}
+	queue := []string{}
+	if _, ok := actions[start]; ok {
+		queue = append(queue, start)
+	}
+
+	for _, trigger := range workflow.Triggers {
+		queue = append(queue, trigger.ID)
+	}
+
+	for _, id := range queue {
+		reachable[id] = true
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, branch := range workflow.Branches {
+			if branch.SourceID == current && nodes[branch.DestinationID] && !reachable[branch.DestinationID] {
+				reachable[branch.DestinationID] = true
+				queue = append(queue, branch.DestinationID)
+			}
+		}
+	}
+
+	if _, ok := actions[start]; ok {
+		for _, action := range workflow.Actions {
+			if !reachable[action.ID] {
+				findings = append(findings, Finding{Rule: "WF003", Severity: severityWarning, File: source, Message: fmt.Sprintf("action %s is not reachable from the start node or a trigger", actionName(action))})
+			}
+		}
+	}
+
+	return append(findings, lintCycles(workflow, nodes, source)...)
+}
+
+// lintCycles reports each loop in the branches once
+func lintCycles(workflow shuffle.Workflow, nodes map[string]bool, source string) []Finding {
+	findings := []Finding{}
+	names := map[string]string{}
+	for _, action := range workflow.Actions {
+		names[action.ID] = actionName(action)
+	}
+
+	for _, trigger := range workflow.Triggers {
+		names[trigger.ID] = trigger.Label
+	}
+
+	children := map[string][]string{}
+	for _, branch := range workflow.Branches {
+		if nodes[branch.SourceID] && nodes[branch.DestinationID] {
+			children[branch.SourceID] = append(children[branch.SourceID], branch.DestinationID)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := map[string]int{}
+	path := []string{}
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		path = append(path, id)
+		for _, child := range children[id] {
+			if state[child] == visiting {
+				loop := []string{}
+				for index := len(path) - 1; index >= 0; index-- {
+					loop = append([]string{names[path[index]]}, loop...)
+					if path[index] == child {
+						break
+					}
+				}
+
+				loop = append(loop, names[child])
+				findings = append(findings, Finding{Rule: "WF007", Severity: severityError, File: source, Message: fmt.Sprintf("cycle %s", strings.Join(loop, " -> "))})
+			} else if state[child] == unvisited {
+				visit(child)
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[id] = visited
+	}
+
+	ids := []string{}
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	for _, id := range ids {
+		if state[id] == unvisited {
+			visit(id)
+		}
+	}
+
+	return findings
+}
+
+// lintReferences checks that $references in parameters and branch
+// conditions point to an action, trigger, variable or a built-in. Multiline
+// and code parameters are skipped, as shell and other scripts use $ too.
+func lintReferences(workflow shuffle.Workflow, source string) []Finding {
+	findings := []Finding{}
+	known := map[string]bool{}
+	for _, name := range builtinReferences {
+		known[name] = true
+	}
+
+	for _, action := range workflow.Actions {
+		known[normalizeLabel(action.Label)] = true
+	}
+
+	for _, trigger := range workflow.Triggers {
+		known[normalizeLabel(trigger.Label)] = true
+	}
+
+	for _, variable := range append(workflow.WorkflowVariables, workflow.ExecutionVariables...) {
+		known[normalizeLabel(variable.Name)] = true
+	}
+
+	check := func(value, location string) {
+		for _, reference := range lintReferenceRegex.FindAllString(value, -1) {
+			name := strings.ToLower(strings.Split(strings.TrimPrefix(reference, "$"), ".")[0])
+			if !known[name] {
+				findings = append(findings, Finding{Rule: "WF004", Severity: severityWarning, File: source, Message: fmt.Sprintf("%s references %s, but there is no action, trigger or variable called %s", location, reference, name)})
+			}
+		}
+	}
+
+	for _, action := range workflow.Actions {
+		for _, param := range action.Parameters {
+			if param.Multiline || codeParameterNames[strings.ToLower(param.Name)] {
+				continue
+			}
+
+			check(param.Value, fmt.Sprintf("parameter %s of %s", param.Name, actionName(action)))
+		}
+	}
+
+	for _, branch := range workflow.Branches {
+		for _, condition := range branch.Conditions {
+			check(condition.Source.Value, fmt.Sprintf("a condition on branch %s", branch.ID))
+			check(condition.Destination.Value, fmt.Sprintf("a condition on branch %s", branch.ID))
+		}
+	}
+
+	return findings
+}
+
+// lintParameters finds required parameters without a value. Fields filled
+// from an authentication are skipped.
+func lintParameters(workflow shuffle.Workflow, source string) []Finding {
+	findings := []Finding{}
+	for _, action := range workflow.Actions {
+		for _, param := range action.Parameters {
+			if !param.Required || len(strings.TrimSpace(param.Value)) > 0 {
+				continue
+			}
+
+			if param.Configuration && len(action.AuthenticationId) > 0 {
+				continue
+			}
+
+			findings = append(findings, Finding{Rule: "WF006", Severity: severityError, File: source, Message: fmt.Sprintf("required parameter %s of %s is empty", param.Name, actionName(action))})
+		}
+	}
+
+	return findings
+}
+
+// lintApps checks that every app and version the actions use is available
+// in the org
+func lintApps(workflow shuffle.Workflow, apps []shuffle.WorkflowApp, source string) []Finding {
+	findings := []Finding{}
+	ids := map[string]bool{}
+	versions := map[string][]string{}
+	for _, app := range apps {
+		ids[app.ID] = true
+		versions[normalizeLabel(app.Name)] = append(versions[normalizeLabel(app.Name)], app.AppVersion)
+	}
+
+	reported := map[string]bool{}
+	for _, action := range workflow.Actions {
+		key := fmt.Sprintf("%s %s", action.AppName, action.AppVersion)
+		if len(action.AppName) == 0 || reported[key] || ids[action.AppID] {
+			continue
+		}
+
+		available := versions[normalizeLabel(action.AppName)]
+		hasVersion := false
+		for _, version := range available {
+			hasVersion = hasVersion || version == action.AppVersion
+		}
+
+		if hasVersion {
+			continue
+		}
+
+		reported[key] = true
+		message := fmt.Sprintf("app %s %s used by %s is not available in the org", action.AppName, action.AppVersion, actionName(action))
+		if len(available) > 0 {
+			sort.Strings(available)
+			message += fmt.Sprintf(". Available versions: %s", strings.Join(available, ", "))
+		}
+
+		findings = append(findings, Finding{Rule: "WF005", Severity: severityError, File: source, Message: message})
+	}
+
+	return findings
+}
+
+// lintWorkflow runs every check. Without apps the org checks are skipped.
+func lintWorkflow(workflow shuffle.Workflow, apps []shuffle.WorkflowApp, source string) []Finding {
+	findings := lintStructure(workflow, source)
+	findings = append(findings, lintReferences(workflow, source)...)
+	findings = append(findings, lintParameters(workflow, source)...)
+	if apps != nil {
+		findings = append(findings, lintApps(workflow, apps, source)...)
+	}
+
+	return findings
+}
+
+var lintWorkflowCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Checks a workflow for problems before it is run or uploaded: lint <file|id>",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No workflow provided. Use a workflow JSON file or ID.")
+			return
+		}
+
+		workflow, err := loadWorkflow(args[0])
+		if err != nil {
+			log.Printf("[ERROR] Problem loading workflow: %s", err)
+			os.Exit(1)
+		}
+
+		var apps []shuffle.WorkflowApp
+		if len(apikey) > 0 {
+			apps, err = GetApps()
+			if err != nil {
+				log.Printf("[ERROR] Problem getting the apps in the org: %s", err)
+				os.Exit(uploadExitCode(err))
+			}
+		} else {
+			log.Println("[INFO] Not checking apps against the org. Set SHUFFLE_APIKEY to do so.")
+		}
+
+		findings := lintWorkflow(workflow, apps, args[0])
+		report := LintReport{Workflow: args[0], Passed: !hasErrorFindings(findings), Findings: findings}
+		if len(lintReportPath) > 0 {
+			writeReport(lintReportPath, report)
+		}
+
+		logFindings(findings)
+		if !report.Passed {
+			log.Printf("[ERROR] Workflow %s failed linting with %d findings", args[0], len(findings))
+			os.Exit(exitValidationFailed)
+		}
+
+		log.Printf("[INFO] Workflow %s looks good (%d warnings)", args[0], len(findings))
+	},
+}
+
+func init() {
+	workflowCmd.AddCommand(lintWorkflowCmd)
+
+	lintWorkflowCmd.Flags().StringVar(&lintReportPath, "report", "", "Write a JSON report of the findings to a file")
+}
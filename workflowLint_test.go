@@ -0,0 +1,241 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+// testLintWorkflow is a valid workflow: a trigger and start node feeding an
+// action that uses both
+func testLintWorkflow() shuffle.Workflow {
+	return shuffle.Workflow{
+		Start: "a1",
+		Actions: []shuffle.Action{
+			{ID: "a1", Label: "Get Alerts", AppName: "Http", AppVersion: "1.4.0", Parameters: []shuffle.WorkflowAppActionParameter{
+				{Name: "url", Value: "https://example.com/$exec.path", Required: true},
+			}},
+			{ID: "a2", Label: "Send", AppName: "Http", AppVersion: "1.4.0", Parameters: []shuffle.WorkflowAppActionParameter{
+				{Name: "body", Value: "$get_alerts.items $webhook_1 $api_url $shuffle_cache.key"},
+			}},
+		},
+		Triggers: []shuffle.Trigger{{ID: "t1", Label: "Webhook 1"}},
+		Branches: []shuffle.Branch{
+			{ID: "b1", SourceID: "a1", DestinationID: "a2"},
+			{ID: "b2", SourceID: "t1", DestinationID: "a1"},
+		},
+		WorkflowVariables: []shuffle.Variable{{Name: "api_url", Value: "https://example.com"}},
+	}
+}
+
+func testLintApps() []shuffle.WorkflowApp {
+	return []shuffle.WorkflowApp{{ID: "http-1", Name: "http", AppVersion: "1.4.0"}, {ID: "http-2", Name: "http", AppVersion: "1.3.0"}}
+}
+
+func findingRules(findings []Finding) []string {
+	rules := []string{}
+	for _, finding := range findings {
+		rules = append(rules, finding.Rule)
+	}
+
+	sort.Strings(rules)
+	return rules
+}
+
+func TestLintWorkflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(workflow *shuffle.Workflow)
+		expected []string
+		message  string
+	}{
+		{
+			name:     "valid workflow",
+			change:   func(workflow *shuffle.Workflow) {},
+			expected: []string{},
+		},
+		{
+			name: "start node from IsStartNode",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Start = ""
+				workflow.Actions[0].IsStartNode = true
+			},
+			expected: []string{},
+		},
+		{
+			name:     "missing start node",
+			change:   func(workflow *shuffle.Workflow) { workflow.Start = "" },
+			expected: []string{"WF001"},
+			message:  "no start node",
+		},
+		{
+			name:     "start node that isn't an action",
+			change:   func(workflow *shuffle.Workflow) { workflow.Start = "gone" },
+			expected: []string{"WF001"},
+			message:  "start node gone is not an action",
+		},
+		{
+			name: "dangling branch",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Branches = append(workflow.Branches, shuffle.Branch{ID: "b3", SourceID: "a2", DestinationID: "deleted"})
+			},
+			expected: []string{"WF002"},
+			message:  "branch b3 has destination deleted",
+		},
+		{
+			name: "unreachable action",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Actions = append(workflow.Actions, shuffle.Action{ID: "a3", Label: "Orphan"})
+			},
+			expected: []string{"WF003"},
+			message:  "action Orphan is not reachable",
+		},
+		{
+			name: "action only reachable from a trigger",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Actions = append(workflow.Actions, shuffle.Action{ID: "a3", Label: "Scheduled"})
+				workflow.Branches = append(workflow.Branches, shuffle.Branch{ID: "b3", SourceID: "t1", DestinationID: "a3"})
+			},
+			expected: []string{},
+		},
+		{
+			name: "cycle",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Branches = append(workflow.Branches, shuffle.Branch{ID: "b3", SourceID: "a2", DestinationID: "a1"})
+			},
+			expected: []string{"WF007"},
+			message:  "cycle Get Alerts -> Send -> Get Alerts",
+		},
+		{
+			name: "unknown label",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Actions[1].Parameters[0].Value = "$get_alert.items"
+			},
+			expected: []string{"WF004"},
+			message:  "references $get_alert.items, but there is no action, trigger or variable called get_alert",
+		},
+		{
+			name: "unknown label in a branch condition",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Branches[0].Conditions = []shuffle.Condition{{
+					Source:      shuffle.WorkflowAppActionParameter{Value: "$typo.status"},
+					Condition:   shuffle.WorkflowAppActionParameter{Value: "equals"},
+					Destination: shuffle.WorkflowAppActionParameter{Value: "200"},
+				}}
+			},
+			expected: []string{"WF004"},
+			message:  "a condition on branch b1",
+		},
+		{
+			name: "code, multiline and non-letter references are skipped",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Actions[1].Parameters = append(workflow.Actions[1].Parameters,
+					shuffle.WorkflowAppActionParameter{Name: "code", Value: "echo $HOME $PATH"},
+					shuffle.WorkflowAppActionParameter{Name: "Script", Value: "$x = 1"},
+					shuffle.WorkflowAppActionParameter{Name: "template", Value: "$unknown", Multiline: true},
+					shuffle.WorkflowAppActionParameter{Name: "price", Value: "costs $5"},
+				)
+			},
+			expected: []string{},
+		},
+		{
+			name: "empty required parameter",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Actions[0].Parameters[0].Value = "  "
+			},
+			expected: []string{"WF006"},
+			message:  "required parameter url of Get Alerts is empty",
+		},
+		{
+			name: "empty required parameter filled by authentication",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Actions[0].AuthenticationId = "auth-1"
+				workflow.Actions[0].Parameters = append(workflow.Actions[0].Parameters, shuffle.WorkflowAppActionParameter{Name: "apikey", Required: true, Configuration: true})
+			},
+			expected: []string{},
+		},
+		{
+			name: "missing app version",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Actions[0].AppVersion = "2.0.0"
+				workflow.Actions[1].AppVersion = "2.0.0"
+			},
+			expected: []string{"WF005"},
+			message:  "app Http 2.0.0 used by Get Alerts is not available in the org. Available versions: 1.3.0, 1.4.0",
+		},
+		{
+			name: "app matched by ID",
+			change: func(workflow *shuffle.Workflow) {
+				workflow.Actions[0].AppVersion = "2.0.0"
+				workflow.Actions[0].AppID = "http-1"
+				workflow.Actions[1].AppID = "http-1"
+			},
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		workflow := testLintWorkflow()
+		test.change(&workflow)
+		findings := lintWorkflow(workflow, testLintApps(), "workflow.json")
+
+		if rules := findingRules(findings); !reflect.DeepEqual(rules, test.expected) {
+			t.Errorf("%s: lintWorkflow = %v, expected %v", test.name, findings, test.expected)
+			continue
+		}
+
+		if len(test.message) > 0 && !strings.Contains(findings[0].Message, test.message) {
+			t.Errorf("%s: message = %q, expected it to contain %q", test.name, findings[0].Message, test.message)
+		}
+	}
+}
+
+func TestLintWorkflowSeverities(t *testing.T) {
+	expected := map[string]string{
+		"WF001": severityError,
+		"WF002": severityError,
+		"WF003": severityWarning,
+		"WF004": severityWarning,
+		"WF005": severityError,
+		"WF006": severityError,
+		"WF007": severityError,
+	}
+
+	workflow := testLintWorkflow()
+	workflow.Start = "gone"
+	workflow.Actions[0].AppVersion = "9.9.9"
+	workflow.Actions[0].Parameters[0].Value = ""
+	workflow.Actions[1].Parameters[0].Value = "$nothing"
+	workflow.Branches = append(workflow.Branches,
+		shuffle.Branch{ID: "b3", SourceID: "a2", DestinationID: "a1"},
+		shuffle.Branch{ID: "b4", SourceID: "a2", DestinationID: "deleted"},
+	)
+	workflow.Triggers = nil
+	workflow.Actions = append(workflow.Actions, shuffle.Action{ID: "a3", Label: "Orphan"})
+
+	seen := map[string]bool{}
+	for _, finding := range lintWorkflow(workflow, testLintApps(), "workflow.json") {
+		seen[finding.Rule] = true
+		if finding.Severity != expected[finding.Rule] {
+			t.Errorf("%s has severity %s, expected %s", finding.Rule, finding.Severity, expected[finding.Rule])
+		}
+	}
+
+	// WF003 needs a valid start node, so it can't show up together with WF001
+	for rule := range expected {
+		if !seen[rule] && rule != "WF003" {
+			t.Errorf("expected a %s finding", rule)
+		}
+	}
+}
+
+func TestLintWorkflowWithoutApps(t *testing.T) {
+	workflow := testLintWorkflow()
+	workflow.Actions[0].AppVersion = "2.0.0"
+	if findings := lintWorkflow(workflow, nil, "workflow.json"); len(findings) != 0 {
+		t.Errorf("lintWorkflow without apps = %v, expected the app check to be skipped", findings)
+	}
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuffle/shuffle-shared"
+	"github.com/spf13/cobra"
+)
+
+var workflowInputPath string
+var workflowAppsPath string
+var workflowStubsPath string
+var workflowOutputPath string
+
+// Matches $exec, $label and $label.field.#.sub references in parameters
+var workflowReferenceRegex = regexp.MustCompile(`\$[A-Za-z0-9_]+(\.[A-Za-z0-9_#\-]+)*`)
+
+// loadWorkflow reads a workflow from a JSON file, or fetches it by ID if no
+// such file exists
+func loadWorkflow(ref string) (shuffle.Workflow, error) {
+	workflow := shuffle.Workflow{}
+	data, err := ioutil.ReadFile(ref)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return workflow, err
+		}
+
+		log.Printf("[DEBUG] No file '%s'. Getting it as a workflow ID from %s", ref, uploadUrl)
+		return GetWorkflow(ref)
+	}
+
+	if err := json.Unmarshal(data, &workflow); err != nil {
+		return workflow, fmt.Errorf("Problem parsing workflow file %s: %w", ref, err)
+	}
+
+	return workflow, nil
+}
+
+// normalizeLabel makes an action label usable as a $reference, the same way
+// the Shuffle UI does
+func normalizeLabel(label string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(label)), " ", "_")
+}
+
+// findLocalApps indexes <app>/<version>/api.yaml folders by normalized name and version
+func findLocalApps(appsPath string) map[string]string {
+	apps := map[string]string{}
+	if len(appsPath) == 0 {
+		return apps
+	}
+
+	apiFiles, _ := filepath.Glob(filepath.Join(appsPath, "*", "*", "api.yaml"))
+	singleApiFiles, _ := filepath.Glob(filepath.Join(appsPath, "api.yaml"))
+	for _, apiFile := range append(apiFiles, singleApiFiles...) {
+		apiData, err := parseAPIYaml(apiFile)
+		if err != nil {
+			log.Printf("[WARNING] Skipping %s: %s", apiFile, err)
+			continue
+		}
+
+		folder := filepath.Dir(apiFile)
+		apps[localAppKey(apiData.Name, apiData.AppVersion)] = folder
+		if _, ok := apps[localAppKey(apiData.Name, "")]; !ok {
+			apps[localAppKey(apiData.Name, "")] = folder
+		}
+	}
+
+	return apps
+}
+
+func localAppKey(name, version string) string {
+	return fmt.Sprintf("%s_%s", strings.ReplaceAll(strings.ToLower(name), " ", "_"), version)
+}
+
+// workflowRunner executes a workflow locally, one action at a time
+type workflowRunner struct {
+	workflow  shuffle.Workflow
+	execution shuffle.WorkflowExecution
+	apps      map[string]string
+	stubs     map[string]string
+	results   map[string]shuffle.ActionResult
+}
+
+// resolveReference looks up a single $reference. The bool is false if it
+// doesn't point to anything.
+func (runner *workflowRunner) resolveReference(reference string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(reference, "$"), ".")
+	name := strings.ToLower(parts[0])
+
+	value := ""
+	found := false
+	if name == "exec" {
+		value, found = runner.execution.ExecutionArgument, true
+	} else {
+		for _, action := range runner.workflow.Actions {
+			if normalizeLabel(action.Label) != name {
+				continue
+			}
+
+			if result, ok := runner.results[action.ID]; ok {
+				value, found = result.Result, true
+			}
+		}
+
+		for _, variable := range append(runner.workflow.WorkflowVariables, runner.workflow.ExecutionVariables...) {
+			if !found && normalizeLabel(variable.Name) == name {
+				value, found = variable.Value, true
+			}
+		}
+	}
+
+	if !found {
+		return reference, false
+	}
+
+	if len(parts) == 1 {
+		return value, true
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return "", true
+	}
+
+	return jsonValueToString(walkJSONPath(parsed, parts[1:])), true
+}
+
+// walkJSONPath follows field names, list indexes (#0 or 0) and # to loop over a list
+func walkJSONPath(value interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+
+	key := path[0]
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return walkJSONPath(typed[key], path[1:])
+	case []interface{}:
+		if key == "#" {
+			items := []interface{}{}
+			for _, item := range typed {
+				items = append(items, walkJSONPath(item, path[1:]))
+			}
+
+			return items
+		}
+
+		index, err := strconv.Atoi(strings.TrimPrefix(key, "#"))
+		if err != nil || index < 0 || index >= len(typed) {
+			return nil
+		}
+
+		return walkJSONPath(typed[index], path[1:])
+	}
+
+	return nil
+}
+
+// jsonValueToString inserts strings as they are and anything else as JSON
+func jsonValueToString(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(data)
+}
+
+// resolveParameter replaces every $reference in a parameter value
+func (runner *workflowRunner) resolveParameter(value string) string {
+	return workflowReferenceRegex.ReplaceAllStringFunc(value, func(reference string) string {
+		resolved, found := runner.resolveReference(reference)
+		if !found {
+			log.Printf("[WARNING] Reference %s doesn't point to an executed action, $exec or variable", reference)
+		}
+
+		return resolved
+	})
+}
+
+// checkCondition evaluates one branch condition the same way Shuffle does
+func (runner *workflowRunner) checkCondition(condition shuffle.Condition) bool {
+	source := strings.TrimSpace(runner.resolveParameter(condition.Source.Value))
+	destination := strings.TrimSpace(runner.resolveParameter(condition.Destination.Value))
+	lowerSource := strings.ToLower(source)
+	lowerDestination := strings.ToLower(destination)
+
+	result := false
+	switch strings.ToLower(condition.Condition.Value) {
+	case "equals":
+		result = lowerSource == lowerDestination
+	case "does not equal":
+		result = lowerSource != lowerDestination
+	case "startswith":
+		result = strings.HasPrefix(lowerSource, lowerDestination)
+	case "endswith":
+		result = strings.HasSuffix(lowerSource, lowerDestination)
+	case "contains":
+		result = strings.Contains(lowerSource, lowerDestination)
+	case "contains_any_of":
+		for _, item := range strings.Split(lowerDestination, ",") {
+			if len(strings.TrimSpace(item)) > 0 && strings.Contains(lowerSource, strings.TrimSpace(item)) {
+				result = true
+				break
+			}
+		}
+	case "larger than", "less than":
+		sourceNumber, sourceErr := strconv.ParseFloat(source, 64)
+		destinationNumber, destinationErr := strconv.ParseFloat(destination, 64)
+		if sourceErr != nil || destinationErr != nil {
+			// Lists are compared by their length
+			var sourceList []interface{}
+			if json.Unmarshal([]byte(source), &sourceList) == nil && destinationErr == nil {
+				sourceNumber, sourceErr = float64(len(sourceList)), nil
+			}
+		}
+
+		if sourceErr == nil && destinationErr == nil {
+			if strings.ToLower(condition.Condition.Value) == "larger than" {
+				result = sourceNumber > destinationNumber
+			} else {
+				result = sourceNumber < destinationNumber
+			}
+		}
+	case "is empty":
+		result = len(source) == 0 || source == "[]" || source == "{}"
+	case "matches regex":
+		matched, err := regexp.MatchString(destination, source)
+		result = err == nil && matched
+	default:
+		log.Printf("[WARNING] Unsupported condition '%s'. Treating it as false.", condition.Condition.Value)
+	}
+
+	// The toggle in the UI negates the condition
+	if condition.Condition.Configuration {
+		result = !result
+	}
+
+	return result
+}
+
+// executionOrder returns the reachable actions from the start node in
+// topological order
+func executionOrder(workflow shuffle.Workflow) ([]shuffle.Action, error) {
+	start := workflow.Start
+	for _, action := range workflow.Actions {
+		if len(start) == 0 && action.IsStartNode {
+			start = action.ID
+		}
+	}
+
+	actions := map[string]shuffle.Action{}
+	for _, action := range workflow.Actions {
+		actions[action.ID] = action
+	}
+
+	if _, ok := actions[start]; !ok {
+		return nil, fmt.Errorf("start node '%s' is not an action in the workflow", start)
+	}
+
+	// Find what is reachable from the start node
+	reachable := map[string]bool{start: true}
+	queue := []string{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, branch := range workflow.Branches {
+			if branch.SourceID != current || reachable[branch.DestinationID] {
+				continue
+			}
+
+			if _, ok := actions[branch.DestinationID]; !ok {
+				continue
+			}
+
+			reachable[branch.DestinationID] = true
+			queue = append(queue, branch.DestinationID)
+		}
+	}
+
+	inDegree := map[string]int{}
+	for _, branch := range workflow.Branches {
+		if reachable[branch.SourceID] && reachable[branch.DestinationID] {
+			inDegree[branch.DestinationID] += 1
+		}
+	}
+
+	// Kahn's algorithm, keeping the order of workflow.Actions for ties
+	order := []shuffle.Action{}
+	done := map[string]bool{}
+	for len(order) < len(reachable) {
+		progressed := false
+		for _, action := range workflow.Actions {
+			if !reachable[action.ID] || done[action.ID] || inDegree[action.ID] > 0 {
+				continue
+			}
+
+			done[action.ID] = true
+			order = append(order, action)
+			progressed = true
+			for _, branch := range workflow.Branches {
+				if branch.SourceID == action.ID && reachable[branch.DestinationID] {
+					inDegree[branch.DestinationID] -= 1
+				}
+			}
+		}
+
+		if !progressed {
+			return order, fmt.Errorf("workflow has a cycle between the actions that are left")
+		}
+	}
+
+	return order, nil
+}
+
+// shouldRun checks the incoming branches of an action. It runs if it's the
+// start node, or if any parent succeeded with all branch conditions passing.
+func (runner *workflowRunner) shouldRun(action shuffle.Action) bool {
+	if action.ID == runner.execution.Start {
+		return true
+	}
+
+	for _, branch := range runner.workflow.Branches {
+		if branch.DestinationID != action.ID {
+			continue
+		}
+
+		parent, ok := runner.results[branch.SourceID]
+		if !ok || parent.Status != "SUCCESS" {
+			continue
+		}
+
+		passed := true
+		for _, condition := range branch.Conditions {
+			if !runner.checkCondition(condition) {
+				passed = false
+				break
+			}
+		}
+
+		if passed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runAction invokes an action through a local app folder, or a stub
+func (runner *workflowRunner) runAction(action shuffle.Action) shuffle.ActionResult {
+	result := shuffle.ActionResult{
+		Action:      action,
+		ExecutionId: runner.execution.ExecutionId,
+		StartedAt:   time.Now().Unix(),
+		Status:      "SUCCESS",
+	}
+
+	// Copy the parameters so the resolved values don't leak into the workflow
+	result.Action.Parameters = append([]shuffle.WorkflowAppActionParameter{}, action.Parameters...)
+	params := map[string]string{}
+	for index, param := range action.Parameters {
+		params[param.Name] = runner.resolveParameter(param.Value)
+		result.Action.Parameters[index].Value = params[param.Name]
+	}
+
+	label := normalizeLabel(action.Label)
+	stubKeys := []string{label, action.ID, fmt.Sprintf("%s.%s", normalizeLabel(action.AppName), action.Name)}
+	for _, key := range stubKeys {
+		if stub, ok := runner.stubs[key]; ok {
+			log.Printf("[INFO] Using stub '%s' for %s", key, action.Label)
+			result.Result = stub
+			result.CompletedAt = time.Now().Unix()
+			return result
+		}
+	}
+
+	folder, ok := runner.apps[localAppKey(action.AppName, action.AppVersion)]
+	if !ok {
+		folder, ok = runner.apps[localAppKey(action.AppName, "")]
+		if ok {
+			log.Printf("[WARNING] No local %s version %s. Using %s", action.AppName, action.AppVersion, folder)
+		}
+	}
+
+	if !ok {
+		log.Printf("[WARNING] %s %s is not available locally. Stubbing %s.", action.AppName, action.AppVersion, action.Label)
+		result.Result = `{"success": true, "reason": "Stubbed by shufflecli. The app is not available locally."}`
+		result.CompletedAt = time.Now().Unix()
+		return result
+	}
+
+	log.Printf("[INFO] Running %s (%s.%s) from %s", action.Label, action.AppName, action.Name, folder)
+	actionResult, err := runAppAction(folder, action.Name, params, "")
+	result.CompletedAt = time.Now().Unix()
+	if err != nil {
+		result.Status = "FAILURE"
+		result.Result = fmt.Sprintf(`{"success": false, "reason": %s}`, strconv.Quote(err.Error()))
+		return result
+	}
+
+	if !actionResult.Success {
+		result.Status = "FAILURE"
+		result.Result = fmt.Sprintf(`{"success": false, "reason": %s}`, strconv.Quote(actionResult.Error))
+		return result
+	}
+
+	result.Result = actionResult.Result
+	return result
+}
+
+// runWorkflow executes the workflow and returns it in the WorkflowExecution format
+func runWorkflow(workflow shuffle.Workflow, executionArgument string, apps map[string]string, stubs map[string]string) (shuffle.WorkflowExecution, error) {
+	execution := shuffle.WorkflowExecution{
+		Type:              "workflow",
+		Status:            "EXECUTING",
+		Start:             workflow.Start,
+		ExecutionArgument: executionArgument,
+		ExecutionId:       newUuid(),
+		ExecutionSource:   "shufflecli",
+		WorkflowId:        workflow.ID,
+		Workflow:          workflow,
+		StartedAt:         time.Now().Unix(),
+		OrgId:             orgId,
+		ExecutionOrg:      orgId,
+		Results:           []shuffle.ActionResult{},
+	}
+
+	order, err := executionOrder(workflow)
+	if err != nil {
+		execution.Status = "ABORTED"
+		return execution, err
+	}
+
+	execution.Start = order[0].ID
+	runner := &workflowRunner{
+		workflow:  workflow,
+		execution: execution,
+		apps:      apps,
+		stubs:     stubs,
+		results:   map[string]shuffle.ActionResult{},
+	}
+
+	for _, action := range order {
+		result := shuffle.ActionResult{
+			Action:      action,
+			ExecutionId: execution.ExecutionId,
+			StartedAt:   time.Now().Unix(),
+			CompletedAt: time.Now().Unix(),
+			Status:      "SKIPPED",
+		}
+
+		if runner.shouldRun(action) {
+			result = runner.runAction(action)
+		} else {
+			log.Printf("[INFO] Skipping %s: no parent succeeded with passing conditions", action.Label)
+		}
+
+		runner.results[action.ID] = result
+		runner.execution.Results = append(runner.execution.Results, result)
+		if result.Status != "SKIPPED" {
+			runner.execution.LastNode = action.ID
+			runner.execution.Result = result.Result
+		}
+
+		log.Printf("[INFO] %s: %s", action.Label, result.Status)
+		if result.Status == "FAILURE" && workflow.Configuration.ExitOnError {
+			runner.execution.Status = "ABORTED"
+			runner.execution.CompletedAt = time.Now().Unix()
+			return runner.execution, nil
+		}
+	}
+
+	runner.execution.Status = "FINISHED"
+	runner.execution.CompletedAt = time.Now().Unix()
+	return runner.execution, nil
+}
+
+var runWorkflowCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Runs a workflow locally from a file or workflow ID",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) <= 0 {
+			log.Println("[ERROR] No workflow provided. Use a workflow JSON file or a workflow ID.")
+			return
+		}
+
+		workflow, err := loadWorkflow(args[0])
+		if err != nil {
+			log.Printf("[ERROR] Problem loading workflow: %s", err)
+			os.Exit(1)
+		}
+
+		executionArgument := ""
+		if len(workflowInputPath) > 0 {
+			data, err := ioutil.ReadFile(workflowInputPath)
+			if err != nil {
+				log.Printf("[ERROR] Problem reading input %s: %s", workflowInputPath, err)
+				os.Exit(1)
+			}
+
+			executionArgument = strings.TrimSpace(string(data))
+		}
+
+		stubs := map[string]string{}
+		if len(workflowStubsPath) > 0 {
+			data, err := ioutil.ReadFile(workflowStubsPath)
+			if err != nil {
+				log.Printf("[ERROR] Problem reading stubs %s: %s", workflowStubsPath, err)
+				os.Exit(1)
+			}
+
+			rawStubs := map[string]interface{}{}
+			if err := json.Unmarshal(data, &rawStubs); err != nil {
+				log.Printf("[ERROR] Stubs must be a JSON object of label -> result: %s", err)
+				os.Exit(1)
+			}
+
+			for key, value := range rawStubs {
+				stubs[key] = jsonValueToString(value)
+			}
+		}
+
+		execution, err := runWorkflow(workflow, executionArgument, findLocalApps(workflowAppsPath), stubs)
+		if err != nil {
+			log.Printf("[ERROR] Problem running workflow: %s", err)
+			os.Exit(1)
+		}
+
+		output, err := json.MarshalIndent(execution, "", "  ")
+		if err != nil {
+			log.Printf("[ERROR] Problem marshalling execution: %s", err)
+			os.Exit(1)
+		}
+
+		if len(workflowOutputPath) > 0 {
+			if err := ioutil.WriteFile(workflowOutputPath, output, 0644); err != nil {
+				log.Printf("[ERROR] Problem writing %s: %s", workflowOutputPath, err)
+				os.Exit(1)
+			}
+
+			log.Printf("[INFO] Wrote execution to %s", workflowOutputPath)
+		} else {
+			fmt.Println(string(output))
+		}
+
+		log.Printf("[INFO] Workflow %s with %d results", execution.Status, len(execution.Results))
+	},
+}
+
+func init() {
+	workflowCmd.AddCommand(runWorkflowCmd)
+
+	runWorkflowCmd.Flags().StringVar(&workflowInputPath, "input", "", "File with the execution argument ($exec)")
+	runWorkflowCmd.Flags().StringVar(&workflowAppsPath, "apps", ".", "Folder with apps in the <app>/<version>/ layout")
+	runWorkflowCmd.Flags().StringVar(&workflowStubsPath, "stubs", "", "JSON file mapping action labels, IDs or app.action to stubbed results")
+	runWorkflowCmd.Flags().StringVar(&workflowOutputPath, "output", "", "Write the execution result to a file instead of stdout")
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shuffle/shuffle-shared"
+)
+
+func testBranch(source, destination string) shuffle.Branch {
+	return shuffle.Branch{ID: source + "-" + destination, SourceID: source, DestinationID: destination}
+}
+
+func actionIds(actions []shuffle.Action) []string {
+	ids := []string{}
+	for _, action := range actions {
+		ids = append(ids, action.ID)
+	}
+
+	return ids
+}
+
+func TestExecutionOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		workflow shuffle.Workflow
+		expected []string
+		err      string
+	}{
+		{
+			name: "chain in reverse list order",
+			workflow: shuffle.Workflow{
+				Start:    "a",
+				Actions:  []shuffle.Action{{ID: "c"}, {ID: "b"}, {ID: "a"}},
+				Branches: []shuffle.Branch{testBranch("a", "b"), testBranch("b", "c")},
+			},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name: "diamond waits for both parents",
+			workflow: shuffle.Workflow{
+				Start:    "a",
+				Actions:  []shuffle.Action{{ID: "a"}, {ID: "d"}, {ID: "c"}, {ID: "b"}},
+				Branches: []shuffle.Branch{testBranch("a", "b"), testBranch("a", "c"), testBranch("b", "d"), testBranch("c", "d")},
+			},
+			expected: []string{"a", "c", "b", "d"},
+		},
+		{
+			name: "unreachable actions are skipped",
+			workflow: shuffle.Workflow{
+				Start:    "a",
+				Actions:  []shuffle.Action{{ID: "a"}, {ID: "b"}, {ID: "orphan"}, {ID: "orphan_child"}},
+				Branches: []shuffle.Branch{testBranch("a", "b"), testBranch("orphan", "orphan_child"), testBranch("orphan", "b")},
+			},
+			expected: []string{"a", "b"},
+		},
+		{
+			name: "start node from IsStartNode",
+			workflow: shuffle.Workflow{
+				Actions:  []shuffle.Action{{ID: "b"}, {ID: "a", IsStartNode: true}},
+				Branches: []shuffle.Branch{testBranch("a", "b"), testBranch("a", "missing")},
+			},
+			expected: []string{"a", "b"},
+		},
+		{
+			name: "missing start node",
+			workflow: shuffle.Workflow{
+				Start:   "nope",
+				Actions: []shuffle.Action{{ID: "a"}},
+			},
+			err: "start node 'nope'",
+		},
+		{
+			name: "cycle",
+			workflow: shuffle.Workflow{
+				Start:    "a",
+				Actions:  []shuffle.Action{{ID: "a"}, {ID: "b"}, {ID: "c"}},
+				Branches: []shuffle.Branch{testBranch("a", "b"), testBranch("b", "c"), testBranch("c", "b")},
+			},
+			expected: []string{"a"},
+			err:      "cycle",
+		},
+	}
+
+	for _, test := range tests {
+		order, err := executionOrder(test.workflow)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: executionOrder error = %v, expected %q", test.name, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: executionOrder failed: %s", test.name, err)
+		}
+
+		if test.expected != nil && !reflect.DeepEqual(actionIds(order), test.expected) {
+			t.Errorf("%s: executionOrder = %v, expected %v", test.name, actionIds(order), test.expected)
+		}
+	}
+}
+
+func testRunner() *workflowRunner {
+	return &workflowRunner{
+		workflow: shuffle.Workflow{
+			Actions: []shuffle.Action{
+				{ID: "1", Label: "Get Alerts"},
+				{ID: "2", Label: "not_run"},
+			},
+			WorkflowVariables: []shuffle.Variable{{Name: "Api Url", Value: "https://example.com"}},
+		},
+		execution: shuffle.WorkflowExecution{ExecutionArgument: `{"ip": "10.0.0.1", "tags": ["a", "b"]}`},
+		results: map[string]shuffle.ActionResult{
+			"1": {Status: "SUCCESS", Result: `{"count": 2, "items": [{"id": "x"}, {"id": "y"}], "ok": true}`},
+		},
+	}
+}
+
+func TestResolveReference(t *testing.T) {
+	tests := []struct {
+		reference string
+		expected  string
+		found     bool
+	}{
+		{"$exec", `{"ip": "10.0.0.1", "tags": ["a", "b"]}`, true},
+		{"$exec.ip", "10.0.0.1", true},
+		{"$exec.tags", `["a","b"]`, true},
+		{"$exec.tags.#1", "b", true},
+		{"$exec.missing", "", true},
+		{"$get_alerts", `{"count": 2, "items": [{"id": "x"}, {"id": "y"}], "ok": true}`, true},
+		{"$GET_ALERTS.count", "2", true},
+		{"$get_alerts.ok", "true", true},
+		{"$get_alerts.items.0.id", "x", true},
+		{"$get_alerts.items.#.id", `["x","y"]`, true},
+		{"$get_alerts.items.5.id", "", true},
+		{"$api_url", "https://example.com", true},
+		{"$api_url.field", "", true},
+		{"$not_run", "$not_run", false},
+		{"$not_run.field", "$not_run.field", false},
+		{"$missing_label", "$missing_label", false},
+	}
+
+	runner := testRunner()
+	for _, test := range tests {
+		value, found := runner.resolveReference(test.reference)
+		if value != test.expected || found != test.found {
+			t.Errorf("resolveReference(%q) = %q, %v, expected %q, %v", test.reference, value, found, test.expected, test.found)
+		}
+	}
+}
+
+func TestResolveParameter(t *testing.T) {
+	runner := testRunner()
+	result := runner.resolveParameter("ip=$exec.ip count=$get_alerts.count missing=$missing")
+	if result != "ip=10.0.0.1 count=2 missing=$missing" {
+		t.Errorf("resolveParameter = %q", result)
+	}
+}
+
+func TestCheckCondition(t *testing.T) {
+	tests := []struct {
+		source      string
+		operator    string
+		destination string
+		negate      bool
+		expected    bool
+	}{
+		{"Hello", "equals", "hello", false, true},
+		{"Hello", "equals", "world", false, false},
+		{"Hello", "equals", "hello", true, false},
+		{"$exec.ip", "equals", "10.0.0.1", false, true},
+		{"Hello", "does not equal", "world", false, true},
+		{"Hello", "does not equal", "HELLO", false, false},
+		{"Hello world", "startswith", "hello", false, true},
+		{"Hello world", "startswith", "world", false, false},
+		{"Hello world", "endswith", "WORLD", false, true},
+		{"Hello world", "endswith", "hello", false, false},
+		{"Hello world", "contains", "lo wo", false, true},
+		{"Hello world", "contains", "bye", false, false},
+		{"Hello world", "contains", "bye", true, true},
+		{"malware found", "contains_any_of", "phishing, malware", false, true},
+		{"all clear", "contains_any_of", "phishing, malware,", false, false},
+		{"10", "larger than", "9.5", false, true},
+		{"9", "larger than", "10", false, false},
+		{"$get_alerts.items", "larger than", "1", false, true},
+		{"$get_alerts.count", "less than", "3", false, true},
+		{"abc", "less than", "3", false, false},
+		{"[1, 2, 3]", "less than", "2", false, false},
+		{"", "is empty", "", false, true},
+		{"[]", "is empty", "", false, true},
+		{"{}", "is empty", "", false, true},
+		{"$missing_label", "is empty", "", false, false},
+		{"value", "is empty", "", true, true},
+		{"abc123", "matches regex", `^[a-z]+\d+$`, false, true},
+		{"abc", "matches regex", `^\d+$`, false, false},
+		{"abc", "matches regex", `(`, false, false},
+		{"a", "unknown operator", "a", false, false},
+	}
+
+	runner := testRunner()
+	for _, test := range tests {
+		condition := shuffle.Condition{
+			Source:      shuffle.WorkflowAppActionParameter{Value: test.source},
+			Condition:   shuffle.WorkflowAppActionParameter{Value: test.operator, Configuration: test.negate},
+			Destination: shuffle.WorkflowAppActionParameter{Value: test.destination},
+		}
+
+		if result := runner.checkCondition(condition); result != test.expected {
+			t.Errorf("checkCondition(%q %s %q, negate=%v) = %v, expected %v", test.source, test.operator, test.destination, test.negate, result, test.expected)
+		}
+	}
+}